@@ -0,0 +1,339 @@
+package cas
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrAttributeMissing is returned by the typed getters of UserAttributes when the user has no value for the attribute.
+var ErrAttributeMissing = errors.New("cas: attribute missing")
+
+// AttributeError reports an attribute which is missing or can not be converted to the requested type.
+type AttributeError struct {
+	Name  string // Name of the attribute
+	Value string // Value which could not be converted, empty when the attribute is missing
+	Err   error  // ErrAttributeMissing or the conversion error
+}
+
+func (e *AttributeError) Error() string {
+	if errors.Is(e.Err, ErrAttributeMissing) {
+		return fmt.Sprintf("cas: attribute %s missing", e.Name)
+	}
+
+	return fmt.Sprintf("cas: attribute %s: invalid value %q: %v", e.Name, e.Value, e.Err)
+}
+
+func (e *AttributeError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeError lists every attribute which prevented UserAttributes.Decode from filling a struct.
+type DecodeError struct {
+	Missing   []string          // Names of required attributes the user has no value for
+	Malformed []*AttributeError // Attributes with values which could not be converted
+}
+
+func (e *DecodeError) Error() string {
+	var parts []string
+	if len(e.Missing) > 0 {
+		parts = append(parts, "missing "+strings.Join(e.Missing, ", "))
+	}
+
+	for _, m := range e.Malformed {
+		parts = append(parts, fmt.Sprintf("malformed %s %q: %v", m.Name, m.Value, m.Err))
+	}
+
+	return "cas: decoding attributes: " + strings.Join(parts, "; ")
+}
+
+// GetAll retrieves all values of an attribute.
+func (a UserAttributes) GetAll(name string) []string {
+	return a[name]
+}
+
+// GetBool retrieves the first value of an attribute as a bool, as understood by strconv.ParseBool.
+func (a UserAttributes) GetBool(name string) (bool, error) {
+	var v bool
+	err := a.getValue(name, reflect.ValueOf(&v).Elem(), "")
+	return v, err
+}
+
+// GetInt retrieves the first value of an attribute as an int.
+func (a UserAttributes) GetInt(name string) (int, error) {
+	var v int
+	err := a.getValue(name, reflect.ValueOf(&v).Elem(), "")
+	return v, err
+}
+
+// GetTime retrieves the first value of an attribute as a time in the layout, or time.RFC3339 when the layout is empty.
+func (a UserAttributes) GetTime(name, layout string) (time.Time, error) {
+	var v time.Time
+	err := a.getValue(name, reflect.ValueOf(&v).Elem(), layout)
+	return v, err
+}
+
+func (a UserAttributes) getValue(name string, v reflect.Value, layout string) error {
+	s, ok := a.first(name)
+	if !ok {
+		return &AttributeError{Name: name, Err: ErrAttributeMissing}
+	}
+
+	if err := setAttributeValue(v, s, layout); err != nil {
+		return &AttributeError{Name: name, Value: s, Err: err}
+	}
+
+	return nil
+}
+
+// first returns the first non-empty value of an attribute.
+func (a UserAttributes) first(name string) (string, bool) {
+	for _, v := range a[name] {
+		if v != "" {
+			return v, true
+		}
+	}
+
+	return "", false
+}
+
+// Decode fills the fields of the struct pointed to by into from the attributes named in their cas tags.
+//
+// The tag names the attribute and may be followed by options, layout and default consume the rest of the tag
+// so they must come last:
+//
+//	type Profile struct {
+//		Mail     string    `cas:"mail,required"`
+//		Groups   []string  `cas:"eduPersonAffiliation"`
+//		Employee int       `cas:"employeeNumber"`
+//		Active   bool      `cas:"active,default=true"`
+//		Joined   time.Time `cas:"joinDate,layout=2006-01-02"`
+//	}
+//
+// Strings, bools, numbers, time.Time (time.RFC3339 unless a layout is given), time.Duration and
+// encoding.TextUnmarshaler implementations are supported, as well as slices of them which receive every value
+// and pointers which stay nil when the attribute is missing. Fields without a cas tag are left untouched.
+//
+// The memberOf groups are not user attributes, the response parsers move them to AuthenticationResponse.MemberOf,
+// so read them with MemberOf instead of a cas:"memberOf" tag.
+//
+// A *DecodeError listing all missing and malformed attributes is returned when any field can not be filled.
+func (a UserAttributes) Decode(into any) error {
+	rv := reflect.ValueOf(into)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("cas: decoding attributes: expected a pointer to a struct, got %T", into)
+	}
+
+	rv = rv.Elem()
+	rt := rv.Type()
+
+	decodeErr := &DecodeError{}
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		tag, ok := field.Tag.Lookup("cas")
+		if !ok || tag == "-" || !field.IsExported() {
+			continue
+		}
+
+		opts, err := parseAttributeTag(tag)
+		if err != nil {
+			return fmt.Errorf("cas: decoding attributes: field %s: %w", field.Name, err)
+		}
+
+		values := a.nonEmpty(opts.name)
+		if len(values) == 0 && opts.hasDefault {
+			values = []string{opts.def}
+		}
+
+		if len(values) == 0 {
+			if opts.required {
+				decodeErr.Missing = append(decodeErr.Missing, opts.name)
+			}
+			continue
+		}
+
+		if err := decodeAttribute(rv.Field(i), values, opts.layout); err != nil {
+			var attrErr *AttributeError
+			if errors.As(err, &attrErr) {
+				attrErr.Name = opts.name
+				decodeErr.Malformed = append(decodeErr.Malformed, attrErr)
+				continue
+			}
+
+			return fmt.Errorf("cas: decoding attributes: field %s: %w", field.Name, err)
+		}
+	}
+
+	if len(decodeErr.Missing) > 0 || len(decodeErr.Malformed) > 0 {
+		return decodeErr
+	}
+
+	return nil
+}
+
+// nonEmpty returns the values of an attribute which are not empty.
+func (a UserAttributes) nonEmpty(name string) []string {
+	var values []string
+	for _, v := range a[name] {
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
+
+// attributeTag holds the parsed options of a cas struct tag.
+type attributeTag struct {
+	name       string
+	required   bool
+	layout     string
+	def        string
+	hasDefault bool
+}
+
+func parseAttributeTag(tag string) (attributeTag, error) {
+	name, rest, _ := strings.Cut(tag, ",")
+	opts := attributeTag{name: name}
+	if name == "" {
+		return opts, errors.New("tag has no attribute name")
+	}
+
+	for rest != "" {
+		var opt string
+		switch {
+		case strings.HasPrefix(rest, "layout="):
+			opts.layout, rest = strings.TrimPrefix(rest, "layout="), ""
+		case strings.HasPrefix(rest, "default="):
+			opts.def, opts.hasDefault, rest = strings.TrimPrefix(rest, "default="), true, ""
+		default:
+			opt, rest, _ = strings.Cut(rest, ",")
+			if opt != "required" {
+				return opts, fmt.Errorf("unknown tag option %q", opt)
+			}
+			opts.required = true
+		}
+	}
+
+	return opts, nil
+}
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// decodeAttribute sets the field from the values, slices receive every value, other fields the first.
+//
+// Conversion failures are returned as *AttributeError without a name, which the caller fills in.
+func decodeAttribute(v reflect.Value, values []string, layout string) error {
+	switch {
+	case v.Kind() == reflect.Pointer:
+		elem := reflect.New(v.Type().Elem())
+		if err := decodeAttribute(elem.Elem(), values, layout); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8:
+		slice := reflect.MakeSlice(v.Type(), len(values), len(values))
+		for i, s := range values {
+			if err := setAttributeValue(slice.Index(i), s, layout); err != nil {
+				return attributeValueError(s, err)
+			}
+		}
+		v.Set(slice)
+		return nil
+	}
+
+	if err := setAttributeValue(v, values[0], layout); err != nil {
+		return attributeValueError(values[0], err)
+	}
+
+	return nil
+}
+
+// unsupportedTypeError reports a field which can never be decoded, it is not wrapped in an *AttributeError.
+type unsupportedTypeError struct {
+	t reflect.Type
+}
+
+func (e *unsupportedTypeError) Error() string {
+	return fmt.Sprintf("unsupported type %s", e.t)
+}
+
+// attributeValueError wraps conversion failures of the value in an *AttributeError.
+func attributeValueError(s string, err error) error {
+	var unsupported *unsupportedTypeError
+	if errors.As(err, &unsupported) {
+		return err
+	}
+
+	return &AttributeError{Value: s, Err: err}
+}
+
+// setAttributeValue converts a single value into v.
+func setAttributeValue(v reflect.Value, s, layout string) error {
+	switch v.Type() {
+	case timeType:
+		if layout == "" {
+			layout = time.RFC3339
+		}
+
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	case durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return &unsupportedTypeError{t: v.Type()}
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package cas
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserAttributesGetters(t *testing.T) {
+	a := UserAttributes{
+		"mail":     {"enoch@example.com", "root@example.com"},
+		"active":   {"true"},
+		"uid":      {"", "1042"},
+		"joined":   {"2024-03-01"},
+		"modified": {"2024-03-01T10:00:00Z"},
+		"bad":      {"yes please"},
+		"empty":    {},
+	}
+
+	assert.Equal(t, []string{"enoch@example.com", "root@example.com"}, a.GetAll("mail"))
+	assert.Nil(t, a.GetAll("phone"))
+	assert.Equal(t, "", a.Get("empty"))
+
+	active, err := a.GetBool("active")
+	require.NoError(t, err)
+	assert.True(t, active)
+
+	uid, err := a.GetInt("uid")
+	require.NoError(t, err)
+	assert.Equal(t, 1042, uid)
+
+	joined, err := a.GetTime("joined", "2006-01-02")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), joined)
+
+	modified, err := a.GetTime("modified", "")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), modified)
+
+	_, err = a.GetBool("bad")
+	var attrErr *AttributeError
+	require.ErrorAs(t, err, &attrErr)
+	assert.Equal(t, "bad", attrErr.Name)
+	assert.Equal(t, "yes please", attrErr.Value)
+
+	_, err = a.GetInt("phone")
+	assert.ErrorIs(t, err, ErrAttributeMissing)
+	assert.EqualError(t, err, "cas: attribute phone missing")
+
+	_, err = a.GetInt("empty")
+	assert.ErrorIs(t, err, ErrAttributeMissing)
+}
+
+type decodedProfile struct {
+	Mail     string        `cas:"mail,required"`
+	Aliases  []string      `cas:"mail"`
+	Groups   []string      `cas:"affiliation"`
+	UID      uint32        `cas:"uid"`
+	Score    float64       `cas:"score"`
+	Active   bool          `cas:"active,default=true"`
+	Locale   string        `cas:"locale,default=en, GB"`
+	Joined   time.Time     `cas:"joined,layout=Mon, 02 Jan 2006"`
+	Session  time.Duration `cas:"session"`
+	Manager  *string       `cas:"manager"`
+	Address  net.IP        `cas:"ip"`
+	Ignored  string
+	Skipped  string `cas:"-"`
+	internal string `cas:"mail"`
+}
+
+func TestUserAttributesDecode(t *testing.T) {
+	a := UserAttributes{
+		"mail":    {"enoch@example.com", "root@example.com"},
+		"uid":     {"1042"},
+		"score":   {"0.5"},
+		"joined":  {"Fri, 01 Mar 2024"},
+		"session": {"8h"},
+		"ip":      {"192.0.2.1"},
+	}
+
+	var p decodedProfile
+	require.NoError(t, a.Decode(&p))
+
+	assert.Equal(t, "enoch@example.com", p.Mail)
+	assert.Equal(t, []string{"enoch@example.com", "root@example.com"}, p.Aliases)
+	assert.Nil(t, p.Groups)
+	assert.Equal(t, uint32(1042), p.UID)
+	assert.Equal(t, 0.5, p.Score)
+	assert.True(t, p.Active)
+	assert.Equal(t, "en, GB", p.Locale)
+	assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), p.Joined)
+	assert.Equal(t, 8*time.Hour, p.Session)
+	assert.Nil(t, p.Manager)
+	assert.Equal(t, "192.0.2.1", p.Address.String())
+	assert.Empty(t, p.internal)
+
+	a["manager"] = []string{"uid=boss"}
+	a["active"] = []string{"false"}
+	require.NoError(t, a.Decode(&p))
+	require.NotNil(t, p.Manager)
+	assert.Equal(t, "uid=boss", *p.Manager)
+	assert.False(t, p.Active)
+}
+
+func TestUserAttributesDecodeErrors(t *testing.T) {
+	a := UserAttributes{
+		"uid":    {"-1"},
+		"score":  {"high"},
+		"joined": {"2024-03-01"},
+	}
+
+	var p decodedProfile
+	err := a.Decode(&p)
+
+	var decodeErr *DecodeError
+	require.True(t, errors.As(err, &decodeErr))
+	assert.Equal(t, []string{"mail"}, decodeErr.Missing)
+	require.Len(t, decodeErr.Malformed, 3)
+	assert.Equal(t, "uid", decodeErr.Malformed[0].Name)
+	assert.Equal(t, "score", decodeErr.Malformed[1].Name)
+	assert.Equal(t, "joined", decodeErr.Malformed[2].Name)
+	assert.Contains(t, err.Error(), `cas: decoding attributes: missing mail; malformed uid "-1": `)
+
+	assert.Error(t, a.Decode(p))
+	assert.Error(t, a.Decode(nil))
+
+	var unsupported struct {
+		Values map[string]string `cas:"uid"`
+	}
+	err = a.Decode(&unsupported)
+	assert.EqualError(t, err, "cas: decoding attributes: field Values: unsupported type map[string]string")
+
+	var badTag struct {
+		UID int `cas:"uid,optional"`
+	}
+	assert.Error(t, a.Decode(&badTag))
+}
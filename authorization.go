@@ -0,0 +1,129 @@
+package cas
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Authorizer decides whether an authenticated user may access a resource.
+//
+// Any predicate over the AuthenticationResponse can be used as an Authorizer, RequireAttribute, RequireGroup,
+// AnyOf and AllOf cover the common cases:
+//
+//	admin := cas.AllOf(cas.RequireGroup("admins"), cas.RequireAttribute("mfa", "true"))
+//	mux.Handle("/admin", client.Handle(admin.Handler(adminHandler)))
+type Authorizer func(a *AuthenticationResponse) bool
+
+// RequireAttribute authorizes users with any of the values of the attribute, or with any non-empty
+// value when no values are given.
+func RequireAttribute(name string, values ...string) Authorizer {
+	return func(a *AuthenticationResponse) bool {
+		for _, v := range a.Attributes[name] {
+			if len(values) == 0 && v != "" {
+				return true
+			}
+
+			for _, value := range values {
+				if v == value {
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+}
+
+// RequireGroup authorizes users which are a member of any of the groups. Group names are compared
+// case-insensitively, like directory group DNs.
+func RequireGroup(groups ...string) Authorizer {
+	return func(a *AuthenticationResponse) bool {
+		for _, g := range a.MemberOf {
+			for _, group := range groups {
+				if strings.EqualFold(g, group) {
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+}
+
+// AnyOf authorizes users authorized by at least one of the authorizers.
+func AnyOf(authorizers ...Authorizer) Authorizer {
+	return func(a *AuthenticationResponse) bool {
+		for _, authorizer := range authorizers {
+			if authorizer(a) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// AllOf authorizes users authorized by every one of the authorizers.
+func AllOf(authorizers ...Authorizer) Authorizer {
+	return func(a *AuthenticationResponse) bool {
+		for _, authorizer := range authorizers {
+			if !authorizer(a) {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+// IsAuthorized indicates whether the request has been authenticated by a user the Authorizer allows.
+func (authorizer Authorizer) IsAuthorized(r *http.Request) bool {
+	a := getAuthenticationResponse(r)
+	return a != nil && authorizer(a)
+}
+
+// Handler returns a http.Handler which only passes requests of authorized users to h.
+//
+// Unauthenticated requests are redirected to login, requests of users which are not authorized are replied
+// to with the ForbiddenHandler of the Client, or a 403 Forbidden.
+//
+// Like Handler, the returned handler must be wrapped by Handle so that tickets are validated.
+func (authorizer Authorizer) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !IsAuthenticated(r) {
+			RedirectToLogin(w, r)
+			return
+		}
+
+		if !authorizer.IsAuthorized(r) {
+			forbidden(w, r)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// HandlerFunc wraps a function so that it is only called for requests of authorized users.
+func (authorizer Authorizer) HandlerFunc(h func(http.ResponseWriter, *http.Request)) http.Handler {
+	return authorizer.Handler(http.HandlerFunc(h))
+}
+
+// forbidden replies to a request of a user who is not authorized.
+func forbidden(w http.ResponseWriter, r *http.Request) {
+	c := getClient(r)
+	if c == nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	c.logger.Info("Forbidden request", slog.String("path", r.URL.Path), slog.String("user", Username(r)))
+
+	if c.forbiddenHandler != nil {
+		c.forbiddenHandler.ServeHTTP(w, r)
+		return
+	}
+
+	http.Error(w, "Forbidden", http.StatusForbidden)
+}
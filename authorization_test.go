@@ -0,0 +1,88 @@
+package cas
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorizers(t *testing.T) {
+	a := &AuthenticationResponse{
+		User:       "enoch.root",
+		Attributes: UserAttributes{"mail": {"enoch@example.com"}, "mfa": {"true"}, "empty": {""}},
+		MemberOf:   []string{"staff", "admins"},
+	}
+
+	tests := []struct {
+		name       string
+		authorizer Authorizer
+		expected   bool
+	}{
+		{"attribute value", RequireAttribute("mfa", "true"), true},
+		{"attribute any value", RequireAttribute("mail", "other@example.com", "enoch@example.com"), true},
+		{"attribute wrong value", RequireAttribute("mfa", "false"), false},
+		{"attribute present", RequireAttribute("mail"), true},
+		{"attribute empty", RequireAttribute("empty"), false},
+		{"attribute missing", RequireAttribute("phone"), false},
+		{"group", RequireGroup("admins"), true},
+		{"any group", RequireGroup("auditors", "staff"), true},
+		{"group missing", RequireGroup("auditors"), false},
+		{"group case-insensitive", RequireGroup("Admins"), true},
+		{"any of", AnyOf(RequireGroup("auditors"), RequireAttribute("mfa", "true")), true},
+		{"any of none", AnyOf(RequireGroup("auditors"), RequireAttribute("mfa", "false")), false},
+		{"all of", AllOf(RequireGroup("admins"), RequireAttribute("mfa", "true")), true},
+		{"all of one", AllOf(RequireGroup("admins"), RequireAttribute("mfa", "false")), false},
+		{"predicate", Authorizer(func(a *AuthenticationResponse) bool { return a.User == "enoch.root" }), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.authorizer(a))
+		})
+	}
+}
+
+func TestAuthorizerHandler(t *testing.T) {
+	u, _ := url.Parse("https://cas.example.com/")
+	handler := RequireGroup("admins").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("admin"))
+	})
+
+	tests := []struct {
+		name      string
+		forbidden http.Handler
+		a         *AuthenticationResponse
+		code      int
+		body      string
+	}{
+		{"unauthenticated", nil, nil, http.StatusFound, ""},
+		{"authorized", nil, &AuthenticationResponse{User: "enoch.root", MemberOf: []string{"admins"}}, http.StatusOK, "admin"},
+		{"forbidden", nil, &AuthenticationResponse{User: "enoch.root"}, http.StatusForbidden, "Forbidden\n"},
+		{"custom forbidden", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}), &AuthenticationResponse{User: "enoch.root"}, http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient(&Options{URL: u, ForbiddenHandler: tt.forbidden})
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/admin", nil)
+			setClient(req, client)
+			if tt.a != nil {
+				setAuthenticationResponse(req, tt.a)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.code, w.Code)
+			if tt.code != http.StatusFound {
+				assert.Equal(t, tt.body, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package casserver
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/mattmohan-flipp/cas/v2"
+)
+
+// ErrInvalidCredentials is returned by an Authenticator when the username or password is wrong.
+var ErrInvalidCredentials = errors.New("casserver: invalid credentials")
+
+// Principal is an authenticated user.
+type Principal struct {
+	Username   string
+	Attributes cas.UserAttributes // Attributes released to services
+	MemberOf   []string           // Groups released to services
+}
+
+// Authenticator verifies the credentials submitted to the login page.
+type Authenticator interface {
+	// Authenticate returns the Principal for valid credentials, or ErrInvalidCredentials.
+	Authenticate(r *http.Request, username, password string) (*Principal, error)
+}
+
+// AuthenticatorFunc is an adapter to allow the use of ordinary functions as Authenticators.
+type AuthenticatorFunc func(r *http.Request, username, password string) (*Principal, error)
+
+// Authenticate calls f(r, username, password).
+func (f AuthenticatorFunc) Authenticate(r *http.Request, username, password string) (*Principal, error) {
+	return f(r, username, password)
+}
+
+// denyAuthenticator rejects all credentials, it is used when no Authenticator is configured.
+type denyAuthenticator struct{}
+
+func (denyAuthenticator) Authenticate(r *http.Request, username, password string) (*Principal, error) {
+	return nil, ErrInvalidCredentials
+}
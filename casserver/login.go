@@ -0,0 +1,291 @@
+package casserver
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// TicketGrantingCookieName is the default name of the SSO session cookie
+const TicketGrantingCookieName = "CASTGC"
+
+var defaultLoginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+  <head><title>Log In</title></head>
+  <body>
+    {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+    <form method="post" action="{{.Action}}">
+      <input type="hidden" name="service" value="{{.Service}}">
+      <input type="hidden" name="lt" value="{{.LoginTicket}}">
+      {{if .Renew}}<input type="hidden" name="renew" value="true">{{end}}
+      <label>Username <input type="text" name="username" value="{{.Username}}" autocomplete="username"></label>
+      <label>Password <input type="password" name="password" autocomplete="current-password"></label>
+      <input type="submit" value="Log In">
+    </form>
+  </body>
+</html>
+`))
+
+// handleLogin serves the credential requestor and acceptor of the login endpoint.
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	service := r.FormValue("service")
+	renew := r.FormValue("renew") == "true"
+	gateway := r.FormValue("gateway") == "true" && !renew
+
+	if service != "" {
+		if _, ok := s.services.Match(service); !ok {
+			s.logger.Warn("login for unauthorized service", slog.String("service", service))
+			http.Error(w, "Application not authorized to use CAS", http.StatusForbidden)
+			return
+		}
+	}
+
+	if r.Method == http.MethodPost {
+		s.acceptCredentials(w, r, service, renew)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if tgt := s.grantingTicket(r); tgt != nil && !renew {
+		s.redirectToService(w, r, tgt, service, false)
+		return
+	}
+
+	if gateway && service != "" {
+		http.Redirect(w, r, service, http.StatusFound)
+		return
+	}
+
+	s.renderLogin(w, r, http.StatusOK, &LoginPage{Service: service, Renew: renew})
+}
+
+// acceptCredentials authenticates the submitted login form and creates a SSO session.
+func (s *Server) acceptCredentials(w http.ResponseWriter, r *http.Request, service string, renew bool) {
+	page := &LoginPage{
+		Service:  service,
+		Renew:    renew,
+		Username: r.PostFormValue("username"),
+	}
+
+	// The login ticket protects against cross-site login requests and replay of the form
+	lt, err := s.tickets.Remove(r.PostFormValue("lt"))
+	if err != nil || lt.Type != LoginTicket {
+		page.Error = "Your login form has expired, please try again."
+		s.renderLogin(w, r, http.StatusBadRequest, page)
+		return
+	}
+
+	principal, err := s.authenticator.Authenticate(r, page.Username, r.PostFormValue("password"))
+	if err != nil {
+		if !errors.Is(err, ErrInvalidCredentials) {
+			s.logger.Error("authentication error", slog.String("username", page.Username), slog.String("error", err.Error()))
+		} else {
+			s.logger.Info("invalid credentials", slog.String("username", page.Username))
+		}
+
+		page.Error = "Invalid credentials."
+		s.renderLogin(w, r, http.StatusUnauthorized, page)
+		return
+	}
+
+	now := time.Now()
+	tgt := &Ticket{
+		Type:      TicketGrantingTicket,
+		Principal: principal,
+		NewLogin:  true,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.ticketGrantingTicketTTL),
+		Services:  make(map[string]string),
+	}
+
+	// A renewed login replaces the existing session, which keeps the services for single logout
+	if previous := s.grantingTicket(r); previous != nil && previous.Principal.Username == principal.Username {
+		s.mu.Lock()
+		if previous, err := s.tickets.Remove(previous.ID); err == nil {
+			for st, svc := range previous.Services {
+				tgt.Services[st] = svc
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	if err := s.issue(tgt); err != nil {
+		s.internalError(w, "create ticket granting ticket", err)
+		return
+	}
+
+	s.logger.Info("user logged in", slog.String("username", principal.Username))
+
+	cookie := *s.cookie
+	cookie.Value = tgt.ID
+	http.SetCookie(w, &cookie)
+
+	s.redirectToService(w, r, tgt, service, true)
+}
+
+// renderLogin shows the login form with a fresh login ticket.
+func (s *Server) renderLogin(w http.ResponseWriter, r *http.Request, code int, page *LoginPage) {
+	now := time.Now()
+	lt := &Ticket{
+		Type:      LoginTicket,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.loginTicketTTL),
+	}
+
+	if err := s.issue(lt); err != nil {
+		s.internalError(w, "create login ticket", err)
+		return
+	}
+
+	page.Action = s.path(s.urlScheme.Login)
+	page.LoginTicket = lt.ID
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+
+	if err := s.loginTemplate.Execute(w, page); err != nil {
+		s.logger.Error("render login page", slog.String("error", err.Error()))
+	}
+}
+
+// redirectToService issues a service ticket from the SSO session and redirects to the service.
+func (s *Server) redirectToService(w http.ResponseWriter, r *http.Request, tgt *Ticket, service string, newLogin bool) {
+	if service == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "Logged in as %s\n", tgt.Principal.Username)
+		return
+	}
+
+	u, err := url.Parse(service)
+	if err != nil {
+		http.Error(w, "Invalid service", http.StatusBadRequest)
+		return
+	}
+
+	st, err := s.grantServiceTicket(tgt, service, newLogin)
+	if err != nil {
+		s.internalError(w, "create service ticket", err)
+		return
+	}
+
+	q := u.Query()
+	q.Set("ticket", st.ID)
+	u.RawQuery = q.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// grantServiceTicket issues a service ticket and records the service on the SSO session for single logout.
+func (s *Server) grantServiceTicket(tgt *Ticket, service string, newLogin bool) (*Ticket, error) {
+	now := time.Now()
+	st := &Ticket{
+		Type:           ServiceTicket,
+		Principal:      tgt.Principal,
+		Service:        service,
+		GrantingTicket: tgt.ID,
+		NewLogin:       newLogin,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(s.serviceTicketTTL),
+	}
+
+	if err := s.issue(st); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Re-read the session so that concurrent logins to other services are not lost
+	current, err := s.tickets.Get(tgt.ID)
+	if err != nil {
+		s.tickets.Remove(st.ID)
+		return nil, err
+	}
+
+	if current.Services == nil {
+		current.Services = make(map[string]string)
+	}
+	current.Services[st.ID] = service
+
+	if err := s.tickets.Put(current); err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// handleLogout destroys the SSO session and notifies the services it was used for.
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if tgt := s.grantingTicket(r); tgt != nil {
+		s.mu.Lock()
+		tgt, err := s.tickets.Remove(tgt.ID)
+		var services map[string]string
+		if err == nil {
+			services = make(map[string]string, len(tgt.Services))
+			for st, svc := range tgt.Services {
+				services[st] = svc
+			}
+		}
+		s.mu.Unlock()
+
+		if err == nil {
+			s.logger.Info("user logged out", slog.String("username", tgt.Principal.Username))
+			s.singleLogout(services)
+		}
+	}
+
+	cookie := *s.cookie
+	cookie.Value = ""
+	cookie.MaxAge = -1
+	http.SetCookie(w, &cookie)
+
+	if service := r.FormValue("service"); service != "" {
+		if _, ok := s.services.Match(service); ok {
+			http.Redirect(w, r, service, http.StatusFound)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "You have been logged out.")
+}
+
+// grantingTicket returns the SSO session of the request, or nil.
+func (s *Server) grantingTicket(r *http.Request) *Ticket {
+	cookie, err := r.Cookie(s.cookie.Name)
+	if err != nil {
+		return nil
+	}
+
+	tgt, err := s.tickets.Get(cookie.Value)
+	if err != nil || tgt.Type != TicketGrantingTicket {
+		return nil
+	}
+
+	return tgt
+}
+
+// issue assigns an identifier to the ticket and stores it.
+func (s *Server) issue(t *Ticket) error {
+	id, err := newTicketID(t.Type)
+	if err != nil {
+		return err
+	}
+
+	t.ID = id
+	return s.tickets.Put(t)
+}
+
+func (s *Server) internalError(w http.ResponseWriter, msg string, err error) {
+	s.logger.Error(msg, slog.String("error", err.Error()))
+	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+}
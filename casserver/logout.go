@@ -0,0 +1,29 @@
+package casserver
+
+import (
+	"github.com/mattmohan-flipp/cas/v2"
+)
+
+// singleLogout sends back-channel logout requests in the background for every service ticket issued by a SSO session.
+//
+// The services map service tickets to the service url they were issued for.
+func (s *Server) singleLogout(services map[string]string) {
+	var targets []cas.LogoutTarget
+	for st, service := range services {
+		rs, ok := s.services.Match(service)
+		if !ok {
+			continue
+		}
+
+		endpoint := service
+		if rs.LogoutURL != "" {
+			endpoint = rs.LogoutURL
+		}
+
+		targets = append(targets, cas.LogoutTarget{URL: endpoint, Ticket: st})
+	}
+
+	if len(targets) > 0 {
+		s.logouts.DispatchAsync(targets, nil)
+	}
+}
@@ -0,0 +1,241 @@
+/*
+Package casserver provides an embeddable CAS server for small deployments.
+
+The server implements the CAS protocol login and logout pages, the CAS 1, 2 and 3 validation endpoints and proxy
+ticket granting. Credentials are checked by a pluggable Authenticator, tickets are kept in a TicketRegistry and
+only the applications in the ServiceRegistry may use the server.
+
+	services, err := casserver.NewMemoryServiceRegistry(&casserver.RegisteredService{
+		Name:    "intranet",
+		Pattern: "^https://intranet\\.example\\.com/.*",
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	u, _ := url.Parse("https://sso.example.com/cas/")
+	server := casserver.NewServer(&casserver.Options{
+		URL:           u,
+		Authenticator: authenticator,
+		Services:      services,
+	})
+
+	http.Handle("/cas/", server)
+*/
+package casserver
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/mattmohan-flipp/cas/v2"
+	"github.com/mattmohan-flipp/cas/v2/urlscheme"
+)
+
+// Default ticket lifetimes
+const (
+	DefaultServiceTicketTTL        = 10 * time.Second
+	DefaultTicketGrantingTicketTTL = 8 * time.Hour
+	DefaultLoginTicketTTL          = 10 * time.Minute
+)
+
+// Options : Server configuration options
+type Options struct {
+	URL           *url.URL            // Public URL of the server, required, the endpoints are served below its path
+	URLScheme     urlscheme.URLScheme // Custom url scheme, can be used to modify the endpoint paths
+	Authenticator Authenticator       // Verifies login credentials, all logins fail if nil
+	Services      ServiceRegistry     // Applications allowed to use the server, all services are rejected if nil
+	Tickets       TicketRegistry      // Custom TicketRegistry, if nil a MemoryTicketRegistry will be used
+	Cookie        *http.Cookie        // Ticket granting cookie options, uses Name, Path, Domain, HttpOnly, Secure & SameSite
+	Client        *http.Client        // Custom http client used for proxy callbacks and single logout requests
+	LoginTemplate *template.Template  // Custom login page, executed with a LoginPage
+	Logger        *slog.Logger        // Optional logger
+
+	ServiceTicketTTL        time.Duration // Lifetime of service and proxy tickets, defaults to 10 seconds
+	TicketGrantingTicketTTL time.Duration // Lifetime of SSO sessions, defaults to 8 hours
+	LoginTicketTTL          time.Duration // Time allowed to submit the login form, defaults to 10 minutes
+
+	AllowInsecureProxyCallback bool // Accept pgtUrl callbacks which do not use https
+}
+
+// LoginPage is the data passed to the login template.
+type LoginPage struct {
+	Action      string // URL the form must be posted to
+	Service     string
+	Renew       bool
+	LoginTicket string
+	Username    string
+	Error       string
+}
+
+// Server implements the CAS protocol endpoints.
+type Server struct {
+	urlScheme     urlscheme.URLScheme
+	authenticator Authenticator
+	services      ServiceRegistry
+	tickets       TicketRegistry
+	cookie        *http.Cookie
+	client        *http.Client
+	loginTemplate *template.Template
+	logouts       *cas.LogoutDispatcher
+	responses     *cas.ServiceResponseWriter
+	logger        *slog.Logger
+
+	serviceTicketTTL        time.Duration
+	ticketGrantingTicketTTL time.Duration
+	loginTicketTTL          time.Duration
+
+	allowInsecureProxyCallback bool
+
+	mu sync.Mutex // serialises updates of the services recorded on ticket granting tickets
+}
+
+// NewServer creates a Server with the provided Options.
+func NewServer(options *Options) *Server {
+	// If logger isn't set then fallback to the default logger
+	logger := options.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	var urlScheme urlscheme.URLScheme
+	if options.URLScheme != nil {
+		urlScheme = options.URLScheme
+	} else {
+		urlScheme = urlscheme.NewDefaultURLScheme(options.URL)
+	}
+
+	var authenticator Authenticator
+	if options.Authenticator != nil {
+		authenticator = options.Authenticator
+	} else {
+		authenticator = denyAuthenticator{}
+	}
+
+	var services ServiceRegistry
+	if options.Services != nil {
+		services = options.Services
+	} else {
+		services = &MemoryServiceRegistry{}
+	}
+
+	var tickets TicketRegistry
+	if options.Tickets != nil {
+		tickets = options.Tickets
+	} else {
+		tickets = NewMemoryTicketRegistry()
+	}
+
+	var cookie *http.Cookie
+	if options.Cookie != nil {
+		// The defaults are filled in on a copy, the options may be shared
+		c := *options.Cookie
+		cookie = &c
+	} else {
+		cookie = &http.Cookie{
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+		}
+	}
+
+	if cookie.Name == "" {
+		cookie.Name = TicketGrantingCookieName
+	}
+
+	if cookie.Path == "" {
+		cookie.Path = "/"
+		if options.URL != nil && options.URL.Path != "" {
+			cookie.Path = options.URL.Path
+		}
+	}
+
+	var client *http.Client
+	if options.Client != nil {
+		client = options.Client
+	} else {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	loginTemplate := options.LoginTemplate
+	if loginTemplate == nil {
+		loginTemplate = defaultLoginTemplate
+	}
+
+	return &Server{
+		responses:     &cas.ServiceResponseWriter{Indent: 2},
+		urlScheme:     urlScheme,
+		authenticator: authenticator,
+		services:      services,
+		tickets:       tickets,
+		cookie:        cookie,
+		client:        client,
+		loginTemplate: loginTemplate,
+		logouts:       cas.NewLogoutDispatcher(&cas.LogoutDispatcherOptions{Client: client, Logger: logger}),
+		logger:        logger,
+
+		serviceTicketTTL:        durationOrDefault(options.ServiceTicketTTL, DefaultServiceTicketTTL),
+		ticketGrantingTicketTTL: durationOrDefault(options.TicketGrantingTicketTTL, DefaultTicketGrantingTicketTTL),
+		loginTicketTTL:          durationOrDefault(options.LoginTicketTTL, DefaultLoginTicketTTL),
+
+		allowInsecureProxyCallback: options.AllowInsecureProxyCallback,
+	}
+}
+
+// ServeHTTP routes requests to the CAS protocol endpoints.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case s.path(s.urlScheme.Login):
+		s.handleLogin(w, r)
+	case s.path(s.urlScheme.Logout):
+		s.handleLogout(w, r)
+	case s.path(s.urlScheme.Validate):
+		s.handleValidate(w, r)
+	case s.path(s.urlScheme.ServiceValidate):
+		s.handleServiceValidate(w, r, false, false)
+	case s.path(s.p3ServiceValidate):
+		s.handleServiceValidate(w, r, false, true)
+	case s.path(s.urlScheme.ProxyValidate):
+		s.handleServiceValidate(w, r, true, false)
+	case s.path(s.p3ProxyValidate):
+		s.handleServiceValidate(w, r, true, true)
+	case s.path(s.urlScheme.Proxy):
+		s.handleProxy(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) p3ServiceValidate() (*url.URL, error) {
+	return urlscheme.P3ServiceValidate(s.urlScheme)
+}
+
+func (s *Server) p3ProxyValidate() (*url.URL, error) {
+	return urlscheme.P3ProxyValidate(s.urlScheme)
+}
+
+// path returns the path of an endpoint url, or an empty string when the url cannot be created.
+func (s *Server) path(endpoint func() (*url.URL, error)) string {
+	u, err := endpoint()
+	if err != nil {
+		return ""
+	}
+
+	if u.Path == "" {
+		return "/"
+	}
+
+	return u.Path
+}
+
+func durationOrDefault(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+
+	return d
+}
@@ -0,0 +1,469 @@
+package casserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattmohan-flipp/cas/v2"
+	"github.com/mattmohan-flipp/cas/v2/proxy"
+	"github.com/mattmohan-flipp/cas/v2/urlscheme"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testAuthenticator = AuthenticatorFunc(func(r *http.Request, username, password string) (*Principal, error) {
+	if username != "enoch.root" || password != "secret" {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Principal{
+		Username:   username,
+		Attributes: cas.UserAttributes{"mail": {"enoch@example.com"}, "phone": {"555-0100"}},
+		MemberOf:   []string{"staff"},
+	}, nil
+})
+
+var loginTicketPattern = regexp.MustCompile(`name="lt" value="([^"]+)"`)
+
+type testEnv struct {
+	cas    *httptest.Server
+	server *Server
+	app    *httptest.Server
+}
+
+func newTestEnv(t *testing.T, services ...*RegisteredService) *testEnv {
+	env := &testEnv{}
+	env.cas = httptest.NewUnstartedServer(nil)
+	env.app = httptest.NewUnstartedServer(nil)
+
+	if len(services) == 0 {
+		services = []*RegisteredService{{Name: "app", Pattern: "^http://" + env.app.Listener.Addr().String() + "/.*"}}
+	}
+
+	registry, err := NewMemoryServiceRegistry(services...)
+	require.NoError(t, err)
+
+	casURL, _ := url.Parse("http://" + env.cas.Listener.Addr().String() + "/cas/")
+	env.server = NewServer(&Options{
+		URL:           casURL,
+		Authenticator: testAuthenticator,
+		Services:      registry,
+		Cookie:        &http.Cookie{HttpOnly: true},
+
+		AllowInsecureProxyCallback: true,
+	})
+	env.cas.Config.Handler = env.server
+	env.cas.Start()
+
+	client := cas.NewClient(&cas.Options{URL: casURL, ProtocolVersion: cas.ProtocolCAS3})
+	env.app.Config.Handler = client.HandleFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cas.IsAuthenticated(r) {
+			cas.RedirectToLogin(w, r)
+			return
+		}
+
+		fmt.Fprintf(w, "%s %s %v", cas.Username(r), cas.Attributes(r).Get("mail"), cas.MemberOf(r))
+	})
+	env.app.Start()
+
+	t.Cleanup(func() {
+		env.app.Close()
+		env.cas.Close()
+	})
+
+	return env
+}
+
+func newBrowser(t *testing.T) *http.Client {
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+
+	return &http.Client{Jar: jar}
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return string(body)
+}
+
+// login submits the login form shown by the page at u.
+func login(t *testing.T, browser *http.Client, u, password string) *http.Response {
+	resp, err := browser.Get(u)
+	require.NoError(t, err)
+	form := readBody(t, resp)
+
+	m := loginTicketPattern.FindStringSubmatch(form)
+	require.NotNil(t, m, form)
+
+	loginURL := resp.Request.URL
+	resp, err = browser.PostForm(loginURL.String(), url.Values{
+		"service":  {loginURL.Query().Get("service")},
+		"renew":    {loginURL.Query().Get("renew")},
+		"lt":       {m[1]},
+		"username": {"enoch.root"},
+		"password": {password},
+	})
+	require.NoError(t, err)
+
+	return resp
+}
+
+func TestNewServerDoesNotModifyOptions(t *testing.T) {
+	casURL, _ := url.Parse("https://sso.example.com/cas/")
+	options := &Options{URL: casURL, Cookie: &http.Cookie{HttpOnly: true}}
+
+	server := NewServer(options)
+	require.NotNil(t, server)
+
+	assert.Equal(t, &http.Cookie{HttpOnly: true}, options.Cookie)
+	assert.Nil(t, options.Logger)
+	assert.Equal(t, TicketGrantingCookieName, server.cookie.Name)
+	assert.Equal(t, "/cas/", server.cookie.Path)
+}
+
+func TestLoginAndValidate(t *testing.T) {
+	env := newTestEnv(t)
+	browser := newBrowser(t)
+
+	resp := login(t, browser, env.app.URL+"/", "secret")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "enoch.root enoch@example.com [staff]", readBody(t, resp))
+}
+
+func TestLoginInvalidCredentials(t *testing.T) {
+	env := newTestEnv(t)
+
+	resp := login(t, newBrowser(t), env.app.URL+"/", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Contains(t, readBody(t, resp), "Invalid credentials.")
+}
+
+func TestLoginRequiresLoginTicket(t *testing.T) {
+	env := newTestEnv(t)
+
+	resp, err := http.PostForm(env.cas.URL+"/cas/login", url.Values{
+		"lt":       {"LT-forged"},
+		"username": {"enoch.root"},
+		"password": {"secret"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Empty(t, resp.Cookies())
+}
+
+func TestLoginUnauthorizedService(t *testing.T) {
+	env := newTestEnv(t)
+
+	resp, err := http.Get(env.cas.URL + "/cas/login?service=" + url.QueryEscape("http://evil.example.com/"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestSingleSignOn(t *testing.T) {
+	env := newTestEnv(t)
+	browser := newBrowser(t)
+
+	resp := login(t, browser, env.app.URL+"/", "secret")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	readBody(t, resp)
+
+	// A second browser session for the application is authenticated without the login form
+	browser.Jar, _ = withCookies(browser.Jar, env.cas.URL)
+	resp, err := browser.Get(env.app.URL + "/other")
+	require.NoError(t, err)
+	assert.Equal(t, "enoch.root enoch@example.com [staff]", readBody(t, resp))
+}
+
+// withCookies returns a cookie jar which only keeps the cookies of the url.
+func withCookies(jar http.CookieJar, u string) (http.CookieJar, error) {
+	parsed, _ := url.Parse(u)
+	parsed.Path = "/cas/"
+
+	fresh, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	fresh.SetCookies(parsed, jar.Cookies(parsed))
+
+	return fresh, nil
+}
+
+func TestGateway(t *testing.T) {
+	env := newTestEnv(t)
+	service := env.app.URL + "/public"
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(env.cas.URL + "/cas/login?gateway=true&service=" + url.QueryEscape(service))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, service, resp.Header.Get("Location"))
+}
+
+func TestServiceTicketIsOneTimeUse(t *testing.T) {
+	env := newTestEnv(t)
+	service := env.app.URL + "/"
+
+	st := issueServiceTicket(t, env, service)
+
+	validator := newValidator(env)
+	serviceURL, _ := url.Parse(service)
+
+	success, err := validator.ValidateTicket(serviceURL, st, disabledProxy)
+	require.NoError(t, err)
+	assert.Equal(t, "enoch.root", success.User)
+	assert.True(t, success.IsNewLogin)
+
+	_, err = validator.ValidateTicket(serviceURL, st, disabledProxy)
+	require.Error(t, err)
+	assert.Equal(t, cas.INVALID_TICKET, err.(*cas.AuthenticationError).Code)
+}
+
+func TestServiceTicketServiceMismatch(t *testing.T) {
+	env := newTestEnv(t)
+	st := issueServiceTicket(t, env, env.app.URL+"/")
+
+	other, _ := url.Parse(env.app.URL + "/other")
+	_, err := newValidator(env).ValidateTicket(other, st, disabledProxy)
+	require.Error(t, err)
+	assert.Equal(t, cas.INVALID_SERVICE, err.(*cas.AuthenticationError).Code)
+}
+
+func TestServiceTicketExpires(t *testing.T) {
+	env := newTestEnv(t)
+	service := env.app.URL + "/"
+	st := issueServiceTicket(t, env, service)
+
+	ticket, err := env.server.tickets.Get(st)
+	require.NoError(t, err)
+	ticket.ExpiresAt = time.Now().Add(-time.Second)
+
+	serviceURL, _ := url.Parse(service)
+	_, err = newValidator(env).ValidateTicket(serviceURL, st, disabledProxy)
+	require.Error(t, err)
+	assert.Equal(t, cas.INVALID_TICKET, err.(*cas.AuthenticationError).Code)
+}
+
+func TestReleasedAttributes(t *testing.T) {
+	env := newTestEnv(t)
+	env.server.services.(*MemoryServiceRegistry).services[0].ReleasedAttributes = []string{"mail"}
+
+	service := env.app.URL + "/"
+	st := issueServiceTicket(t, env, service)
+
+	serviceURL, _ := url.Parse(service)
+	success, err := newValidator(env).ValidateTicket(serviceURL, st, disabledProxy)
+	require.NoError(t, err)
+	assert.Equal(t, cas.UserAttributes{"mail": {"enoch@example.com"}}, success.Attributes)
+	assert.Empty(t, success.MemberOf)
+}
+
+func TestCas1Validate(t *testing.T) {
+	env := newTestEnv(t)
+	service := env.app.URL + "/"
+	st := issueServiceTicket(t, env, service)
+
+	resp, err := http.Get(env.cas.URL + "/cas/validate?" + url.Values{"service": {service}, "ticket": {st}}.Encode())
+	require.NoError(t, err)
+	assert.Equal(t, "yes\nenoch.root\n", readBody(t, resp))
+
+	resp, err = http.Get(env.cas.URL + "/cas/validate?" + url.Values{"service": {service}, "ticket": {st}}.Encode())
+	require.NoError(t, err)
+	assert.Equal(t, "no\n\n", readBody(t, resp))
+}
+
+func TestRenew(t *testing.T) {
+	env := newTestEnv(t)
+	service := env.app.URL + "/"
+	browser := newBrowser(t)
+
+	resp := login(t, browser, env.cas.URL+"/cas/login", "secret")
+	readBody(t, resp)
+
+	// A ticket issued from the existing SSO session is not a new login
+	st := ticketFromLogin(t, browser, env.cas.URL+"/cas/login?service="+url.QueryEscape(service))
+	resp, err := http.Get(env.cas.URL + "/cas/serviceValidate?" + url.Values{"service": {service}, "ticket": {st}, "renew": {"true"}}.Encode())
+	require.NoError(t, err)
+	assert.Contains(t, readBody(t, resp), `code="INVALID_TICKET"`)
+
+	// renew=true forces the login form
+	browser.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp = login(t, browser, env.cas.URL+"/cas/login?renew=true&service="+url.QueryEscape(service), "secret")
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	location, _ := url.Parse(resp.Header.Get("Location"))
+
+	resp, err = http.Get(env.cas.URL + "/cas/serviceValidate?" + url.Values{"service": {service}, "ticket": {location.Query().Get("ticket")}, "renew": {"true"}}.Encode())
+	require.NoError(t, err)
+	success, err := cas.ParseServiceResponse([]byte(readBody(t, resp)))
+	require.NoError(t, err)
+	assert.Equal(t, "enoch.root", success.User)
+}
+
+func TestProxy(t *testing.T) {
+	var mu sync.Mutex
+	pgts := make(map[string]string)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		pgts[r.URL.Query().Get("pgtIou")] = r.URL.Query().Get("pgtId")
+		mu.Unlock()
+	}))
+	defer callback.Close()
+
+	env := newTestEnv(t,
+		&RegisteredService{Name: "web", Pattern: "http://web.example.com/", AllowProxy: true, ProxyCallbackPattern: "^" + regexp.QuoteMeta(callback.URL) + "(/.*)?$"},
+		&RegisteredService{Name: "api", Pattern: "http://api.example.com/"},
+	)
+
+	st := issueServiceTicket(t, env, "http://web.example.com/")
+	resp, err := http.Get(env.cas.URL + "/cas/serviceValidate?" + url.Values{
+		"service": {"http://web.example.com/"},
+		"ticket":  {st},
+		"pgtUrl":  {callback.URL},
+	}.Encode())
+	require.NoError(t, err)
+
+	success, err := cas.ParseServiceResponse([]byte(readBody(t, resp)))
+	require.NoError(t, err)
+
+	mu.Lock()
+	pgt, ok := pgts[success.ProxyGrantingTicket]
+	mu.Unlock()
+	require.True(t, ok)
+
+	// Unregistered target services cannot obtain proxy tickets
+	resp, err = http.Get(env.cas.URL + "/cas/proxy?" + url.Values{"pgt": {pgt}, "targetService": {"http://evil.example.com/"}}.Encode())
+	require.NoError(t, err)
+	assert.Contains(t, readBody(t, resp), `code="UNAUTHORIZED_SERVICE"`)
+
+	resp, err = http.Get(env.cas.URL + "/cas/proxy?" + url.Values{"pgt": {pgt}, "targetService": {"http://api.example.com/"}}.Encode())
+	require.NoError(t, err)
+	pt, err := cas.ParseProxyResponse([]byte(readBody(t, resp)))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(pt, "PT-"))
+
+	// Proxy tickets are rejected by serviceValidate
+	resp, err = http.Get(env.cas.URL + "/cas/serviceValidate?" + url.Values{"service": {"http://api.example.com/"}, "ticket": {pt}}.Encode())
+	require.NoError(t, err)
+	assert.Contains(t, readBody(t, resp), `code="INVALID_TICKET_SPEC"`)
+}
+
+func TestProxyCallbackRefusedForService(t *testing.T) {
+	called := false
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer callback.Close()
+
+	env := newTestEnv(t)
+	service := env.app.URL + "/"
+	st := issueServiceTicket(t, env, service)
+
+	resp, err := http.Get(env.cas.URL + "/cas/serviceValidate?" + url.Values{"service": {service}, "ticket": {st}, "pgtUrl": {callback.URL}}.Encode())
+	require.NoError(t, err)
+	success, err := cas.ParseServiceResponse([]byte(readBody(t, resp)))
+	require.NoError(t, err)
+
+	assert.Equal(t, "enoch.root", success.User)
+	assert.Empty(t, success.ProxyGrantingTicket)
+	assert.False(t, called)
+}
+
+func TestProxyCallbackMustBeRegistered(t *testing.T) {
+	var called atomic.Bool
+	foreign := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called.Store(true)
+	}))
+	defer foreign.Close()
+
+	env := newTestEnv(t,
+		&RegisteredService{Name: "web", Pattern: "http://web.example.com/", AllowProxy: true},
+		&RegisteredService{Name: "api", Pattern: "http://api.example.com/", AllowProxy: true, ProxyCallbackPattern: "https://api.example.com/callback"},
+	)
+
+	for _, service := range []string{"http://web.example.com/", "http://api.example.com/"} {
+		st := issueServiceTicket(t, env, service)
+		resp, err := http.Get(env.cas.URL + "/cas/serviceValidate?" + url.Values{
+			"service": {service},
+			"ticket":  {st},
+			"pgtUrl":  {foreign.URL + "/internal"},
+		}.Encode())
+		require.NoError(t, err)
+		success, err := cas.ParseServiceResponse([]byte(readBody(t, resp)))
+		require.NoError(t, err)
+
+		assert.Equal(t, "enoch.root", success.User)
+		assert.Empty(t, success.ProxyGrantingTicket)
+	}
+
+	assert.False(t, called.Load())
+}
+
+func TestLogout(t *testing.T) {
+	env := newTestEnv(t)
+	browser := newBrowser(t)
+
+	resp := login(t, browser, env.app.URL+"/", "secret")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	readBody(t, resp)
+
+	resp, err := browser.Get(env.cas.URL + "/cas/logout")
+	require.NoError(t, err)
+	assert.Contains(t, readBody(t, resp), "logged out")
+
+	// The single logout request ends the application session
+	assert.Eventually(t, func() bool {
+		resp, err := browser.Get(env.app.URL + "/")
+		if err != nil {
+			return false
+		}
+		return strings.Contains(readBody(t, resp), `name="lt"`)
+	}, time.Second, 10*time.Millisecond)
+}
+
+var disabledProxy = proxy.NewProxy(urlscheme.NewDefaultURLScheme(&url.URL{}), &proxy.ProxyOptions{})
+
+func newValidator(env *testEnv) *cas.ServiceTicketValidator {
+	casURL, _ := url.Parse(env.cas.URL + "/cas/")
+	return cas.NewServiceTicketValidator(cas.ServiceTicketValidatorOptions{CasURL: casURL, ProtocolVersion: cas.ProtocolCAS3})
+}
+
+// issueServiceTicket logs in with a new browser and returns the service ticket issued for the service.
+func issueServiceTicket(t *testing.T, env *testEnv, service string) string {
+	browser := newBrowser(t)
+	browser.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	resp := login(t, browser, env.cas.URL+"/cas/login?service="+url.QueryEscape(service), "secret")
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+
+	return location.Query().Get("ticket")
+}
+
+// ticketFromLogin requests the login page with an existing SSO session and returns the issued ticket.
+func ticketFromLogin(t *testing.T, browser *http.Client, u string) string {
+	client := &http.Client{Jar: browser.Jar, CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	resp, err := client.Get(u)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+
+	return location.Query().Get("ticket")
+}
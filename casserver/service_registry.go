@@ -0,0 +1,149 @@
+package casserver
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// RegisteredService is an application which is allowed to use the server.
+type RegisteredService struct {
+	Name string
+
+	// Pattern matches the service urls of the application. Patterns starting with "^" are regular
+	// expressions which must match the whole service url, other patterns must equal the service url.
+	Pattern string
+
+	// AllowProxy permits the service to obtain proxy granting tickets using a pgtUrl.
+	AllowProxy bool
+
+	// ProxyCallbackPattern matches the pgtUrls which receive the proxy granting tickets of the service, like
+	// Pattern. Defaults to Pattern, so the callback must belong to the service. Other urls are never called.
+	ProxyCallbackPattern string
+
+	// ReleasedAttributes lists the names of the attributes released to the service,
+	// all attributes are released when empty.
+	ReleasedAttributes []string
+
+	// LogoutURL receives single logout requests, defaults to the service url the ticket was issued for.
+	LogoutURL string
+
+	once    sync.Once
+	err     error
+	re      *regexp.Regexp
+	proxyRe *regexp.Regexp
+}
+
+// Compile compiles the patterns of the service. It is called by the first match, so services returned by custom
+// ServiceRegistry implementations need not call it, but doing so reports invalid patterns early.
+func (s *RegisteredService) Compile() error {
+	s.once.Do(func() {
+		if s.Pattern == "" {
+			s.err = fmt.Errorf("casserver: service %q has no pattern", s.Name)
+			return
+		}
+
+		if s.re, s.err = compilePattern(s.Pattern); s.err != nil {
+			s.err = fmt.Errorf("casserver: service %q: %w", s.Name, s.err)
+			return
+		}
+
+		if s.proxyRe, s.err = compilePattern(s.ProxyCallbackPattern); s.err != nil {
+			s.err = fmt.Errorf("casserver: service %q: proxy callback: %w", s.Name, s.err)
+		}
+	})
+
+	return s.err
+}
+
+// compilePattern compiles patterns starting with "^" to a regular expression matching the whole url.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if !strings.HasPrefix(pattern, "^") {
+		return nil, nil
+	}
+
+	return regexp.Compile("(?:" + pattern + ")$")
+}
+
+// Matches determines whether the service url belongs to the registered service.
+//
+// A service with invalid patterns matches no service url.
+func (s *RegisteredService) Matches(service string) bool {
+	if s.Compile() != nil {
+		return false
+	}
+
+	if s.re != nil {
+		return s.re.MatchString(service)
+	}
+
+	return s.Pattern == service
+}
+
+// allowsProxyCallback determines whether proxy granting tickets of the service may be sent to the pgtUrl.
+func (s *RegisteredService) allowsProxyCallback(pgtUrl string) bool {
+	if s.ProxyCallbackPattern == "" {
+		return s.Matches(pgtUrl)
+	}
+
+	if s.Compile() != nil {
+		return false
+	}
+
+	if s.proxyRe != nil {
+		return s.proxyRe.MatchString(pgtUrl)
+	}
+
+	return s.ProxyCallbackPattern == pgtUrl
+}
+
+// releasesAttribute determines whether the attribute may be sent to the service.
+func (s *RegisteredService) releasesAttribute(name string) bool {
+	if len(s.ReleasedAttributes) == 0 {
+		return true
+	}
+
+	for _, released := range s.ReleasedAttributes {
+		if released == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ServiceRegistry provides an interface for looking up the applications allowed to use the server.
+type ServiceRegistry interface {
+	// Match returns the registered service for a service url, or false if the service is not allowed.
+	Match(service string) (*RegisteredService, bool)
+}
+
+// MemoryServiceRegistry implements the ServiceRegistry interface with a fixed list of services.
+//
+// Services are matched in the order they were registered.
+type MemoryServiceRegistry struct {
+	services []*RegisteredService
+}
+
+// NewMemoryServiceRegistry creates a MemoryServiceRegistry, compiling the pattern of each service.
+func NewMemoryServiceRegistry(services ...*RegisteredService) (*MemoryServiceRegistry, error) {
+	for _, s := range services {
+		if err := s.Compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &MemoryServiceRegistry{services: services}, nil
+}
+
+// Match returns the first registered service matching the service url
+func (r *MemoryServiceRegistry) Match(service string) (*RegisteredService, bool) {
+	for _, s := range r.services {
+		if s.Matches(service) {
+			return s, true
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,68 @@
+package casserver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryServiceRegistry(t *testing.T) {
+	r, err := NewMemoryServiceRegistry(
+		&RegisteredService{Name: "exact", Pattern: "https://exact.example.com/"},
+		&RegisteredService{Name: "pattern", Pattern: `^https://[a-z]+\.example\.com/.*`},
+		&RegisteredService{Name: "prefix", Pattern: `^https://prefix.example.com`},
+	)
+	require.NoError(t, err)
+
+	cases := map[string]string{
+		"https://exact.example.com/":        "exact",
+		"https://exact.example.com/page":    "pattern",
+		"https://app.example.com/?q=1":      "pattern",
+		"https://app.example.com.evil.com/": "",
+		"http://app.example.com/":           "",
+		"https://prefix.example.com":        "prefix",
+		"https://prefix.example.com.evil/":  "",
+	}
+
+	for service, name := range cases {
+		rs, ok := r.Match(service)
+		if name == "" {
+			assert.False(t, ok, service)
+			continue
+		}
+
+		require.True(t, ok, service)
+		assert.Equal(t, name, rs.Name, service)
+	}
+}
+
+func TestMemoryServiceRegistryInvalidPattern(t *testing.T) {
+	_, err := NewMemoryServiceRegistry(&RegisteredService{Name: "broken", Pattern: "^https://("})
+	assert.Error(t, err)
+
+	_, err = NewMemoryServiceRegistry(&RegisteredService{Name: "empty"})
+	assert.Error(t, err)
+}
+
+func TestRegisteredServiceCompilesLazily(t *testing.T) {
+	// Services of custom registries are not compiled by NewMemoryServiceRegistry
+	s := &RegisteredService{Name: "custom", Pattern: `^https://app\.example\.com/.*`, ProxyCallbackPattern: `^https://app\.example\.com/pgt`}
+	assert.True(t, s.Matches("https://app.example.com/page"))
+	assert.False(t, s.Matches("https://app.example.com.evil/page"))
+	assert.True(t, s.allowsProxyCallback("https://app.example.com/pgt"))
+	assert.False(t, s.allowsProxyCallback("https://app.example.com/pgt/evil"))
+
+	broken := &RegisteredService{Name: "broken", Pattern: "^https://("}
+	assert.False(t, broken.Matches("https://("))
+	assert.Error(t, broken.Compile())
+}
+
+func TestReleasesAttribute(t *testing.T) {
+	all := &RegisteredService{}
+	assert.True(t, all.releasesAttribute("mail"))
+
+	some := &RegisteredService{ReleasedAttributes: []string{"mail"}}
+	assert.True(t, some.releasesAttribute("mail"))
+	assert.False(t, some.releasesAttribute("phone"))
+}
@@ -0,0 +1,177 @@
+package casserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// TicketRegistry errors
+var (
+	// The ticket does not exist, has expired or has already been used
+	ErrTicketNotFound = errors.New("casserver: ticket registry: ticket not found")
+)
+
+// TicketType identifies the kind of a Ticket.
+type TicketType int
+
+const (
+	TicketGrantingTicket TicketType = iota
+	ServiceTicket
+	ProxyGrantingTicket
+	ProxyTicket
+	LoginTicket
+)
+
+func (tt TicketType) String() string {
+	switch tt {
+	case TicketGrantingTicket:
+		return "TGT"
+	case ServiceTicket:
+		return "ST"
+	case ProxyGrantingTicket:
+		return "PGT"
+	case ProxyTicket:
+		return "PT"
+	case LoginTicket:
+		return "LT"
+	default:
+		return ""
+	}
+}
+
+// Ticket is a ticket issued by the server.
+type Ticket struct {
+	ID             string
+	Type           TicketType
+	Principal      *Principal // Authenticated user, nil for login tickets
+	Service        string     // Service the ticket was issued for, service and proxy tickets only
+	GrantingTicket string     // Ticket granting ticket of the SSO session the ticket belongs to
+	Proxies        []string   // Proxy callback urls the ticket was obtained through, most recent first
+	NewLogin       bool       // Whether the ticket was issued by a primary authentication
+	CreatedAt      time.Time
+	ExpiresAt      time.Time
+
+	// Services maps the service tickets issued by a ticket granting ticket to their service,
+	// and is used to send single logout requests.
+	Services map[string]string
+}
+
+// Expired reports whether the ticket has expired at the time now.
+func (t *Ticket) Expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && !now.Before(t.ExpiresAt)
+}
+
+// TicketRegistry provides an interface for storing the tickets issued by the server.
+//
+// Implementations must not return expired tickets.
+type TicketRegistry interface {
+	// Put stores or replaces a ticket.
+	Put(t *Ticket) error
+
+	// Get returns a ticket without removing it.
+	Get(id string) (*Ticket, error)
+
+	// Remove deletes a ticket and returns it. Only one caller can remove a ticket,
+	// which makes service and proxy tickets one-time-use.
+	Remove(id string) (*Ticket, error)
+}
+
+// MemoryTicketRegistry implements the TicketRegistry interface storing tickets in memory.
+//
+// Expired tickets are evicted when accessed and by a periodic sweep when tickets are added.
+type MemoryTicketRegistry struct {
+	mu        sync.Mutex
+	tickets   map[string]*Ticket
+	lastSweep time.Time
+}
+
+// memoryRegistrySweepInterval is the minimum time between sweeps of expired tickets
+const memoryRegistrySweepInterval = time.Minute
+
+// NewMemoryTicketRegistry creates a MemoryTicketRegistry.
+func NewMemoryTicketRegistry() *MemoryTicketRegistry {
+	return &MemoryTicketRegistry{
+		tickets:   make(map[string]*Ticket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Put stores or replaces a ticket
+func (r *MemoryTicketRegistry) Put(t *Ticket) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.lastSweep) >= memoryRegistrySweepInterval {
+		r.sweep(now)
+	}
+
+	r.tickets[t.ID] = t
+	return nil
+}
+
+// Get returns a ticket without removing it
+func (r *MemoryTicketRegistry) Get(id string) (*Ticket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.tickets[id]
+	if !ok {
+		return nil, ErrTicketNotFound
+	}
+
+	if t.Expired(time.Now()) {
+		delete(r.tickets, id)
+		return nil, ErrTicketNotFound
+	}
+
+	return t, nil
+}
+
+// Remove deletes a ticket and returns it
+func (r *MemoryTicketRegistry) Remove(id string) (*Ticket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.tickets[id]
+	if !ok {
+		return nil, ErrTicketNotFound
+	}
+
+	delete(r.tickets, id)
+
+	if t.Expired(time.Now()) {
+		return nil, ErrTicketNotFound
+	}
+
+	return t, nil
+}
+
+// sweep removes all expired tickets, the caller must hold the lock.
+func (r *MemoryTicketRegistry) sweep(now time.Time) {
+	for id, t := range r.tickets {
+		if t.Expired(now) {
+			delete(r.tickets, id)
+		}
+	}
+
+	r.lastSweep = now
+}
+
+// newTicketID generates a ticket identifier for the ticket type.
+func newTicketID(tt TicketType) (string, error) {
+	return newID(tt.String())
+}
+
+// newID generates a random identifier with the given prefix.
+func newID(prefix string) (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return prefix + "-" + hex.EncodeToString(bytes), nil
+}
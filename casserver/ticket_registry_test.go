@@ -0,0 +1,59 @@
+package casserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryTicketRegistry(t *testing.T) {
+	r := NewMemoryTicketRegistry()
+
+	st := &Ticket{ID: "ST-1", Type: ServiceTicket, ExpiresAt: time.Now().Add(time.Minute)}
+	require.NoError(t, r.Put(st))
+
+	got, err := r.Get("ST-1")
+	require.NoError(t, err)
+	assert.Equal(t, st, got)
+
+	got, err = r.Remove("ST-1")
+	require.NoError(t, err)
+	assert.Equal(t, st, got)
+
+	_, err = r.Remove("ST-1")
+	assert.Equal(t, ErrTicketNotFound, err)
+
+	_, err = r.Get("ST-1")
+	assert.Equal(t, ErrTicketNotFound, err)
+}
+
+func TestMemoryTicketRegistryExpiry(t *testing.T) {
+	r := NewMemoryTicketRegistry()
+
+	require.NoError(t, r.Put(&Ticket{ID: "ST-1", ExpiresAt: time.Now().Add(-time.Second)}))
+	require.NoError(t, r.Put(&Ticket{ID: "ST-2", ExpiresAt: time.Now().Add(-time.Second)}))
+	require.NoError(t, r.Put(&Ticket{ID: "TGT-1"}))
+
+	_, err := r.Get("ST-1")
+	assert.Equal(t, ErrTicketNotFound, err)
+
+	_, err = r.Remove("ST-2")
+	assert.Equal(t, ErrTicketNotFound, err)
+
+	// Tickets without an expiry time never expire
+	_, err = r.Get("TGT-1")
+	assert.NoError(t, err)
+}
+
+func TestMemoryTicketRegistrySweep(t *testing.T) {
+	r := NewMemoryTicketRegistry()
+	require.NoError(t, r.Put(&Ticket{ID: "ST-1", ExpiresAt: time.Now().Add(-time.Second)}))
+
+	r.lastSweep = time.Now().Add(-memoryRegistrySweepInterval)
+	require.NoError(t, r.Put(&Ticket{ID: "ST-2"}))
+
+	assert.NotContains(t, r.tickets, "ST-1")
+	assert.Contains(t, r.tickets, "ST-2")
+}
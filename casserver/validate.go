@@ -0,0 +1,251 @@
+package casserver
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mattmohan-flipp/cas/v2"
+)
+
+// handleValidate implements the CAS 1 validate endpoint.
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	t, _, err := s.consumeTicket(r, false)
+	if err != nil {
+		fmt.Fprint(w, "no\n\n")
+		return
+	}
+
+	fmt.Fprintf(w, "yes\n%s\n", t.Principal.Username)
+}
+
+// handleServiceValidate implements the CAS 2 and 3 serviceValidate and proxyValidate endpoints.
+//
+// Attributes are only released by the CAS 3 endpoints.
+func (s *Server) handleServiceValidate(w http.ResponseWriter, r *http.Request, acceptProxyTickets, cas3 bool) {
+	t, rs, err := s.consumeTicket(r, acceptProxyTickets)
+	if err != nil {
+		s.writeResponse(s.responses.WriteFailure(w, err.Code, err.Message))
+		return
+	}
+
+	success := &cas.AuthenticationResponse{User: t.Principal.Username, Proxies: t.Proxies}
+
+	if pgtUrl := r.FormValue("pgtUrl"); pgtUrl != "" {
+		success.ProxyGrantingTicket = s.grantProxyGrantingTicket(t, rs, pgtUrl)
+	}
+
+	if cas3 {
+		s.releaseAttributes(success, t, rs)
+	}
+
+	s.logger.Info("ticket validated",
+		slog.String("ticket", t.ID),
+		slog.String("service", t.Service),
+		slog.String("username", t.Principal.Username))
+
+	s.writeResponse(s.responses.WriteSuccess(w, success))
+}
+
+// handleProxy issues proxy tickets for proxy granting tickets.
+func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
+	pgtID := r.FormValue("pgt")
+	targetService := r.FormValue("targetService")
+	if pgtID == "" || targetService == "" {
+		s.writeResponse(s.responses.WriteProxyFailure(w, cas.INVALID_REQUEST, "'pgt' and 'targetService' parameters are both required"))
+		return
+	}
+
+	pgt, err := s.tickets.Get(pgtID)
+	if err == nil && pgt.Type != ProxyGrantingTicket {
+		err = ErrTicketNotFound
+	}
+	if err == nil {
+		// The proxy granting ticket ends with the SSO session it was obtained from
+		_, err = s.tickets.Get(pgt.GrantingTicket)
+	}
+	if err != nil {
+		s.writeResponse(s.responses.WriteProxyFailure(w, cas.INVALID_TICKET, fmt.Sprintf("Ticket %s not recognized", pgtID)))
+		return
+	}
+
+	if _, ok := s.services.Match(targetService); !ok {
+		s.writeResponse(s.responses.WriteProxyFailure(w, cas.UNAUTHORIZED_SERVICE, fmt.Sprintf("Service %s is not authorized to use CAS", targetService)))
+		return
+	}
+
+	now := time.Now()
+	pt := &Ticket{
+		Type:           ProxyTicket,
+		Principal:      pgt.Principal,
+		Service:        targetService,
+		GrantingTicket: pgt.GrantingTicket,
+		Proxies:        pgt.Proxies,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(s.serviceTicketTTL),
+	}
+
+	if err := s.issue(pt); err != nil {
+		s.logger.Error("create proxy ticket", slog.String("error", err.Error()))
+		s.writeResponse(s.responses.WriteProxyFailure(w, cas.INTERNAL_ERROR, "Unable to create proxy ticket"))
+		return
+	}
+
+	s.writeResponse(s.responses.WriteProxySuccess(w, pt.ID))
+}
+
+// consumeTicket removes the requested ticket and checks it may be validated for the requested service.
+func (s *Server) consumeTicket(r *http.Request, acceptProxyTickets bool) (*Ticket, *RegisteredService, *cas.AuthenticationError) {
+	id := r.FormValue("ticket")
+	service := r.FormValue("service")
+	if id == "" || service == "" {
+		return nil, nil, &cas.AuthenticationError{Code: cas.INVALID_REQUEST, Message: "'service' and 'ticket' parameters are both required"}
+	}
+
+	notRecognized := &cas.AuthenticationError{Code: cas.INVALID_TICKET, Message: fmt.Sprintf("Ticket %s not recognized", id)}
+
+	// Check the type before removing so that other tickets cannot be destroyed through validation
+	t, err := s.tickets.Get(id)
+	if err != nil || (t.Type != ServiceTicket && t.Type != ProxyTicket) {
+		return nil, nil, notRecognized
+	}
+
+	t, err = s.tickets.Remove(id)
+	if err != nil {
+		return nil, nil, notRecognized
+	}
+
+	if t.Type == ProxyTicket && !acceptProxyTickets {
+		return nil, nil, &cas.AuthenticationError{Code: cas.INVALID_TICKET_SPEC, Message: fmt.Sprintf("Ticket %s is a proxy ticket", id)}
+	}
+
+	if t.Service != service {
+		return nil, nil, &cas.AuthenticationError{Code: cas.INVALID_SERVICE, Message: fmt.Sprintf("Ticket %s does not match supplied service", id)}
+	}
+
+	rs, ok := s.services.Match(service)
+	if !ok {
+		return nil, nil, &cas.AuthenticationError{Code: cas.INVALID_SERVICE, Message: fmt.Sprintf("Service %s is not authorized to use CAS", service)}
+	}
+
+	if r.FormValue("renew") == "true" && !t.NewLogin {
+		return nil, nil, &cas.AuthenticationError{Code: cas.INVALID_TICKET, Message: fmt.Sprintf("Ticket %s was not issued from a new login", id)}
+	}
+
+	// Tickets are only valid while their SSO session exists
+	if _, err := s.tickets.Get(t.GrantingTicket); err != nil {
+		return nil, nil, notRecognized
+	}
+
+	return t, rs, nil
+}
+
+// grantProxyGrantingTicket creates a PGT for the validated ticket and delivers it to the proxy callback.
+//
+// The PGT IOU is returned, or an empty string when the service may not proxy or the callback failed.
+func (s *Server) grantProxyGrantingTicket(t *Ticket, rs *RegisteredService, pgtUrl string) string {
+	log := s.logger.With(slog.String("service", t.Service), slog.String("pgtUrl", pgtUrl))
+
+	if !rs.AllowProxy {
+		log.Warn("proxy callback for service which may not proxy")
+		return ""
+	}
+
+	u, err := url.Parse(pgtUrl)
+	if err != nil || (u.Scheme != "https" && !(s.allowInsecureProxyCallback && u.Scheme == "http")) {
+		log.Warn("invalid proxy callback url")
+		return ""
+	}
+
+	// Only callbacks registered for the service are called, the server must not request arbitrary urls
+	if !rs.allowsProxyCallback(pgtUrl) {
+		log.Warn("proxy callback url not registered for service")
+		return ""
+	}
+
+	tgt, err := s.tickets.Get(t.GrantingTicket)
+	if err != nil {
+		return ""
+	}
+
+	now := time.Now()
+	pgt := &Ticket{
+		Type:           ProxyGrantingTicket,
+		Principal:      t.Principal,
+		GrantingTicket: t.GrantingTicket,
+		Proxies:        append([]string{pgtUrl}, t.Proxies...),
+		CreatedAt:      now,
+		ExpiresAt:      tgt.ExpiresAt,
+	}
+
+	pgt.ID, err = newTicketID(ProxyGrantingTicket)
+	if err != nil {
+		log.Error("create proxy granting ticket", slog.String("error", err.Error()))
+		return ""
+	}
+
+	iou, err := newID("PGTIOU")
+	if err != nil {
+		log.Error("create proxy granting ticket iou", slog.String("error", err.Error()))
+		return ""
+	}
+
+	q := u.Query()
+	q.Set("pgtIou", iou)
+	q.Set("pgtId", pgt.ID)
+	u.RawQuery = q.Encode()
+
+	resp, err := s.client.Get(u.String())
+	if err != nil {
+		log.Warn("proxy callback failed", slog.String("error", err.Error()))
+		return ""
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Warn("proxy callback failed", slog.Int("status", resp.StatusCode))
+		return ""
+	}
+
+	if err := s.tickets.Put(pgt); err != nil {
+		log.Error("store proxy granting ticket", slog.String("error", err.Error()))
+		return ""
+	}
+
+	return iou
+}
+
+// releaseAttributes adds the attributes of the ticket's principal which may be sent to the service to the response.
+func (s *Server) releaseAttributes(success *cas.AuthenticationResponse, t *Ticket, rs *RegisteredService) {
+	success.IsNewLogin = t.NewLogin
+
+	if tgt, err := s.tickets.Get(t.GrantingTicket); err == nil {
+		success.AuthenticationDate = tgt.CreatedAt
+	}
+
+	if rs.releasesAttribute("memberOf") {
+		success.MemberOf = t.Principal.MemberOf
+	}
+
+	for name, values := range t.Principal.Attributes {
+		if !rs.releasesAttribute(name) {
+			continue
+		}
+
+		if success.Attributes == nil {
+			success.Attributes = make(cas.UserAttributes)
+		}
+		success.Attributes[name] = values
+	}
+}
+
+// writeResponse logs service responses which could not be written.
+func (s *Server) writeResponse(err error) {
+	if err != nil {
+		s.logger.Error("write service response", slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,608 @@
+/*
+Package castest provides an embeddable CAS server for end-to-end testing of CAS clients.
+
+The server is built on net/http/httptest and implements the login and logout pages, the CAS 1, 2 and 3 validation
+endpoints, proxy ticket granting and validation, the REST v1 tickets endpoints and back-channel single logout.
+*/
+package castest
+
+import (
+	"crypto/rand"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattmohan-flipp/cas/v2"
+)
+
+const (
+	// TicketGrantingCookieName is the name of the SSO session cookie set by the login page
+	TicketGrantingCookieName = "CASTGC"
+
+	restTicketsPath = "/v1/tickets"
+)
+
+// ErrUnknownUser is returned when a ticket is requested for a user which has not been added to the server.
+var ErrUnknownUser = errors.New("castest: unknown user")
+
+// User is an account known to the test server.
+type User struct {
+	Username   string
+	Password   string
+	Attributes map[string][]string
+	MemberOf   []string
+}
+
+// Server is a CAS server for use in tests.
+type Server struct {
+	*httptest.Server
+
+	// AttributesStyle selects how attributes are rendered, defaults to cas.CasThreeZeroNamedAttributesStyle
+	AttributesStyle cas.AttributesStyle
+
+	// HTTPClient is used for proxy callbacks and single logout requests, defaults to http.DefaultClient
+	HTTPClient *http.Client
+
+	mu        sync.Mutex
+	users     map[string]*User
+	autoLogin string
+	tgts      map[string]*grantingTicket
+	tickets   map[string]*ticket
+	pgts      map[string]*proxyGrantingTicket
+}
+
+// grantingTicket is a SSO session, tracking the service tickets issued for single logout.
+type grantingTicket struct {
+	id       string
+	username string
+	issued   time.Time
+	services map[string]string // service ticket to service
+}
+
+// ticket is a service or proxy ticket which has not been validated yet.
+type ticket struct {
+	id       string
+	service  string
+	username string
+	tgt      string
+	newLogin bool
+	proxies  []string // proxy callback urls for proxy tickets, most recent first
+}
+
+type proxyGrantingTicket struct {
+	id       string
+	username string
+	proxies  []string
+}
+
+// NewServer starts and returns a new CAS test server. The caller should call Close when finished.
+func NewServer() *Server {
+	s := &Server{
+		users:   make(map[string]*User),
+		tgts:    make(map[string]*grantingTicket),
+		tickets: make(map[string]*ticket),
+		pgts:    make(map[string]*proxyGrantingTicket),
+	}
+	s.Server = httptest.NewServer(s)
+
+	return s
+}
+
+// AddUser adds an account to the server.
+func (s *Server) AddUser(user User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[user.Username] = &user
+}
+
+// AutoLogin makes the login page authenticate the user without asking for credentials.
+//
+// An empty username disables automatic login.
+func (s *Server) AutoLogin(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.autoLogin = username
+}
+
+// IssueServiceTicket creates a SSO session for the user and issues a service ticket for the service,
+// as if the user had logged in.
+func (s *Server) IssueServiceTicket(username, service string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[username]; !ok {
+		return "", ErrUnknownUser
+	}
+
+	tgt := s.newGrantingTicket(username)
+	return s.newServiceTicket(tgt, service, true).id, nil
+}
+
+// Logout ends all SSO sessions of the user and sends single logout requests to every service
+// a ticket was issued for.
+func (s *Server) Logout(username string) error {
+	s.mu.Lock()
+	var sessions []*grantingTicket
+	for id, tgt := range s.tgts {
+		if tgt.username == username {
+			sessions = append(sessions, tgt)
+			delete(s.tgts, id)
+		}
+	}
+	s.mu.Unlock()
+
+	var errs []error
+	for _, tgt := range sessions {
+		errs = append(errs, s.singleLogout(tgt))
+	}
+
+	return errors.Join(errs...)
+}
+
+// SendLogoutRequest posts a back-channel single logout request for the ticket to the service.
+func (s *Server) SendLogoutRequest(service, ticket string) error {
+	body, err := xml.Marshal(&xmlLogoutRequest{
+		ID:           newID("LR"),
+		Version:      "2.0",
+		IssueInstant: time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		NameID:       xmlNameID{Value: "@NOT_USED@"},
+		SessionIndex: ticket,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.httpClient().PostForm(service, url.Values{"logoutRequest": {string(body)}})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("castest: logout request to %s returned status code %v", service, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// ServeHTTP implements the CAS server endpoints.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/login":
+		s.handleLogin(w, r)
+	case r.URL.Path == "/logout":
+		s.handleLogout(w, r)
+	case r.URL.Path == "/validate":
+		s.handleValidate(w, r)
+	case r.URL.Path == "/serviceValidate":
+		s.handleServiceValidate(w, r, false, false)
+	case r.URL.Path == "/p3/serviceValidate":
+		s.handleServiceValidate(w, r, false, true)
+	case r.URL.Path == "/proxyValidate":
+		s.handleServiceValidate(w, r, true, false)
+	case r.URL.Path == "/p3/proxyValidate":
+		s.handleServiceValidate(w, r, true, true)
+	case r.URL.Path == "/proxy":
+		s.handleProxy(w, r)
+	case r.URL.Path == restTicketsPath || strings.HasPrefix(r.URL.Path, restTicketsPath+"/"):
+		s.handleRest(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	service := r.FormValue("service")
+	renew := r.FormValue("renew") == "true"
+	gateway := r.FormValue("gateway") == "true"
+
+	s.mu.Lock()
+
+	if r.Method == http.MethodPost {
+		user, ok := s.users[r.PostFormValue("username")]
+		if !ok || user.Password != r.PostFormValue("password") {
+			s.mu.Unlock()
+			w.WriteHeader(http.StatusUnauthorized)
+			loginForm.Execute(w, service)
+			return
+		}
+
+		s.login(w, r, user.Username, service)
+		return
+	}
+
+	if tgt := s.grantingTicketFromCookie(r); tgt != nil && !renew {
+		s.issueAndRedirect(w, r, tgt, service, false)
+		return
+	}
+
+	if s.autoLogin != "" && !gateway {
+		s.login(w, r, s.autoLogin, service)
+		return
+	}
+
+	s.mu.Unlock()
+
+	if gateway && service != "" {
+		http.Redirect(w, r, service, http.StatusFound)
+		return
+	}
+
+	loginForm.Execute(w, service)
+}
+
+// login creates a SSO session for the user and redirects to the service, the caller must hold the lock.
+func (s *Server) login(w http.ResponseWriter, r *http.Request, username, service string) {
+	tgt := s.newGrantingTicket(username)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     TicketGrantingCookieName,
+		Value:    tgt.id,
+		Path:     "/",
+		HttpOnly: true,
+	})
+
+	s.issueAndRedirect(w, r, tgt, service, true)
+}
+
+// issueAndRedirect issues a service ticket and redirects to the service, the caller must hold the lock
+// which is released before responding.
+func (s *Server) issueAndRedirect(w http.ResponseWriter, r *http.Request, tgt *grantingTicket, service string, newLogin bool) {
+	if service == "" {
+		s.mu.Unlock()
+		fmt.Fprintf(w, "Logged in as %s\n", template.HTMLEscapeString(tgt.username))
+		return
+	}
+
+	st := s.newServiceTicket(tgt, service, newLogin)
+	s.mu.Unlock()
+
+	u, err := url.Parse(service)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q := u.Query()
+	q.Set("ticket", st.id)
+	u.RawQuery = q.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	tgt := s.grantingTicketFromCookie(r)
+	if tgt != nil {
+		delete(s.tgts, tgt.id)
+	}
+	s.mu.Unlock()
+
+	if tgt != nil {
+		s.singleLogout(tgt)
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: TicketGrantingCookieName, Path: "/", MaxAge: -1})
+
+	if service := r.FormValue("service"); service != "" {
+		http.Redirect(w, r, service, http.StatusFound)
+		return
+	}
+
+	fmt.Fprintln(w, "Logged out")
+}
+
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	t, code := s.consumeTicket(r, false)
+	if code != "" {
+		fmt.Fprintf(w, "no\n\n")
+		return
+	}
+
+	fmt.Fprintf(w, "yes\n%s\n", t.username)
+}
+
+func (s *Server) handleServiceValidate(w http.ResponseWriter, r *http.Request, acceptProxyTickets, cas3 bool) {
+	t, code := s.consumeTicket(r, acceptProxyTickets)
+	if code != "" {
+		s.responses().WriteFailure(w, code, failureMessage(code, r))
+		return
+	}
+
+	s.mu.Lock()
+	user, ok := s.users[t.username]
+	s.mu.Unlock()
+
+	if !ok {
+		s.responses().WriteFailure(w, cas.INVALID_TICKET, failureMessage(cas.INVALID_TICKET, r))
+		return
+	}
+
+	success := &cas.AuthenticationResponse{User: t.username, Proxies: t.proxies}
+
+	if pgtUrl := r.FormValue("pgtUrl"); pgtUrl != "" {
+		success.ProxyGrantingTicket = s.grantProxyGrantingTicket(t, pgtUrl)
+	}
+
+	s.addAttributes(success, user, t, cas3)
+
+	s.responses().WriteSuccess(w, success)
+}
+
+func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
+	pgtID := r.FormValue("pgt")
+	targetService := r.FormValue("targetService")
+	if pgtID == "" || targetService == "" {
+		s.responses().WriteProxyFailure(w, cas.INVALID_REQUEST, "'pgt' and 'targetService' parameters are both required")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pgt, ok := s.pgts[pgtID]
+	if !ok {
+		s.responses().WriteProxyFailure(w, cas.INVALID_TICKET, fmt.Sprintf("Ticket %s not recognized", pgtID))
+		return
+	}
+
+	pt := &ticket{
+		id:       newID("PT"),
+		service:  targetService,
+		username: pgt.username,
+		proxies:  pgt.proxies,
+	}
+	s.tickets[pt.id] = pt
+
+	s.responses().WriteProxySuccess(w, pt.id)
+}
+
+func (s *Server) handleRest(w http.ResponseWriter, r *http.Request) {
+	tgtID := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, restTicketsPath), "/")
+
+	s.mu.Lock()
+
+	switch {
+	case tgtID == "" && r.Method == http.MethodPost:
+		user, ok := s.users[r.PostFormValue("username")]
+		if !ok || user.Password != r.PostFormValue("password") {
+			s.mu.Unlock()
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		tgt := s.newGrantingTicket(user.Username)
+		s.mu.Unlock()
+
+		w.Header().Set("Location", s.URL+restTicketsPath+"/"+tgt.id)
+		w.WriteHeader(http.StatusCreated)
+	case tgtID != "" && r.Method == http.MethodPost:
+		tgt, ok := s.tgts[tgtID]
+		service := r.PostFormValue("service")
+		if !ok || service == "" {
+			s.mu.Unlock()
+			http.NotFound(w, r)
+			return
+		}
+
+		st := s.newServiceTicket(tgt, service, true)
+		s.mu.Unlock()
+
+		fmt.Fprint(w, st.id)
+	case tgtID != "" && r.Method == http.MethodDelete:
+		tgt, ok := s.tgts[tgtID]
+		delete(s.tgts, tgtID)
+		s.mu.Unlock()
+
+		if ok {
+			s.singleLogout(tgt)
+		}
+
+		w.WriteHeader(http.StatusOK)
+	default:
+		s.mu.Unlock()
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// consumeTicket removes the requested ticket and checks it may be validated for the requested service.
+//
+// A CAS error code is returned when the ticket is not valid.
+func (s *Server) consumeTicket(r *http.Request, acceptProxyTickets bool) (*ticket, string) {
+	id := r.FormValue("ticket")
+	service := r.FormValue("service")
+	if id == "" || service == "" {
+		return nil, cas.INVALID_REQUEST
+	}
+
+	s.mu.Lock()
+	t, ok := s.tickets[id]
+	delete(s.tickets, id)
+	s.mu.Unlock()
+
+	switch {
+	case !ok:
+		return nil, cas.INVALID_TICKET
+	case !acceptProxyTickets && len(t.proxies) > 0:
+		return nil, cas.INVALID_TICKET_SPEC
+	case t.service != service:
+		return nil, cas.INVALID_SERVICE
+	case r.FormValue("renew") == "true" && !t.newLogin:
+		return nil, cas.INVALID_TICKET
+	}
+
+	return t, ""
+}
+
+// grantProxyGrantingTicket creates a PGT for the ticket and delivers it to the proxy callback.
+//
+// The PGT IOU is returned, or an empty string when the callback could not be reached.
+func (s *Server) grantProxyGrantingTicket(t *ticket, pgtUrl string) string {
+	pgt := &proxyGrantingTicket{
+		id:       newID("PGT"),
+		username: t.username,
+		proxies:  append([]string{pgtUrl}, t.proxies...),
+	}
+	iou := newID("PGTIOU")
+
+	u, err := url.Parse(pgtUrl)
+	if err != nil {
+		return ""
+	}
+
+	q := u.Query()
+	q.Set("pgtIou", iou)
+	q.Set("pgtId", pgt.id)
+	u.RawQuery = q.Encode()
+
+	resp, err := s.httpClient().Get(u.String())
+	if err != nil {
+		return ""
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+
+	s.mu.Lock()
+	s.pgts[pgt.id] = pgt
+	s.mu.Unlock()
+
+	return iou
+}
+
+// addAttributes adds the user attributes released by the endpoint to the response.
+func (s *Server) addAttributes(success *cas.AuthenticationResponse, user *User, t *ticket, cas3 bool) {
+	// CAS 2 servers only release attributes in the RubyCAS style
+	if !cas3 && s.AttributesStyle != cas.RubyCasAttributesStyle {
+		return
+	}
+
+	if len(user.Attributes) > 0 {
+		success.Attributes = cas.UserAttributes(user.Attributes)
+	}
+
+	if !cas3 {
+		return
+	}
+
+	s.mu.Lock()
+	if tgt, ok := s.tgts[t.tgt]; ok {
+		success.AuthenticationDate = tgt.issued.UTC()
+	}
+	s.mu.Unlock()
+
+	success.IsNewLogin = t.newLogin
+	success.MemberOf = user.MemberOf
+}
+
+// responses returns the writer of service responses in the configured attributes style.
+func (s *Server) responses() *cas.ServiceResponseWriter {
+	return &cas.ServiceResponseWriter{AttributesStyle: s.AttributesStyle, Indent: 2}
+}
+
+// singleLogout sends logout requests for every service ticket issued by the SSO session.
+func (s *Server) singleLogout(tgt *grantingTicket) error {
+	var errs []error
+	for st, service := range tgt.services {
+		errs = append(errs, s.SendLogoutRequest(service, st))
+	}
+
+	return errors.Join(errs...)
+}
+
+// grantingTicketFromCookie returns the SSO session of the request, the caller must hold the lock.
+func (s *Server) grantingTicketFromCookie(r *http.Request) *grantingTicket {
+	cookie, err := r.Cookie(TicketGrantingCookieName)
+	if err != nil {
+		return nil
+	}
+
+	return s.tgts[cookie.Value]
+}
+
+// newGrantingTicket creates a SSO session, the caller must hold the lock.
+func (s *Server) newGrantingTicket(username string) *grantingTicket {
+	tgt := &grantingTicket{
+		id:       newID("TGT"),
+		username: username,
+		issued:   time.Now(),
+		services: make(map[string]string),
+	}
+	s.tgts[tgt.id] = tgt
+
+	return tgt
+}
+
+// newServiceTicket issues a service ticket from the SSO session, the caller must hold the lock.
+func (s *Server) newServiceTicket(tgt *grantingTicket, service string, newLogin bool) *ticket {
+	st := &ticket{
+		id:       newID("ST"),
+		service:  service,
+		username: tgt.username,
+		tgt:      tgt.id,
+		newLogin: newLogin,
+	}
+	s.tickets[st.id] = st
+	tgt.services[st.id] = service
+
+	return st
+}
+
+func (s *Server) httpClient() *http.Client {
+	if s.HTTPClient != nil {
+		return s.HTTPClient
+	}
+
+	return http.DefaultClient
+}
+
+func failureMessage(code string, r *http.Request) string {
+	switch code {
+	case cas.INVALID_REQUEST:
+		return "'service' and 'ticket' parameters are both required"
+	case cas.INVALID_TICKET_SPEC:
+		return fmt.Sprintf("Ticket %s is a proxy ticket", r.FormValue("ticket"))
+	case cas.INVALID_SERVICE:
+		return fmt.Sprintf("Ticket %s is not recognized for service %s", r.FormValue("ticket"), r.FormValue("service"))
+	default:
+		return fmt.Sprintf("Ticket %s not recognized", r.FormValue("ticket"))
+	}
+}
+
+// newID generates a ticket identifier with the given prefix.
+func newID(prefix string) string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+	bytes := make([]byte, 32)
+	rand.Read(bytes)
+
+	for k, v := range bytes {
+		bytes[k] = alphabet[v%byte(len(alphabet))]
+	}
+
+	return prefix + "-" + string(bytes)
+}
+
+var loginForm = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+  <body>
+    <form method="post" action="/login">
+      <input type="hidden" name="service" value="{{.}}">
+      <input type="text" name="username">
+      <input type="password" name="password">
+      <input type="submit" value="Login">
+    </form>
+  </body>
+</html>
+`))
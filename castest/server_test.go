@@ -0,0 +1,307 @@
+package castest_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mattmohan-flipp/cas/v2"
+	"github.com/mattmohan-flipp/cas/v2/castest"
+	"github.com/mattmohan-flipp/cas/v2/proxy"
+	"github.com/mattmohan-flipp/cas/v2/urlscheme"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer() *castest.Server {
+	server := castest.NewServer()
+	server.AddUser(castest.User{
+		Username:   "enoch.root",
+		Password:   "secret",
+		Attributes: map[string][]string{"mail": {"enoch@example.com"}},
+		MemberOf:   []string{"staff"},
+	})
+
+	return server
+}
+
+func newBrowser(t *testing.T) *http.Client {
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+
+	return &http.Client{Jar: jar}
+}
+
+func newApplication(casURL string, options *cas.Options) *httptest.Server {
+	options.URL, _ = url.Parse(casURL)
+	client := cas.NewClient(options)
+
+	return httptest.NewServer(client.HandleFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cas.IsAuthenticated(r) {
+			cas.RedirectToLogin(w, r)
+			return
+		}
+
+		fmt.Fprintf(w, "%s %s %v", cas.Username(r), cas.Attributes(r).Get("mail"), cas.MemberOf(r))
+	}))
+}
+
+func get(t *testing.T, client *http.Client, u string) (int, string) {
+	resp, err := client.Get(u)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp.StatusCode, string(body)
+}
+
+func TestLoginFlow(t *testing.T) {
+	for _, version := range []cas.ProtocolVersion{cas.ProtocolAuto, cas.ProtocolCAS3} {
+		t.Run(version.String(), func(t *testing.T) {
+			server := newTestServer()
+			defer server.Close()
+			server.AutoLogin("enoch.root")
+
+			app := newApplication(server.URL, &cas.Options{ProtocolVersion: version})
+			defer app.Close()
+
+			code, body := get(t, newBrowser(t), app.URL+"/")
+			assert.Equal(t, http.StatusOK, code)
+			assert.Equal(t, "enoch.root enoch@example.com [staff]", body)
+		})
+	}
+}
+
+func TestLoginFlowCas1(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+	server.AutoLogin("enoch.root")
+
+	app := newApplication(server.URL, &cas.Options{ProtocolVersion: cas.ProtocolCAS1})
+	defer app.Close()
+
+	code, body := get(t, newBrowser(t), app.URL+"/")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "enoch.root  []", body)
+}
+
+func TestLoginForm(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	app := newApplication(server.URL, &cas.Options{})
+	defer app.Close()
+
+	browser := newBrowser(t)
+	code, body := get(t, browser, app.URL+"/")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `<form method="post" action="/login">`)
+
+	resp, err := browser.PostForm(server.URL+"/login", url.Values{
+		"service":  {app.URL + "/"},
+		"username": {"enoch.root"},
+		"password": {"secret"},
+	})
+	require.NoError(t, err)
+	body2, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "enoch.root enoch@example.com [staff]", string(body2))
+}
+
+func TestServiceValidateFailures(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	ticket, err := server.IssueServiceTicket("enoch.root", "http://example.com/")
+	require.NoError(t, err)
+
+	validator := cas.NewServiceTicketValidator(cas.ServiceTicketValidatorOptions{CasURL: mustParse(server.URL)})
+
+	_, err = validator.ValidateTicket(mustParse("http://other.example.com/"), ticket, noProxy)
+	require.Error(t, err)
+	assert.Equal(t, cas.INVALID_SERVICE, err.(*cas.AuthenticationError).Code)
+
+	// Tickets can only be validated once
+	_, err = validator.ValidateTicket(mustParse("http://example.com/"), ticket, noProxy)
+	require.Error(t, err)
+	assert.Equal(t, cas.INVALID_TICKET, err.(*cas.AuthenticationError).Code)
+
+	_, err = server.IssueServiceTicket("arthur.dent", "http://example.com/")
+	assert.Equal(t, castest.ErrUnknownUser, err)
+}
+
+func TestRestFlow(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	client := cas.NewRestClient(&cas.RestOptions{
+		CasURL:     mustParse(server.URL),
+		ServiceURL: mustParse("http://example.com/api"),
+	})
+
+	tgt, err := client.RequestGrantingTicket("enoch.root", "secret")
+	require.NoError(t, err)
+
+	st, err := client.RequestServiceTicket(tgt)
+	require.NoError(t, err)
+
+	success, err := client.ValidateServiceTicket(st)
+	require.NoError(t, err)
+	assert.Equal(t, "enoch.root", success.User)
+
+	require.NoError(t, client.Logout(tgt))
+
+	_, err = client.RequestServiceTicket(tgt)
+	assert.Error(t, err)
+
+	_, err = client.RequestGrantingTicket("enoch.root", "wrong")
+	assert.Error(t, err)
+}
+
+func TestSingleLogout(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+	server.AutoLogin("enoch.root")
+
+	app := newApplication(server.URL, &cas.Options{})
+	defer app.Close()
+
+	browser := newBrowser(t)
+	code, _ := get(t, browser, app.URL+"/")
+	require.Equal(t, http.StatusOK, code)
+
+	require.NoError(t, server.Logout("enoch.root"))
+
+	// The application session is gone, and with the SSO session ended the login form is shown
+	server.AutoLogin("")
+	code, body := get(t, browser, app.URL+"/")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `<form method="post" action="/login">`)
+}
+
+func TestProxyFlow(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+
+	pgts := make(map[string]string)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pgts[r.URL.Query().Get("pgtIou")] = r.URL.Query().Get("pgtId")
+	}))
+	defer callback.Close()
+
+	ticket, err := server.IssueServiceTicket("enoch.root", "http://web.example.com/")
+	require.NoError(t, err)
+
+	resp, err := http.Get(server.URL + "/serviceValidate?" + url.Values{
+		"service": {"http://web.example.com/"},
+		"ticket":  {ticket},
+		"pgtUrl":  {callback.URL},
+	}.Encode())
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	success, err := cas.ParseServiceResponse(body)
+	require.NoError(t, err)
+	pgt, ok := pgts[success.ProxyGrantingTicket]
+	require.True(t, ok)
+
+	_, body2 := get(t, http.DefaultClient, server.URL+"/proxy?"+url.Values{"pgt": {pgt}, "targetService": {"http://api.example.com/"}}.Encode())
+	assert.Contains(t, body2, "<proxyTicket>PT-")
+
+	i := strings.Index(body2, "<proxyTicket>") + len("<proxyTicket>")
+	j := strings.Index(body2, "</proxyTicket>")
+	pt := body2[i:j]
+
+	validator := cas.NewServiceTicketValidator(cas.ServiceTicketValidatorOptions{
+		CasURL:             mustParse(server.URL),
+		AllowedProxyChains: []cas.ProxyChain{{callback.URL}},
+	})
+
+	success, err = validator.ValidateProxyTicket(mustParse("http://api.example.com/"), pt, noProxy)
+	require.NoError(t, err)
+	assert.Equal(t, "enoch.root", success.User)
+	assert.Equal(t, []string{callback.URL}, success.Proxies)
+}
+
+// handleProxyingApplication serves an application which accepts tickets proxied through the allowed chains
+// and calls the next service with a proxy ticket of its own.
+func handleProxyingApplication(t *testing.T, mux *http.ServeMux, appURL, casURL string, chains []cas.ProxyChain, next string) {
+	client := cas.NewClient(&cas.Options{
+		URL:                mustParse(casURL),
+		AllowedProxyChains: chains,
+		Proxy: proxy.NewProxy(urlscheme.NewDefaultURLScheme(mustParse(casURL)), &proxy.ProxyOptions{
+			RequestProxy:     true,
+			ProxyCallbackURL: appURL + "/pgtCallback",
+		}),
+	})
+
+	mux.HandleFunc("/pgtCallback", client.HandleProxyCallback)
+	mux.Handle("/", client.HandleFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cas.IsAuthenticated(r) {
+			cas.RedirectToLogin(w, r)
+			return
+		}
+
+		proxyClient, err := cas.NewProxyClient(r, &cas.ProxyTransportOptions{AllowedServices: []string{next}})
+		if !assert.NoError(t, err) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		code, body := get(t, proxyClient, next+"/")
+		fmt.Fprintf(w, "%s > %d %s", cas.Username(r), code, body)
+	}))
+}
+
+func TestMultiHopProxyFlow(t *testing.T) {
+	server := newTestServer()
+	defer server.Close()
+	server.AutoLogin("enoch.root")
+
+	webMux, apiMux, dataMux := http.NewServeMux(), http.NewServeMux(), http.NewServeMux()
+	web, api, data := httptest.NewServer(webMux), httptest.NewServer(apiMux), httptest.NewServer(dataMux)
+	defer web.Close()
+	defer api.Close()
+	defer data.Close()
+
+	// web -> api -> data, CAS reports the most recent proxy first
+	webCallback, apiCallback := web.URL+"/pgtCallback", api.URL+"/pgtCallback"
+	handleProxyingApplication(t, webMux, web.URL, server.URL, nil, api.URL)
+	handleProxyingApplication(t, apiMux, api.URL, server.URL, []cas.ProxyChain{{webCallback}}, data.URL)
+
+	dataClient := cas.NewClient(&cas.Options{
+		URL:                mustParse(server.URL),
+		AllowedProxyChains: []cas.ProxyChain{{apiCallback, webCallback}},
+	})
+	dataMux.Handle("/", dataClient.HandleFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cas.IsAuthenticated(r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, cas.Username(r))
+	}))
+
+	code, body := get(t, newBrowser(t), web.URL+"/")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "enoch.root > 200 enoch.root > 200 enoch.root", body)
+}
+
+var noProxy = proxy.NewProxy(urlscheme.NewDefaultURLScheme(&url.URL{}), &proxy.ProxyOptions{})
+
+func mustParse(s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
@@ -0,0 +1,19 @@
+package castest
+
+import (
+	"encoding/xml"
+)
+
+type xmlLogoutRequest struct {
+	XMLName      xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol LogoutRequest"`
+	ID           string   `xml:"ID,attr"`
+	Version      string   `xml:"Version,attr"`
+	IssueInstant string   `xml:"IssueInstant,attr"`
+	NameID       xmlNameID
+	SessionIndex string `xml:"SessionIndex"`
+}
+
+type xmlNameID struct {
+	XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:assertion NameID"`
+	Value   string   `xml:",chardata"`
+}
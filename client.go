@@ -1,9 +1,12 @@
 package cas
 
 import (
+	"context"
 	"crypto/rand"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 
 	"log/slog"
 
@@ -22,6 +25,24 @@ type Options struct {
 	SessionStore SessionStore
 	Logger       *slog.Logger // Optional logger
 	Proxy        *proxy.Proxy
+
+	RenewMaxAge     time.Duration   // How long a login satisfies Renew, defaults to 5 minutes
+	ProtocolVersion ProtocolVersion // CAS protocol used to validate tickets, defaults to ProtocolAuto
+	ResponseFormat  ResponseFormat  // Format requested for service responses, defaults to ResponseFormatXML
+
+	AllowedProxyChains []ProxyChain // Proxy chains allowed to present proxy tickets, enables proxyValidate
+	AcceptAnyProxy     bool         // Accept proxy tickets from any proxy chain, enables proxyValidate
+
+	ForbiddenHandler http.Handler // Replies to requests an Authorizer refuses, defaults to a 403 Forbidden
+	RoleMapper       *RoleMapper  // Maps groups and attributes to the roles returned by Roles and HasRole
+
+	Retry          *RetryOptions   // Retry ticket validation and proxy ticket requests failing transiently, no retries if nil
+	CircuitBreaker *CircuitBreaker // Fail requests fast while the CAS server is down, disabled if nil
+	FailoverURLs   []*url.URL      // Further CAS nodes, in order, used while the node at URL is down
+
+	// IPs and CIDRs of reverse proxies whose Forwarded and X-Forwarded-* headers determine the service url.
+	// Forwarding headers of other peers are ignored.
+	TrustedProxies []string
 }
 
 // Client implements the main protocol
@@ -38,6 +59,30 @@ type Client struct {
 	logger      *slog.Logger
 
 	proxy *proxy.Proxy
+
+	renewMaxAge time.Duration
+
+	forbiddenHandler http.Handler
+	roles            *RoleMapper
+
+	retry   *RetryOptions
+	breaker *CircuitBreaker
+
+	namespace string // prefixes the keys of the tenant in stores shared between tenants
+
+	trustedProxies trustedProxies
+}
+
+// newFailoverURLScheme creates a FailoverURLScheme for the primary and failover nodes. Invalid failover urls are
+// logged and the primary node is used alone.
+func newFailoverURLScheme(primary *url.URL, failover []*url.URL, logger *slog.Logger) urlscheme.URLScheme {
+	scheme, err := urlscheme.NewFailoverURLScheme(append([]*url.URL{primary}, failover...)...)
+	if err != nil {
+		logger.Error("Ignoring invalid failover urls", slog.Any("error", err))
+		return urlscheme.NewDefaultURLScheme(primary)
+	}
+
+	return scheme
 }
 
 // NewClient creates a Client with the provided Options.
@@ -64,6 +109,8 @@ func NewClient(options *Options) *Client {
 	var urlScheme urlscheme.URLScheme
 	if options.URLScheme != nil {
 		urlScheme = options.URLScheme
+	} else if len(options.FailoverURLs) > 0 {
+		urlScheme = newFailoverURLScheme(options.URL, options.FailoverURLs, options.Logger)
 	} else {
 		urlScheme = urlscheme.NewDefaultURLScheme(options.URL)
 	}
@@ -92,6 +139,26 @@ func NewClient(options *Options) *Client {
 		proxySettings = proxy.NewProxy(urlScheme, &proxy.ProxyOptions{})
 	}
 
+	stValidator := NewServiceTicketValidator(ServiceTicketValidatorOptions{
+		Client:          client,
+		CasURL:          options.URL,
+		URLScheme:       urlScheme,
+		Logger:          options.Logger,
+		ProtocolVersion: options.ProtocolVersion,
+		ResponseFormat:  options.ResponseFormat,
+
+		AllowedProxyChains: options.AllowedProxyChains,
+		AcceptAnyProxy:     options.AcceptAnyProxy,
+
+		Retry:          options.Retry,
+		CircuitBreaker: options.CircuitBreaker,
+	})
+
+	renewMaxAge := options.RenewMaxAge
+	if renewMaxAge == 0 {
+		renewMaxAge = defaultRenewMaxAge
+	}
+
 	return &Client{
 		tickets:     tickets,
 		client:      client,
@@ -99,9 +166,18 @@ func NewClient(options *Options) *Client {
 		cookie:      cookie,
 		sessions:    sessions,
 		sendService: options.SendService,
-		stValidator: NewServiceTicketValidator(ServiceTicketValidatorOptions{Client: client, CasURL: options.URL, Logger: options.Logger}),
+		stValidator: stValidator,
 		logger:      options.Logger,
 		proxy:       proxySettings,
+		renewMaxAge: renewMaxAge,
+
+		forbiddenHandler: options.ForbiddenHandler,
+		roles:            options.RoleMapper,
+
+		retry:   options.Retry,
+		breaker: options.CircuitBreaker,
+
+		trustedProxies: parseTrustedProxies(options.TrustedProxies, options.Logger),
 	}
 }
 
@@ -119,41 +195,49 @@ func (c *Client) HandleFunc(h func(http.ResponseWriter, *http.Request)) http.Han
 }
 
 // requestURL determines an absolute URL from the http.Request.
-func requestURL(r *http.Request) (*url.URL, error) {
+//
+// The forwarding headers of requests received from a trusted proxy describe the original request.
+func (c *Client) requestURL(r *http.Request) (*url.URL, error) {
 	u, err := url.Parse(r.URL.String())
 	if err != nil {
 		return nil, err
 	}
 
 	u.Host = r.Host
-	if host := r.Header.Get("X-Forwarded-Host"); host != "" {
-		u.Host = host
-	}
-
 	u.Scheme = "http"
-	if scheme := r.Header.Get("X-Forwarded-Proto"); scheme != "" {
-		u.Scheme = scheme
-	} else if r.TLS != nil {
+	if r.TLS != nil {
 		u.Scheme = "https"
 	}
 
+	c.trustedProxies.forwardedRequest(r).apply(u)
+
 	return u, nil
 }
 
 // LoginUrlForRequest determines the CAS login URL for the http.Request.
 func (c *Client) LoginUrlForRequest(r *http.Request) (string, error) {
+	return c.loginUrlForRequest(r, nil)
+}
+
+// loginUrlForRequest determines the CAS login URL for the http.Request with additional login parameters.
+func (c *Client) loginUrlForRequest(r *http.Request, params url.Values) (string, error) {
 	u, err := c.urlScheme.Login()
 	if err != nil {
 		return "", err
 	}
 
-	service, err := requestURL(r)
+	service, err := c.requestURL(r)
 	if err != nil {
 		return "", err
 	}
 
 	q := u.Query()
 	q.Add("service", sanitisedURLString(service))
+	for k, values := range params {
+		for _, v := range values {
+			q.Add(k, v)
+		}
+	}
 	u.RawQuery = q.Encode()
 
 	return u.String(), nil
@@ -167,7 +251,7 @@ func (c *Client) LogoutUrlForRequest(r *http.Request) (string, error) {
 	}
 
 	if c.sendService {
-		service, err := requestURL(r)
+		service, err := c.requestURL(r)
 		if err != nil {
 			return "", err
 		}
@@ -182,16 +266,25 @@ func (c *Client) LogoutUrlForRequest(r *http.Request) (string, error) {
 
 // ServiceValidateUrlForRequest determines the CAS serviceValidate URL for the ticket and http.Request.
 func (c *Client) ServiceValidateUrlForRequest(ticket string, r *http.Request) (string, error) {
-	service, err := requestURL(r)
+	service, err := c.requestURL(r)
 	if err != nil {
 		return "", err
 	}
 	return c.stValidator.ServiceValidateUrl(service, ticket, c.proxy)
 }
 
+// ProxyValidateUrlForRequest determines the CAS proxyValidate URL for the ticket and http.Request.
+func (c *Client) ProxyValidateUrlForRequest(ticket string, r *http.Request) (string, error) {
+	service, err := c.requestURL(r)
+	if err != nil {
+		return "", err
+	}
+	return c.stValidator.ProxyValidateUrl(service, ticket, c.proxy)
+}
+
 // ValidateUrlForRequest determines the CAS validate URL for the ticket and http.Request.
 func (c *Client) ValidateUrlForRequest(ticket string, r *http.Request) (string, error) {
-	service, err := requestURL(r)
+	service, err := c.requestURL(r)
 	if err != nil {
 		return "", err
 	}
@@ -232,18 +325,30 @@ func (c *Client) HandleProxyCallback(w http.ResponseWriter, r *http.Request) {
 }
 
 // validateTicket performs CAS ticket validation with the given ticket and service.
-func (c *Client) validateTicket(ticket string, service *http.Request) error {
-	serviceURL, err := requestURL(service)
+//
+// When renew is set the ticket must have been issued from a fresh primary authentication.
+// The validation is canceled when the context of the service request is done.
+func (c *Client) validateTicket(ticket string, service *http.Request, renew bool) error {
+	serviceURL, err := c.requestURL(service)
 	if err != nil {
 		return err
 	}
 
-	success, err := c.stValidator.ValidateTicket(serviceURL, ticket, c.proxy)
+	ctx := service.Context()
+
+	var success *AuthenticationResponse
+	if renew {
+		success, err = c.stValidator.ValidateRenewedTicketContext(ctx, serviceURL, ticket, c.proxy)
+	} else if c.stValidator.acceptsProxyTickets() {
+		success, err = c.stValidator.ValidateProxyTicketContext(ctx, serviceURL, ticket, c.proxy)
+	} else {
+		success, err = c.stValidator.ValidateTicketContext(ctx, serviceURL, ticket, c.proxy)
+	}
 	if err != nil {
 		return err
 	}
 
-	if err := c.tickets.Write(ticket, success); err != nil {
+	if err := c.writeTicket(ctx, ticket, success); err != nil {
 		return err
 	}
 
@@ -253,12 +358,17 @@ func (c *Client) validateTicket(ticket string, service *http.Request) error {
 // getSession finds or creates a session for the request.
 //
 // A cookie is set on the response if one is not provided with the request.
-// Validates the ticket if the URL parameter is provided.
+// Validates the ticket if the URL parameter is provided. While a renewed login is
+// pending the ticket takes precedence over the existing session.
 func (c *Client) getSession(w http.ResponseWriter, r *http.Request) {
 	cookie := c.getCookie(w, r)
+	ticket := r.URL.Query().Get("ticket")
+	renew := c.isRenewPending(r)
+	ctx := r.Context()
 
-	if s, ok := c.sessions.Get(cookie.Value); ok {
-		if t, err := c.tickets.Read(s); err == nil {
+	s, ok := c.getSessionTicket(ctx, cookie.Value)
+	if ok && !(renew && ticket != "") {
+		if t, err := c.readTicket(ctx, s); err == nil {
 			c.logger.Debug("Re-used ticket", slog.String("ticket", s), slog.String("for", t.User))
 
 			setAuthenticationResponse(r, t)
@@ -271,18 +381,32 @@ func (c *Client) getSession(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if ticket := r.URL.Query().Get("ticket"); ticket != "" {
-		if err := c.validateTicket(ticket, r); err != nil {
+	if ticket != "" {
+		if renew {
+			c.clearRenewCookie(w)
+		}
+
+		if err := c.validateTicket(ticket, r, renew); err != nil {
 			c.logger.Warn("Error validating ticket", slog.String("ticket", ticket), slog.Any("error", err))
 			return // allow ServeHTTP()
 		}
 
-		c.setSession(cookie.Value, ticket)
+		c.setSession(ctx, cookie.Value, ticket)
+
+		if ok && s != ticket {
+			c.logger.Info("Removing ticket replaced by renewed login", slog.String("ticket", s))
+			if err := c.deleteTicket(ctx, s); err != nil {
+				c.logger.Warn("Failed to remove replaced ticket", slog.String("ticket", s), slog.Any("error", err))
+			}
+		}
 
-		if t, err := c.tickets.Read(ticket); err == nil {
+		if t, err := c.readTicket(ctx, ticket); err == nil {
 			c.logger.Debug("Validated ticket", slog.String("ticket", ticket), slog.String("for", t.User))
 
 			setAuthenticationResponse(r, t)
+			if renew {
+				setRenewed(r)
+			}
 			return
 		} else {
 			c.logger.Warn("Failed to find ticket", slog.String("ticket", ticket), slog.Any("error", err))
@@ -295,12 +419,12 @@ func (c *Client) getSession(w http.ResponseWriter, r *http.Request) {
 
 // getCookie finds or creates the session cookie on the response.
 func (c *Client) getCookie(w http.ResponseWriter, r *http.Request) *http.Cookie {
-	cookie, err := r.Cookie(sessionCookieName)
+	cookie, err := r.Cookie(c.cookieName(sessionCookieName))
 	if err != nil {
 		// NOTE: Intentionally not enabling HttpOnly so the cookie can
 		//       still be used by Ajax requests.
 		cookie = &http.Cookie{
-			Name:     sessionCookieName,
+			Name:     c.cookieName(sessionCookieName),
 			Value:    newSessionID(),
 			Path:     c.cookie.Path,
 			Domain:   c.cookie.Domain,
@@ -334,6 +458,16 @@ func newSessionID() string {
 	return string(bytes)
 }
 
+// cookieName returns the name of a cookie of the client. Tenants of a MultiTenantClient suffix it with their name,
+// so that their cookies do not collide on a shared host.
+func (c *Client) cookieName(name string) string {
+	if c.namespace == "" {
+		return name
+	}
+
+	return name + "_" + strings.TrimSuffix(c.namespace, ":")
+}
+
 // clearCookie invalidates and removes the cookie from the client.
 func clearCookie(w http.ResponseWriter, c *http.Cookie) {
 	c.MaxAge = -1
@@ -341,29 +475,128 @@ func clearCookie(w http.ResponseWriter, c *http.Cookie) {
 }
 
 // setSession stores the session id to ticket mapping in the Client.
-func (c *Client) setSession(id string, ticket string) {
+func (c *Client) setSession(ctx context.Context, id string, ticket string) {
 
 	c.logger.Info("Recording session", slog.String("id", id), slog.String("ticket", ticket))
 
+	id, ticket = c.namespace+id, c.namespace+ticket
+	if s, ok := c.sessions.(ContextSessionStore); ok {
+		s.SetContext(ctx, id, ticket)
+		return
+	}
+
 	c.sessions.Set(id, ticket)
 }
 
 // clearSession removes the session from the client and clears the cookie.
 func (c *Client) clearSession(w http.ResponseWriter, r *http.Request) {
 	cookie := c.getCookie(w, r)
+	ctx := r.Context()
 
-	if serviceTicket, ok := c.sessions.Get(cookie.Value); ok {
-		if err := c.tickets.Delete(serviceTicket); err != nil {
+	if serviceTicket, ok := c.getSessionTicket(ctx, cookie.Value); ok {
+		if err := c.deleteTicket(ctx, serviceTicket); err != nil {
 			c.logger.Warn("Failed to remove ticket", slog.String("cookie", cookie.Value), slog.Any("error", err))
 		}
 
-		c.deleteSession(cookie.Value)
+		c.deleteSession(ctx, cookie.Value)
 	}
 
 	clearCookie(w, cookie)
 }
 
+// deleteTicket removes the ticket from the client, along with the IOU of its proxy granting ticket.
+//
+// The PGT is owned by the session of the ticket and must not outlive it.
+func (c *Client) deleteTicket(ctx context.Context, ticket string) error {
+	if t, err := c.readTicket(ctx, ticket); err == nil && t.ProxyGrantingTicket != "" {
+		if err := c.proxy.DeleteProxyTgt(t.ProxyGrantingTicket); err != nil {
+			c.logger.Warn("Failed to remove proxy granting ticket", slog.String("ticket", ticket), slog.Any("error", err))
+		}
+	}
+
+	ticket = c.namespace + ticket
+	if s, ok := c.tickets.(ContextTicketStore); ok {
+		return s.DeleteContext(ctx, ticket)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.tickets.Delete(ticket)
+}
+
 // deleteSession removes the session from the client
-func (c *Client) deleteSession(id string) {
+func (c *Client) deleteSession(ctx context.Context, id string) {
+	id = c.namespace + id
+	if s, ok := c.sessions.(ContextSessionStore); ok {
+		s.DeleteContext(ctx, id)
+		return
+	}
+
 	c.sessions.Delete(id)
 }
+
+// deleteSessionsByTicket removes all sessions bound to the ticket from the client, if the SessionStore indexes
+// sessions by ticket.
+func (c *Client) deleteSessionsByTicket(ctx context.Context, ticket string) error {
+	sessions, ok := c.sessions.(TicketIndexedSessionStore)
+	if !ok {
+		c.logger.Warn("session store does not index sessions by ticket, single logout cannot end the sessions")
+		return nil
+	}
+
+	ticket = c.namespace + ticket
+	if s, ok := sessions.(ContextTicketIndexedSessionStore); ok {
+		return s.DeleteByTicketContext(ctx, ticket)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return sessions.DeleteByTicket(ticket)
+}
+
+// getSessionTicket returns the ticket of the session, no ticket is found once the context is done.
+//
+// Sessions recorded by other tenants of a shared SessionStore are never found.
+func (c *Client) getSessionTicket(ctx context.Context, id string) (string, bool) {
+	var ticket string
+	var ok bool
+	if s, isContext := c.sessions.(ContextSessionStore); isContext {
+		ticket, ok = s.GetContext(ctx, c.namespace+id)
+	} else if ctx.Err() == nil {
+		ticket, ok = c.sessions.Get(c.namespace + id)
+	}
+
+	if !ok || !strings.HasPrefix(ticket, c.namespace) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(ticket, c.namespace), true
+}
+
+// readTicket returns the AuthenticationResponse of the ticket from the TicketStore.
+func (c *Client) readTicket(ctx context.Context, ticket string) (*AuthenticationResponse, error) {
+	ticket = c.namespace + ticket
+	if s, ok := c.tickets.(ContextTicketStore); ok {
+		return s.ReadContext(ctx, ticket)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return c.tickets.Read(ticket)
+}
+
+// writeTicket stores the AuthenticationResponse of the ticket in the TicketStore.
+func (c *Client) writeTicket(ctx context.Context, ticket string, success *AuthenticationResponse) error {
+	ticket = c.namespace + ticket
+	if s, ok := c.tickets.(ContextTicketStore); ok {
+		return s.WriteContext(ctx, ticket, success)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.tickets.Write(ticket, success)
+}
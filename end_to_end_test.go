@@ -0,0 +1,714 @@
+package cas_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mattmohan-flipp/cas/v2"
+	"github.com/mattmohan-flipp/cas/v2/castest"
+	"github.com/mattmohan-flipp/cas/v2/urlscheme"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type requestKey struct{}
+
+// contextTicketStore records the request values of the contexts it is called with.
+type contextTicketStore struct {
+	cas.MemoryStore
+	seen []interface{}
+}
+
+func (s *contextTicketStore) ReadContext(ctx context.Context, id string) (*cas.AuthenticationResponse, error) {
+	s.seen = append(s.seen, ctx.Value(requestKey{}))
+	return s.Read(id)
+}
+
+func (s *contextTicketStore) WriteContext(ctx context.Context, id string, ticket *cas.AuthenticationResponse) error {
+	s.seen = append(s.seen, ctx.Value(requestKey{}))
+	return s.Write(id, ticket)
+}
+
+func (s *contextTicketStore) DeleteContext(ctx context.Context, id string) error {
+	s.seen = append(s.seen, ctx.Value(requestKey{}))
+	return s.Delete(id)
+}
+
+func TestInvalidServiceTicket(t *testing.T) {
+	server := castest.NewServer()
+	defer server.Close()
+
+	url, _ := url.Parse(server.URL)
+	client := cas.NewClient(&cas.Options{
+		URL: url,
+	})
+
+	handler := client.HandleFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cas.IsAuthenticated(r) {
+			cas.RedirectToLogin(w, r)
+			return
+		}
+
+		fmt.Fprintln(w, "You are logged in, but you shouldn't be, oh noes!!")
+	})
+
+	req, err := http.NewRequest("GET", "http://example.com/?ticket=ST-l8d6b51d8e9c4569345a30e2f904626a1066384db8694784a60b515d62f6c", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusFound {
+		t.Errorf("Expected HTTP response code to be <%v>, got <%v>", http.StatusFound, w.Code)
+	}
+
+	loc := w.Header().Get("Location")
+	exp, _ := url.Parse("/login?service=http%3A%2F%2Fexample.com%2F")
+	if loc != exp.String() {
+		t.Errorf("Expected HTTP redirect to <%s>, got <%s>", exp, loc)
+	}
+
+	setCookie := w.Header().Get("Set-Cookie")
+	if !strings.HasPrefix(setCookie, cas.SessionCookieName) {
+		t.Errorf("Expected response to have Set-Cookie header with <%v>, got <%v>",
+			cas.SessionCookieName, setCookie)
+	}
+}
+
+func TestValidServiceTicket(t *testing.T) {
+	server := castest.NewServer()
+	defer server.Close()
+
+	server.AddUser(castest.User{Username: "TestValidServiceTicket"})
+	ticket, err := server.IssueServiceTicket("TestValidServiceTicket", "http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url, _ := url.Parse(server.URL)
+	client := cas.NewClient(&cas.Options{
+		URL: url,
+	})
+
+	message := "You are logged in, welcome client"
+	handler := client.HandleFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cas.IsAuthenticated(r) {
+			cas.RedirectToLogin(w, r)
+			return
+		}
+
+		fmt.Fprintln(w, message)
+	})
+
+	req, err := http.NewRequest("GET", "http://example.com/?ticket="+ticket, nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected HTTP response code to be <%v>, got <%v>", http.StatusOK, w.Code)
+	}
+
+	if message != strings.Trim(w.Body.String(), "\n") {
+		t.Errorf("Expected body to be <%s>, got <%s>", message, strings.Trim(w.Body.String(), "\n"))
+	}
+
+	setCookie := w.Header().Get("Set-Cookie")
+	if !strings.HasPrefix(setCookie, cas.SessionCookieName) {
+		t.Errorf("Expected response to have Set-Cookie header with <%v>, got <%v>",
+			cas.SessionCookieName, setCookie)
+	}
+}
+
+func TestGetUsernameFromServiceTicket(t *testing.T) {
+	server := castest.NewServer()
+	defer server.Close()
+
+	server.AddUser(castest.User{Username: "enoch.root"})
+	ticket, err := server.IssueServiceTicket("enoch.root", "http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url, _ := url.Parse(server.URL)
+	client := cas.NewClient(&cas.Options{
+		URL: url,
+	})
+
+	message := "You are logged in, welcome"
+	handler := client.HandleFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cas.IsAuthenticated(r) {
+			cas.RedirectToLogin(w, r)
+			return
+		}
+
+		user := cas.Username(r)
+		fmt.Fprintln(w, message, user)
+	})
+
+	req, err := http.NewRequest("GET", "http://example.com/?ticket="+ticket, nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected HTTP response code to be <%v>, got <%v>", http.StatusOK, w.Code)
+	}
+
+	expected := fmt.Sprintf("%s %s", message, "enoch.root")
+	if expected != strings.Trim(w.Body.String(), "\n") {
+		t.Errorf("Expected body to be <%s>, got <%s>", expected, strings.Trim(w.Body.String(), "\n"))
+	}
+
+	setCookie := w.Header().Get("Set-Cookie")
+	if !strings.HasPrefix(setCookie, cas.SessionCookieName) {
+		t.Errorf("Expected response to have Set-Cookie header with <%v>, got <%v>",
+			cas.SessionCookieName, setCookie)
+	}
+}
+
+func TestGetAttributesFromServiceTicket(t *testing.T) {
+	server := castest.NewServer()
+	defer server.Close()
+
+	server.AddUser(castest.User{
+		Username: "enoch.root",
+		Attributes: map[string][]string{
+			"admin":   {"true"},
+			"account": {"testing"},
+		},
+	})
+	ticket, err := server.IssueServiceTicket("enoch.root", "http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url, _ := url.Parse(server.URL)
+	client := cas.NewClient(&cas.Options{
+		URL: url,
+	})
+
+	message := "You are logged in, welcome %s%s, your account is %s"
+	handler := client.HandleFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cas.IsAuthenticated(r) {
+			cas.RedirectToLogin(w, r)
+			return
+		}
+
+		user := cas.Username(r)
+		attr := cas.Attributes(r)
+
+		admin := ""
+		if attr.Get("admin") == "true" {
+			admin = "Sir "
+		}
+
+		account := attr.Get("account")
+		fmt.Fprintf(w, message, admin, user, account)
+		fmt.Fprintf(w, "\n")
+	})
+
+	req, err := http.NewRequest("GET", "http://example.com/?ticket="+ticket, nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected HTTP response code to be <%v>, got <%v>", http.StatusOK, w.Code)
+	}
+
+	expected := fmt.Sprintf(message, "Sir ", "enoch.root", "testing")
+	if expected != strings.Trim(w.Body.String(), "\n") {
+		t.Errorf("Expected body to be <%s>, got <%s>", expected, strings.Trim(w.Body.String(), "\n"))
+	}
+
+	setCookie := w.Header().Get("Set-Cookie")
+	if !strings.HasPrefix(setCookie, cas.SessionCookieName) {
+		t.Errorf("Expected response to have Set-Cookie header with <%v>, got <%v>",
+			cas.SessionCookieName, setCookie)
+	}
+}
+
+func TestSecondRequestShouldBeCookied(t *testing.T) {
+	server := castest.NewServer()
+	defer server.Close()
+
+	server.AddUser(castest.User{
+		Username: "enoch.root",
+		Attributes: map[string][]string{
+			"admin":   {"true"},
+			"account": {"testing"},
+		},
+	})
+	ticket, err := server.IssueServiceTicket("enoch.root", "http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url, _ := url.Parse(server.URL)
+	client := cas.NewClient(&cas.Options{
+		URL: url,
+	})
+
+	message := "You are logged in, welcome %s%s, your account is %s"
+	handler := client.HandleFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cas.IsAuthenticated(r) {
+			cas.RedirectToLogin(w, r)
+			return
+		}
+
+		user := cas.Username(r)
+		attr := cas.Attributes(r)
+
+		admin := ""
+		if attr.Get("admin") == "true" {
+			admin = "Sir "
+		}
+
+		account := attr.Get("account")
+		fmt.Fprintf(w, message, admin, user, account)
+		fmt.Fprintf(w, "\n")
+	})
+
+	req, err := http.NewRequest("GET", "http://example.com/?ticket="+ticket, nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected First HTTP response code to be <%v>, got <%v>", http.StatusOK, w.Code)
+	}
+
+	setCookie := w.Header().Get("Set-Cookie")
+	if !strings.HasPrefix(setCookie, cas.SessionCookieName) {
+		t.Errorf("Expected response to have Set-Cookie header with <%v>, got <%v>",
+			cas.SessionCookieName, setCookie)
+	}
+
+	req, err = http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Parse response headers and add them to the new request
+	resp := http.Response{Header: w.Header()}
+	for _, cookie := range resp.Cookies() {
+		req.AddCookie(cookie)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected Second HTTP response code to be <%v>, got <%v>", http.StatusOK, w.Code)
+	}
+}
+
+func TestLogOut(t *testing.T) {
+	server := castest.NewServer()
+	defer server.Close()
+
+	server.AddUser(castest.User{Username: "enoch.root"})
+	ticket, err := server.IssueServiceTicket("enoch.root", "http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse(server.URL)
+	client := cas.NewClient(&cas.Options{
+		URL: u,
+	})
+
+	handler := client.HandleFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cas.IsAuthenticated(r) {
+			cas.RedirectToLogin(w, r)
+			return
+		}
+
+		if r.URL.Query().Get("logout") == "1" {
+			cas.RedirectToLogout(w, r)
+			return
+		}
+
+		fmt.Fprintln(w, "Welcome, you are logged in")
+	})
+
+	// Log them in
+	req, err := http.NewRequest("GET", "http://example.com/?ticket="+ticket, nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected First HTTP response code to be <%v>, got <%v>", http.StatusOK, w.Code)
+	}
+
+	setCookie := w.Header().Get("Set-Cookie")
+	if !strings.HasPrefix(setCookie, cas.SessionCookieName) {
+		t.Errorf("Expected response to have Set-Cookie header with <%v>, got <%v>",
+			cas.SessionCookieName, setCookie)
+	}
+
+	if _, err := client.TicketStore().Read(ticket); err != nil {
+		t.Errorf("Expected tickets.Read error to be nil, got %v", err)
+	}
+
+	// Request Logout
+	req, err = http.NewRequest("GET", "http://example.com/?logout=1", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Parse response headers and add them to the new request
+	resp := http.Response{Header: w.Header()}
+	for _, cookie := range resp.Cookies() {
+		req.AddCookie(cookie)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusFound {
+		t.Errorf("Expected Second HTTP response code to be <%v>, got <%v>", http.StatusOK, w.Code)
+	}
+
+	if _, err := client.TicketStore().Read(ticket); err != cas.ErrInvalidTicket {
+		t.Errorf("Expected tickets.Read error to be cas.ErrInvalidTicket, got %v", err)
+	}
+
+	expected := fmt.Sprintf("%s://%s/logout", u.Scheme, u.Host)
+	location := w.Header().Get("Location")
+	if location != expected {
+		t.Errorf("Expected Location to be %q, got %q", expected, location)
+	}
+
+	exists := false
+	resp = http.Response{Header: w.Header()}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name != cas.SessionCookieName {
+			continue
+		}
+
+		exists = true
+		if cookie.MaxAge != -1 {
+			t.Errorf("Expected cookie max age to be -1, got <%v> %v", cookie.MaxAge, cookie)
+		}
+	}
+
+	if !exists {
+		t.Errorf("Expected session cookie to exist")
+	}
+}
+
+func TestSingleLogOut(t *testing.T) {
+	server := castest.NewServer()
+	defer server.Close()
+
+	server.AddUser(castest.User{Username: "enoch.root"})
+	ticket, err := server.IssueServiceTicket("enoch.root", "http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse(server.URL)
+	client := cas.NewClient(&cas.Options{
+		URL: u,
+	})
+
+	handler := client.HandleFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cas.IsAuthenticated(r) {
+			cas.RedirectToLogin(w, r)
+			return
+		}
+
+		fmt.Fprintln(w, "Welcome, you are logged in")
+	})
+
+	// Log them in
+	req, err := http.NewRequest("GET", "http://example.com/?ticket="+ticket, nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected First HTTP response code to be <%v>, got <%v>", http.StatusOK, w.Code)
+	}
+
+	if _, err := client.TicketStore().Read(ticket); err != nil {
+		t.Errorf("Expected tickets.Read error to be nil, got %v", err)
+	}
+
+	var sessionID string
+	resp := http.Response{Header: w.Header()}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == cas.SessionCookieName {
+			sessionID = cookie.Value
+		}
+	}
+
+	if _, ok := client.SessionStore().Get(sessionID); !ok {
+		t.Errorf("Expected session %q to exist", sessionID)
+	}
+
+	// Single Logout Request
+	logoutRequest, err := cas.XMLLogoutRequest(ticket)
+	if err != nil {
+		t.Errorf("xmlLogoutRequest returned an error: %v", err)
+	}
+
+	postData := make(url.Values)
+	postData.Set("logoutRequest", string(logoutRequest))
+
+	req, err = http.NewRequest("POST", "http://example.com/any/path/in/the/application", strings.NewReader(postData.Encode()))
+	if err != nil {
+		t.Error(err)
+	}
+
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected Second HTTP response code to be <%v>, got <%v>", http.StatusOK, w.Code)
+	}
+
+	if _, err := client.TicketStore().Read(ticket); err != cas.ErrInvalidTicket {
+		t.Errorf("Expected tickets.Read error to be cas.ErrInvalidTicket, got %v", err)
+	}
+
+	if _, ok := client.SessionStore().Get(sessionID); ok {
+		t.Errorf("Expected session %q to be removed", sessionID)
+	}
+}
+
+func TestContextTicketStore(t *testing.T) {
+	server := castest.NewServer()
+	defer server.Close()
+
+	server.AddUser(castest.User{Username: "TestContextTicketStore"})
+	ticket, err := server.IssueServiceTicket("TestContextTicketStore", "http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url, _ := url.Parse(server.URL)
+	store := &contextTicketStore{}
+	client := cas.NewClient(&cas.Options{
+		URL:   url,
+		Store: store,
+	})
+
+	handler := client.HandleFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cas.IsAuthenticated(r) {
+			t.Errorf("Expected request to be authenticated")
+		}
+	})
+
+	req, _ := http.NewRequest("GET", "http://example.com/?ticket="+ticket, nil)
+	req = req.WithContext(context.WithValue(req.Context(), requestKey{}, "validate"))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(store.seen) != 2 || store.seen[0] != "validate" || store.seen[1] != "validate" {
+		t.Errorf("Expected ticket to be written and read with the request context, got <%v>", store.seen)
+	}
+
+	// A canceled request neither validates nor stores the ticket
+	ticket, _ = server.IssueServiceTicket("TestContextTicketStore", "http://example.com/")
+	client = cas.NewClient(&cas.Options{URL: url})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	req, _ = http.NewRequestWithContext(ctx, "GET", "http://example.com/?ticket="+ticket, nil)
+	handler = client.HandleFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cas.IsAuthenticated(r) {
+			t.Errorf("Expected canceled request not to be authenticated")
+		}
+	})
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if _, err := client.TicketStore().Read(ticket); err != cas.ErrInvalidTicket {
+		t.Errorf("Expected ticket of canceled request not to be stored, got <%v>", err)
+	}
+}
+
+func TestClientFailoverURLs(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	server := castest.NewServer()
+	defer server.Close()
+
+	server.AddUser(castest.User{Username: "TestClientFailoverURLs"})
+	ticket, err := server.IssueServiceTicket("TestClientFailoverURLs", "http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary, _ := url.Parse(down.URL + "/cas/")
+	secondary, _ := url.Parse(server.URL)
+	client := cas.NewClient(&cas.Options{
+		URL:          primary,
+		FailoverURLs: []*url.URL{secondary},
+	})
+
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	loc, _ := client.LoginUrlForRequest(req)
+	if !strings.HasPrefix(loc, down.URL+"/cas/login?") {
+		t.Errorf("Expected login to be pinned to the primary, got <%v>", loc)
+	}
+
+	handler := client.HandleFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cas.IsAuthenticated(r) {
+			t.Errorf("Expected request to be authenticated by the secondary")
+		}
+	})
+
+	req, _ = http.NewRequest("GET", "http://example.com/?ticket="+ticket, nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	loc, _ = client.LoginUrlForRequest(req)
+	if !strings.HasPrefix(loc, server.URL+"/login?") {
+		t.Errorf("Expected login to move to the secondary while the primary is down, got <%v>", loc)
+	}
+
+	// Invalid failover urls are ignored
+	client = cas.NewClient(&cas.Options{
+		URL:          primary,
+		FailoverURLs: []*url.URL{nil},
+	})
+
+	if _, ok := client.URLScheme().(*urlscheme.DefaultURLScheme); !ok {
+		t.Errorf("Expected a DefaultURLScheme for invalid failover urls, got <%T>", client.URLScheme())
+	}
+}
+
+func TestGatewayServesAuthenticatedUser(t *testing.T) {
+	server := castest.NewServer()
+	defer server.Close()
+
+	server.AddUser(castest.User{Username: "enoch.root"})
+	ticket, err := server.IssueServiceTicket("enoch.root", "http://example.com/page")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse(server.URL)
+	client := cas.NewClient(&cas.Options{
+		URL: u,
+	})
+
+	handler := client.Handle(client.Gateway(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Hello %s", cas.Username(r))
+	})))
+
+	req, err := http.NewRequest("GET", "http://example.com/page?ticket="+ticket, nil)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Hello enoch.root", w.Body.String())
+}
+
+func TestMultiTenantClientIsolatesSessions(t *testing.T) {
+	serverA := castest.NewServer()
+	defer serverA.Close()
+	serverB := castest.NewServer()
+	defer serverB.Close()
+
+	serverA.AddUser(castest.User{Username: "alice"})
+	serverB.AddUser(castest.User{Username: "bob"})
+
+	urlA, _ := url.Parse(serverA.URL)
+	urlB, _ := url.Parse(serverB.URL)
+
+	// Tenants sharing stores must not see each other's sessions
+	tickets := &cas.MemoryStore{}
+	sessions := cas.NewMemorySessionStore()
+	m := cas.NewMultiTenantClient(&cas.MultiTenantOptions{
+		Resolver: cas.TenantByPathPrefix(),
+		Tenants: map[string]*cas.Options{
+			"a": {URL: urlA, Store: tickets, SessionStore: sessions},
+			"b": {URL: urlB, Store: tickets, SessionStore: sessions},
+		},
+	})
+	require.NotNil(t, m.Client("a"))
+	assert.Nil(t, m.Client("c"))
+
+	var user, tenant string
+	handler := m.HandleFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, tenant = cas.Username(r), cas.Tenant(r)
+	})
+
+	serve := func(target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		user, tenant = "", ""
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	ticket, err := serverA.IssueServiceTicket("alice", "http://example.com/a/")
+	require.NoError(t, err)
+
+	w := serve("http://example.com/a/?ticket=" + ticket)
+	assert.Equal(t, "alice", user)
+	assert.Equal(t, "a", tenant)
+
+	cookies := w.Result().Cookies()
+	require.NotEmpty(t, cookies)
+	assert.Equal(t, cas.SessionCookieName+"_a", cookies[0].Name)
+
+	w = serve("http://example.com/a/", cookies...)
+	assert.Equal(t, "alice", user)
+
+	// The session of tenant a does not authenticate on tenant b
+	serve("http://example.com/b/", cookies...)
+	assert.Equal(t, "", user)
+	assert.Equal(t, "b", tenant)
+
+	// Neither does a ticket issued by the CAS server of tenant a
+	ticket, err = serverA.IssueServiceTicket("alice", "http://example.com/b/")
+	require.NoError(t, err)
+	serve("http://example.com/b/?ticket="+ticket, cookies...)
+	assert.Equal(t, "", user)
+
+	// A session id crafted to match the keys of another tenant is not found either
+	serve("http://example.com/b/", &http.Cookie{Name: cas.SessionCookieName + "_b", Value: "a:" + cookies[0].Value})
+	assert.Equal(t, "", user)
+
+	w = serve("http://example.com/c/")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
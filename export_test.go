@@ -0,0 +1,15 @@
+package cas
+
+import "github.com/mattmohan-flipp/cas/v2/urlscheme"
+
+// Internals used by the cas_test package, whose tests run against castest, which imports cas.
+
+const SessionCookieName = sessionCookieName
+
+var XMLLogoutRequest = xmlLogoutRequest
+
+func (c *Client) TicketStore() TicketStore { return c.tickets }
+
+func (c *Client) SessionStore() SessionStore { return c.sessions }
+
+func (c *Client) URLScheme() urlscheme.URLScheme { return c.urlScheme }
@@ -0,0 +1,113 @@
+package cas
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+)
+
+const validateSuccessResponse = `<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">
+  <cas:authenticationSuccess>
+    <cas:user>enoch.root</cas:user>
+  </cas:authenticationSuccess>
+</cas:serviceResponse>`
+
+// fakeCasServer is a CAS server for the tests of the package. It records every request and answers it with the
+// handler, unless the request is one of the first failures.
+type fakeCasServer struct {
+	*httptest.Server
+
+	failures      int // Number of first requests answered with failureStatus
+	failureStatus int
+
+	mu       sync.Mutex
+	requests []*url.URL
+}
+
+// newFakeCasServer creates a fakeCasServer answering every request with the handler.
+func newFakeCasServer(h http.HandlerFunc) *fakeCasServer {
+	return newFlakyCasServer(0, 0, h)
+}
+
+// newFlakyCasServer creates a fakeCasServer failing the first requests with the status before running the handler.
+func newFlakyCasServer(failures int, status int, h http.HandlerFunc) *fakeCasServer {
+	s := &fakeCasServer{failures: failures, failureStatus: status}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.URL)
+		n := len(s.requests)
+		s.mu.Unlock()
+
+		if n <= s.failures {
+			w.WriteHeader(s.failureStatus)
+			return
+		}
+		h(w, r)
+	}))
+
+	return s
+}
+
+// Count returns the number of requests received since the server started or was reset.
+func (s *fakeCasServer) Count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.requests)
+}
+
+// Paths returns the path of every request received since the server started or was reset.
+func (s *fakeCasServer) Paths() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	paths := make([]string, len(s.requests))
+	for i, u := range s.requests {
+		paths[i] = u.Path
+	}
+
+	return paths
+}
+
+// Queries returns the query of every request received since the server started or was reset.
+func (s *fakeCasServer) Queries() []url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queries := make([]url.Values, len(s.requests))
+	for i, u := range s.requests {
+		queries[i] = u.Query()
+	}
+
+	return queries
+}
+
+// Reset forgets the received requests, so the first requests fail again.
+func (s *fakeCasServer) Reset() {
+	s.mu.Lock()
+	s.requests = nil
+	s.mu.Unlock()
+}
+
+// serveValidation answers ticket validations on the given paths for enoch.root, other paths are not found.
+func serveValidation(paths ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range paths {
+			if r.URL.Path != p {
+				continue
+			}
+
+			if p == "/validate" {
+				fmt.Fprintf(w, "yes\nenoch.root\n")
+				return
+			}
+
+			fmt.Fprint(w, validateSuccessResponse)
+			return
+		}
+
+		http.NotFound(w, r)
+	}
+}
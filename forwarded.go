@@ -0,0 +1,213 @@
+package cas
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/mattmohan-flipp/cas/v2/internal/netutil"
+)
+
+// trustedProxies holds the networks of reverse proxies whose forwarding headers are honored.
+type trustedProxies []netip.Prefix
+
+// parseTrustedProxies parses the IPs and CIDRs of trusted proxies. Invalid entries are logged and not trusted.
+func parseTrustedProxies(entries []string, logger *slog.Logger) trustedProxies {
+	var networks trustedProxies
+	for _, entry := range entries {
+		network, err := netutil.ParseNetwork(entry)
+		if err != nil {
+			logger.Error("Ignoring invalid trusted proxy", slog.String("proxy", entry), slog.Any("error", err))
+			continue
+		}
+		networks = append(networks, network)
+	}
+
+	return networks
+}
+
+// contains determines whether the address, with an optional port, belongs to a trusted proxy.
+func (t trustedProxies) contains(address string) bool {
+	if len(t) == 0 {
+		return false
+	}
+
+	host := address
+	if h, _, err := net.SplitHostPort(address); err == nil {
+		host = h
+	}
+
+	addr, err := netip.ParseAddr(strings.Trim(host, "[]"))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, network := range t {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forwarded is the original request as described by the forwarding headers of trusted proxies.
+type forwarded struct {
+	proto  string
+	host   string
+	port   string
+	prefix string
+}
+
+// forwardedRequest reads the forwarding headers of a request received from a trusted proxy.
+//
+// The RFC 7239 Forwarded header takes precedence over X-Forwarded-Proto and X-Forwarded-Host. Its elements are
+// followed back through the trusted proxies to the one which received the request from the client. Of the
+// X-Forwarded-* headers the last value, set by the nearest proxy, is used.
+func (t trustedProxies) forwardedRequest(r *http.Request) forwarded {
+	var f forwarded
+	if !t.contains(r.RemoteAddr) {
+		return f
+	}
+
+	if elements := parseForwarded(r.Header.Values("Forwarded")); len(elements) > 0 {
+		i := len(elements) - 1
+		for i > 0 && t.contains(elements[i]["for"]) {
+			i--
+		}
+
+		f.proto = elements[i]["proto"]
+		f.host = elements[i]["host"]
+	} else {
+		f.proto = lastHeaderValue(r, "X-Forwarded-Proto")
+		f.host = lastHeaderValue(r, "X-Forwarded-Host")
+	}
+
+	f.port = lastHeaderValue(r, "X-Forwarded-Port")
+	f.prefix = lastHeaderValue(r, "X-Forwarded-Prefix")
+
+	return f
+}
+
+// parseForwarded parses the elements of RFC 7239 Forwarded headers, with lower case parameter names.
+func parseForwarded(headers []string) []map[string]string {
+	var elements []map[string]string
+	for _, header := range headers {
+		for _, element := range splitQuoted(header, ',') {
+			params := make(map[string]string)
+			for _, pair := range splitQuoted(element, ';') {
+				name, value, ok := strings.Cut(pair, "=")
+				if !ok {
+					continue
+				}
+
+				value = strings.TrimSpace(value)
+				if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+					value = unquote(value[1 : len(value)-1])
+				}
+				params[strings.ToLower(strings.TrimSpace(name))] = value
+			}
+			elements = append(elements, params)
+		}
+	}
+
+	return elements
+}
+
+// splitQuoted splits s at the separator outside of quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, escaped, start := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case s[i] == '\\' && quoted:
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+
+	return append(parts, strings.TrimSpace(s[start:]))
+}
+
+// unquote removes the escaping backslashes of a quoted string.
+func unquote(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
+
+func lastHeaderValue(r *http.Request, name string) string {
+	values := r.Header.Values(name)
+	if len(values) == 0 {
+		return ""
+	}
+
+	list := strings.Split(values[len(values)-1], ",")
+	return strings.TrimSpace(list[len(list)-1])
+}
+
+// apply rewrites the url of the request to the original request.
+func (f forwarded) apply(u *url.URL) {
+	if proto := strings.ToLower(f.proto); proto == "http" || proto == "https" {
+		u.Scheme = proto
+	}
+
+	if f.host != "" && isValidHost(f.host) {
+		u.Host = f.host
+	}
+
+	if f.port != "" && isValidPort(f.port) {
+		hostname := u.Hostname()
+		if strings.Contains(hostname, ":") {
+			hostname = "[" + hostname + "]"
+		}
+
+		u.Host = hostname
+		if !(u.Scheme == "http" && f.port == "80") && !(u.Scheme == "https" && f.port == "443") {
+			u.Host = net.JoinHostPort(u.Hostname(), f.port)
+		}
+	}
+
+	if prefix := strings.Trim(f.prefix, "/"); prefix != "" && !strings.ContainsAny(prefix, "?#\\") {
+		u.Path = "/" + prefix + u.Path
+		if u.RawPath != "" {
+			u.RawPath = "/" + prefix + u.RawPath
+		}
+	}
+}
+
+// isValidHost determines whether the host, with an optional port, is usable as the host of a url.
+func isValidHost(host string) bool {
+	u, err := url.Parse("//" + host)
+	return err == nil && u.Host == host && u.User == nil && u.Path == "" && u.Hostname() != ""
+}
+
+func isValidPort(port string) bool {
+	if len(port) == 0 || len(port) > 5 {
+		return false
+	}
+
+	for _, c := range port {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,103 @@
+package cas
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestURLForwarding(t *testing.T) {
+	casURL, _ := url.Parse("https://cas.example.com/")
+	client := NewClient(&Options{
+		URL:            casURL,
+		TrustedProxies: []string{"10.0.0.0/8", "2001:db8::1", "not-a-network"},
+	})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		{"direct", "203.0.113.7:5000", nil, "http://app.internal/reports?x=1"},
+		{"untrusted peer", "203.0.113.7:5000", map[string]string{
+			"X-Forwarded-Host":  "evil.example.com",
+			"X-Forwarded-Proto": "https",
+			"Forwarded":         "host=evil.example.com",
+		}, "http://app.internal/reports?x=1"},
+		{"x-forwarded", "10.1.2.3:5000", map[string]string{
+			"X-Forwarded-Host":   "app.example.com",
+			"X-Forwarded-Proto":  "https",
+			"X-Forwarded-Port":   "8443",
+			"X-Forwarded-Prefix": "/portal/",
+		}, "https://app.example.com:8443/portal/reports?x=1"},
+		{"default port", "10.1.2.3:5000", map[string]string{
+			"X-Forwarded-Host":  "app.example.com:8080",
+			"X-Forwarded-Proto": "https",
+			"X-Forwarded-Port":  "443",
+		}, "https://app.example.com/reports?x=1"},
+		{"last x-forwarded value", "10.1.2.3:5000", map[string]string{
+			"X-Forwarded-Host": "evil.example.com, app.example.com",
+		}, "http://app.example.com/reports?x=1"},
+		{"invalid values", "10.1.2.3:5000", map[string]string{
+			"X-Forwarded-Host":  "evil.example.com/phish",
+			"X-Forwarded-Proto": "javascript",
+			"X-Forwarded-Port":  "http",
+		}, "http://app.internal/reports?x=1"},
+		{"forwarded", "[2001:db8::1]:5000", map[string]string{
+			"Forwarded":        `for="[2001:db8::17]:4711";proto=https;host="app.example.com"`,
+			"X-Forwarded-Host": "other.example.com",
+		}, "https://app.example.com/reports?x=1"},
+		{"forwarded spoofed by client", "10.1.2.3:5000", map[string]string{
+			"Forwarded": "for=10.9.9.9;host=evil.example.com, for=203.0.113.7;proto=https;host=app.example.com",
+		}, "https://app.example.com/reports?x=1"},
+		{"forwarded through trusted proxies", "10.1.2.3:5000", map[string]string{
+			"Forwarded": "for=203.0.113.7;proto=https;host=app.example.com, for=10.0.0.5;host=edge.internal",
+		}, "https://app.example.com/reports?x=1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://app.internal/reports?x=1", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+
+			u, err := client.requestURL(r)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, u.String())
+		})
+	}
+}
+
+func TestForwardingUsedForServiceURLs(t *testing.T) {
+	casURL, _ := url.Parse("https://cas.example.com/")
+	client := NewClient(&Options{
+		URL:            casURL,
+		SendService:    true,
+		TrustedProxies: []string{"10.0.0.1"},
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "http://app.internal/", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+	r.Header.Set("Forwarded", "proto=https;host=app.example.com")
+
+	service := url.QueryEscape("https://app.example.com/")
+
+	login, err := client.LoginUrlForRequest(r)
+	require.NoError(t, err)
+	assert.Equal(t, "https://cas.example.com/login?service="+service, login)
+
+	logout, err := client.LogoutUrlForRequest(r)
+	require.NoError(t, err)
+	assert.Equal(t, "https://cas.example.com/logout?service="+service, logout)
+
+	validate, err := client.ServiceValidateUrlForRequest("ST-1", r)
+	require.NoError(t, err)
+	assert.Contains(t, validate, "service="+service)
+}
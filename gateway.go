@@ -0,0 +1,84 @@
+package cas
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+)
+
+const (
+	gatewayCookieName = "_cas_gateway"
+)
+
+// GatewayUrlForRequest determines the CAS login URL for a gateway (passive) authentication of the http.Request.
+//
+// CAS redirects back with a ticket when the user already has a SSO session and without one otherwise,
+// the user is never asked for credentials.
+func (c *Client) GatewayUrlForRequest(r *http.Request) (string, error) {
+	return c.loginUrlForRequest(r, url.Values{"gateway": {"true"}})
+}
+
+// RedirectToGateway replies to the request with a redirect URL to passively authenticate with CAS.
+func (c *Client) RedirectToGateway(w http.ResponseWriter, r *http.Request) {
+	u, err := c.GatewayUrlForRequest(r)
+	if err != nil {
+		c.logger.Error("Error generating gateway URL", slog.Any("error", err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	c.logger.Info("Checking for SSO session, redirecting client", slog.String("to", u), slog.Int("status", http.StatusFound))
+
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+// Gateway returns a http.Handler which passively authenticates users for pages with optional login.
+//
+// On the first unauthenticated visit the user is redirected to CAS with gateway=true. Users with an existing SSO
+// session return authenticated, all others return without a ticket and are served anonymously. A cookie records
+// that the check was done so that the redirect happens at most once per browser session.
+//
+// Like Handler, Gateway must be wrapped by Handle so that tickets are validated.
+func (c *Client) Gateway(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setClient(r, c)
+
+		if IsAuthenticated(r) || !isGatewayCandidate(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if _, err := r.Cookie(c.cookieName(gatewayCookieName)); err == nil {
+			c.logger.Debug("Gateway already attempted, serving anonymous request", slog.String("path", r.URL.Path))
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		c.setGatewayCookie(w)
+		c.RedirectToGateway(w, r)
+	})
+}
+
+// isGatewayCandidate determines whether a request may be redirected for gateway authentication.
+//
+// Only safe methods are redirected, and requests already carrying a ticket are left to the ticket validation.
+func isGatewayCandidate(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	return r.URL.Query().Get("ticket") == ""
+}
+
+// setGatewayCookie records on the client that a gateway authentication was attempted.
+func (c *Client) setGatewayCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.cookieName(gatewayCookieName),
+		Value:    "1",
+		Path:     c.cookie.Path,
+		Domain:   c.cookie.Domain,
+		HttpOnly: true,
+		Secure:   c.cookie.Secure,
+		SameSite: c.cookie.SameSite,
+	})
+}
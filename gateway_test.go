@@ -0,0 +1,71 @@
+package cas
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGatewayRedirectsOnce(t *testing.T) {
+	u, _ := url.Parse("https://cas.example.com/")
+	client := NewClient(&Options{
+		URL: u,
+	})
+
+	handler := client.Handle(client.Gateway(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsAuthenticated(r) {
+			fmt.Fprintf(w, "Hello %s", Username(r))
+			return
+		}
+
+		fmt.Fprint(w, "Hello anonymous")
+	})))
+
+	req, err := http.NewRequest("GET", "http://example.com/page?gateway=true", nil)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://cas.example.com/login?gateway=true&service=http%3A%2F%2Fexample.com%2Fpage", w.Header().Get("Location"))
+
+	// CAS returns without a ticket, the marker cookie prevents another redirect
+	req, err = http.NewRequest("GET", "http://example.com/page", nil)
+	require.NoError(t, err)
+
+	resp := http.Response{Header: w.Header()}
+	for _, cookie := range resp.Cookies() {
+		req.AddCookie(cookie)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Hello anonymous", w.Body.String())
+}
+
+func TestGatewayIgnoresUnsafeMethods(t *testing.T) {
+	u, _ := url.Parse("https://cas.example.com/")
+	client := NewClient(&Options{
+		URL: u,
+	})
+
+	handler := client.Handle(client.Gateway(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+
+	req, err := http.NewRequest("POST", "http://example.com/page", nil)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
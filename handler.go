@@ -1,8 +1,15 @@
 package cas
 
 import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"regexp"
 )
 
 const (
@@ -34,8 +41,13 @@ func (ch *clientHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 // isSingleLogoutRequest determines if the http.Request is a CAS Single Logout Request.
 //
-// The rules for a SLO request are, HTTP POST urlencoded form with a logoutRequest parameter.
+// The rules for a back-channel SLO request are, HTTP POST urlencoded form with a logoutRequest parameter.
+// Front-channel SLO requests are HTTP GET requests with a logoutRequest query parameter.
 func isSingleLogoutRequest(r *http.Request) bool {
+	if r.Method == "GET" {
+		return r.URL.Query().Get("logoutRequest") != ""
+	}
+
 	if r.Method != "POST" {
 		return false
 	}
@@ -54,23 +66,91 @@ func isSingleLogoutRequest(r *http.Request) bool {
 
 // performSingleLogout processes a single logout request
 func (ch *clientHandler) performSingleLogout(w http.ResponseWriter, r *http.Request) {
-	rawXML := r.FormValue("logoutRequest")
-	logoutRequest, err := parseLogoutRequest([]byte(rawXML))
+	rawXML, err := singleLogoutRequestXML(r)
+	if err != nil {
+		ch.c.logger.Error("error decoding logout request", slog.String("err", err.Error()))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logoutRequest, err := parseLogoutRequest(rawXML)
 
 	if err != nil {
 		ch.c.logger.Error("error parsing logout request", slog.String("err", err.Error()))
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if err := ch.c.tickets.Delete(logoutRequest.SessionIndex); err != nil {
+	if err := ch.c.deleteTicket(r.Context(), logoutRequest.SessionIndex); err != nil {
 		ch.c.logger.Error("error removing ticket", slog.String("err", err.Error()))
 
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	ch.c.deleteSession(logoutRequest.SessionIndex)
+	if err := ch.c.deleteSessionsByTicket(r.Context(), logoutRequest.SessionIndex); err != nil {
+		ch.c.logger.Error("error removing sessions", slog.String("err", err.Error()))
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if callback := r.URL.Query().Get("callback"); r.Method == "GET" && callback != "" {
+		writeLogoutCallback(w, callback)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// singleLogoutRequestXML extracts the logout request XML from a back-channel or front-channel SLO request.
+//
+// Front-channel requests carry the XML deflated and base64 encoded.
+func singleLogoutRequestXML(r *http.Request) ([]byte, error) {
+	if r.Method != "GET" {
+		return []byte(r.FormValue("logoutRequest")), nil
+	}
+
+	return decodeFrontChannelLogoutRequest(r.URL.Query().Get("logoutRequest"))
+}
+
+// maxLogoutRequestSize bounds the inflated size of front-channel logout requests
+const maxLogoutRequestSize = 64 << 10
+
+var errLogoutRequestTooLarge = errors.New("cas: logout request too large")
+
+// decodeFrontChannelLogoutRequest base64 decodes and inflates a front-channel logout request.
+//
+// Requests inflating to more than maxLogoutRequestSize are rejected.
+func decodeFrontChannelLogoutRequest(encoded string) ([]byte, error) {
+	compressed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(compressed)), maxLogoutRequestSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > maxLogoutRequestSize {
+		return nil, errLogoutRequestTooLarge
+	}
+
+	return data, nil
+}
+
+// jsonpCallbackPattern restricts JSONP callbacks to plain javascript identifiers.
+var jsonpCallbackPattern = regexp.MustCompile(`^[A-Za-z_$][0-9A-Za-z_$.]*$`)
+
+// writeLogoutCallback replies to a front-channel SLO request with a JSONP callback invocation.
+func writeLogoutCallback(w http.ResponseWriter, callback string) {
+	if !jsonpCallbackPattern.MatchString(callback) {
+		http.Error(w, "invalid callback", http.StatusBadRequest)
+		return
+	}
 
+	w.Header().Set("Content-Type", "application/javascript")
 	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "%s();", callback)
 }
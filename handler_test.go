@@ -0,0 +1,153 @@
+package cas
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/mattmohan-flipp/cas/v2/proxy"
+	"github.com/mattmohan-flipp/cas/v2/proxy/store"
+	"github.com/mattmohan-flipp/cas/v2/urlscheme"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encodeFrontChannelLogoutRequest(t *testing.T, ticket string) string {
+	logoutRequest, err := xmlLogoutRequest(ticket)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	require.NoError(t, err)
+	fw.Write(logoutRequest)
+	fw.Close()
+
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestFrontChannelSingleLogOut(t *testing.T) {
+	u, _ := url.Parse("https://cas.example.com/")
+	client := NewClient(&Options{
+		URL: u,
+	})
+
+	handler := client.HandleFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("front-channel logout request should not reach the handler")
+	})
+
+	tests := []struct {
+		name        string
+		callback    string
+		code        int
+		body        string
+		contentType string
+	}{
+		{"without callback", "", http.StatusOK, "", ""},
+		{"with callback", "jQuery1234_5678", http.StatusOK, "jQuery1234_5678();", "application/javascript"},
+		{"with invalid callback", "alert(1)//", http.StatusBadRequest, "invalid callback\n", "text/plain; charset=utf-8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ticket := "ST-front-channel"
+			client.tickets.Write(ticket, &AuthenticationResponse{User: "enoch.root"})
+
+			q := url.Values{}
+			q.Set("logoutRequest", encodeFrontChannelLogoutRequest(t, ticket))
+			if tt.callback != "" {
+				q.Set("callback", tt.callback)
+			}
+
+			req, err := http.NewRequest("GET", "http://example.com/any/path?"+q.Encode(), nil)
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.code, w.Code)
+			assert.Equal(t, tt.body, w.Body.String())
+			assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+
+			_, err = client.tickets.Read(ticket)
+			assert.Equal(t, ErrInvalidTicket, err)
+		})
+	}
+}
+
+func TestFrontChannelSingleLogOutInvalidEncoding(t *testing.T) {
+	u, _ := url.Parse("https://cas.example.com/")
+	client := NewClient(&Options{
+		URL: u,
+	})
+
+	handler := client.HandleFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	deflate := func(data []byte) string {
+		var buf bytes.Buffer
+		fw, err := flate.NewWriter(&buf, flate.BestCompression)
+		require.NoError(t, err)
+		fw.Write(data)
+		fw.Close()
+		return base64.StdEncoding.EncodeToString(buf.Bytes())
+	}
+
+	tests := []struct {
+		name          string
+		logoutRequest string
+	}{
+		{"invalid base64", "not-base64!"},
+		{"decompression bomb", deflate(make([]byte, 16<<20))},
+		{"invalid xml", deflate([]byte("<samlp:LogoutRequest"))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "http://example.com/?"+url.Values{"logoutRequest": {tt.logoutRequest}}.Encode(), nil)
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestSingleLogOutRemovesProxyGrantingTicket(t *testing.T) {
+	u, _ := url.Parse("https://cas.example.com/")
+	proxyStore := store.NewMemoryProxyStore()
+	defer proxyStore.Close()
+
+	client := NewClient(&Options{
+		URL: u,
+		Proxy: proxy.NewProxy(urlscheme.NewDefaultURLScheme(u), &proxy.ProxyOptions{
+			RequestProxy:     true,
+			ProxyCallbackURL: "https://example.com/pgtCallback",
+			ProxyStore:       proxyStore,
+		}),
+	})
+
+	handler := client.HandleFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("logout request should not reach the handler")
+	})
+
+	ticket := "ST-proxy"
+	client.tickets.Write(ticket, &AuthenticationResponse{User: "enoch.root", ProxyGrantingTicket: "PGTIOU-1"})
+	proxyStore.Set("PGTIOU-1", "PGT-1")
+
+	q := url.Values{}
+	q.Set("logoutRequest", encodeFrontChannelLogoutRequest(t, ticket))
+	req, err := http.NewRequest("GET", "http://example.com/?"+q.Encode(), nil)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	_, ok := proxyStore.Get("PGTIOU-1")
+	assert.False(t, ok)
+}
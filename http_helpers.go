@@ -2,14 +2,10 @@ package cas
 
 import (
 	"context"
-	"encoding/xml"
 	"errors"
-	"io"
 	"net/http"
 	"net/url"
 	"time"
-
-	"github.com/mattmohan-flipp/cas/v2/proxy"
 )
 
 type key int
@@ -17,6 +13,8 @@ type key int
 const ( // emulating enums is actually pretty ugly in go.
 	clientKey key = iota
 	authenticationResponseKey
+	tenantKey
+	renewedKey
 )
 
 // setClient associates a Client with a http.Request.
@@ -159,9 +157,11 @@ func MemberOf(r *http.Request) []string {
 
 var errNoClient = errors.New("cas: no client associated with request")
 var errNoAuthenticationResponse = errors.New("cas: no authentication response associated with request")
-var errProxyUrlError = errors.New("cas: error getting proxy url")
-var errProxyTicketError = errors.New("cas: error getting proxy ticket")
 
+// GetProxyTicket requests a proxy ticket for the target service using the proxy granting ticket of the request.
+//
+// A proxyFailure returned by the CAS server is reported as an *AuthenticationError. The request to the CAS
+// server is canceled when the context of the request is done.
 func GetProxyTicket(r *http.Request, targetService *url.URL) (string, error) {
 	// Get the client from the request context.
 	c := getClient(r)
@@ -174,32 +174,9 @@ func GetProxyTicket(r *http.Request, targetService *url.URL) (string, error) {
 	if a == nil {
 		return "", errNoAuthenticationResponse
 	}
-	if a.ProxyGrantingTicket == "" {
+	if a.ProxyGrantingTicket == "" && a.ProxyGrantingTicketID == "" {
 		return "", errors.New("cas: no proxy granting ticket available in authentication response")
 	}
 
-	url, err := c.proxy.GetProxyURL(targetService.String(), a.ProxyGrantingTicket)
-	if err != nil {
-		return "", err
-	}
-
-	proxyReq, err := c.client.Get(url)
-	if err != nil {
-		return "", err
-	}
-
-	response, err := io.ReadAll(proxyReq.Body)
-	defer proxyReq.Body.Close()
-	if err != nil {
-		return "", err
-	}
-
-	var proxyResponse proxy.XmlProxyResponse
-	xml.Unmarshal(response, &proxyResponse)
-
-	if proxyResponse.Success.ProxyTicket == "" {
-		return "", errProxyTicketError
-	}
-
-	return proxyResponse.Success.ProxyTicket, nil
+	return c.requestProxyTicket(r.Context(), a, targetService.String())
 }
@@ -0,0 +1,25 @@
+// Package netutil contains network helpers shared by the cas packages.
+package netutil
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// ParseNetwork parses a CIDR or a single IP address.
+func ParseNetwork(network string) (netip.Prefix, error) {
+	if strings.Contains(network, "/") {
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(network)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -0,0 +1,31 @@
+package netutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNetwork(t *testing.T) {
+	tests := []struct {
+		network  string
+		expected string
+	}{
+		{"10.1.2.3/8", "10.0.0.0/8"},
+		{"10.1.2.3", "10.1.2.3/32"},
+		{"::ffff:10.1.2.3", "10.1.2.3/32"},
+		{"2001:db8::1", "2001:db8::1/128"},
+	}
+
+	for _, tt := range tests {
+		prefix, err := ParseNetwork(tt.network)
+		require.NoError(t, err)
+		assert.Equal(t, tt.expected, prefix.String())
+	}
+
+	_, err := ParseNetwork("not-a-network")
+	assert.Error(t, err)
+	_, err = ParseNetwork("10.0.0.0/33")
+	assert.Error(t, err)
+}
@@ -0,0 +1,161 @@
+package cas
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type jsonServiceResponse struct {
+	ServiceResponse jsonServiceResponseBody `json:"serviceResponse"`
+}
+
+type jsonServiceResponseBody struct {
+	Failure      *jsonAuthenticationFailure `json:"authenticationFailure,omitempty"`
+	Success      *jsonAuthenticationSuccess `json:"authenticationSuccess,omitempty"`
+	ProxyFailure *jsonAuthenticationFailure `json:"proxyFailure,omitempty"`
+	ProxySuccess *jsonProxySuccess          `json:"proxySuccess,omitempty"`
+}
+
+type jsonAuthenticationFailure struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+type jsonAuthenticationSuccess struct {
+	User                string                     `json:"user"`
+	ProxyGrantingTicket string                     `json:"proxyGrantingTicket,omitempty"`
+	Proxies             []string                   `json:"proxies,omitempty"`
+	Attributes          map[string]json.RawMessage `json:"attributes,omitempty"`
+}
+
+type jsonProxySuccess struct {
+	ProxyTicket string `json:"proxyTicket"`
+}
+
+// ParseServiceResponseJSON returns a successful response or an error from a CAS 3 JSON service response
+func ParseServiceResponseJSON(data []byte) (*AuthenticationResponse, error) {
+	var x jsonServiceResponse
+
+	if err := json.Unmarshal(data, &x); err != nil {
+		return nil, err
+	}
+
+	if f := x.ServiceResponse.Failure; f != nil {
+		err := &AuthenticationError{Code: f.Code, Message: strings.TrimSpace(f.Description)}
+		return nil, err
+	}
+
+	if x.ServiceResponse.Success == nil {
+		return nil, &AuthenticationError{Code: INTERNAL_ERROR, Message: "service response contains no authentication result"}
+	}
+
+	s := x.ServiceResponse.Success
+	r := &AuthenticationResponse{
+		User:                s.User,
+		ProxyGrantingTicket: s.ProxyGrantingTicket,
+		Proxies:             s.Proxies,
+		Attributes:          make(UserAttributes),
+	}
+
+	for name, raw := range s.Attributes {
+		values, err := jsonAttributeValues(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		switch name {
+		case "authenticationDate":
+			// An unknown date format leaves the date unset rather than failing the authentication
+			if len(values) > 0 {
+				if t, err := parseJSONDate(values[0]); err == nil {
+					r.AuthenticationDate = t
+				}
+			}
+		case "longTermAuthenticationRequestTokenUsed":
+			r.IsRememberedLogin = jsonAttributeBool(values)
+		case "isFromNewLogin":
+			r.IsNewLogin = jsonAttributeBool(values)
+		case "memberOf":
+			r.MemberOf = append(r.MemberOf, values...)
+		default:
+			for _, v := range values {
+				r.Attributes.Add(name, v)
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// isJSONServiceResponse determines whether the response body is a JSON document.
+func isJSONServiceResponse(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	return len(data) > 0 && data[0] == '{'
+}
+
+// jsonAttributeValues flattens a JSON attribute value, which may be a scalar or an array of scalars, into strings.
+func jsonAttributeValues(raw json.RawMessage) ([]string, error) {
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+
+	var decoded interface{}
+	if err := d.Decode(&decoded); err != nil {
+		return nil, err
+	}
+
+	elements, ok := decoded.([]interface{})
+	if !ok {
+		elements = []interface{}{decoded}
+	}
+
+	var values []string
+	for _, e := range elements {
+		switch v := e.(type) {
+		case nil:
+			continue
+		case string:
+			values = append(values, strings.TrimSpace(v))
+		case bool:
+			values = append(values, strconv.FormatBool(v))
+		case json.Number:
+			values = append(values, v.String())
+		default:
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, string(b))
+		}
+	}
+
+	return values, nil
+}
+
+// jsonAttributeBool reports whether the first attribute value is true.
+func jsonAttributeBool(values []string) bool {
+	if len(values) == 0 {
+		return false
+	}
+
+	b, _ := strconv.ParseBool(values[0])
+	return b
+}
+
+// parseJSONDate parses an authenticationDate, stripping the "[UTC]" style zone id some CAS servers append.
+//
+// Apereo CAS serializes the date as seconds since the epoch, e.g. 1.473452473355E9, which is accepted as well.
+func parseJSONDate(raw string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(raw, 64); err == nil {
+		return time.UnixMicro(int64(math.Round(seconds * 1e6))), nil
+	}
+
+	if i := strings.Index(raw, "["); i > 0 {
+		raw = raw[:i]
+	}
+
+	return time.Parse(time.RFC3339Nano, raw)
+}
@@ -0,0 +1,112 @@
+package cas
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseJSONFailureServiceResponse(t *testing.T) {
+	s := `{
+  "serviceResponse" : {
+    "authenticationFailure" : {
+      "code" : "INVALID_TICKET",
+      "description" : "Ticket ST-1856339-aA5Yuvrxzpv8Tau1cYQ7 not recognized"
+    }
+  }
+}`
+
+	_, err := ParseServiceResponse([]byte(s))
+	require.Error(t, err)
+
+	authErr, ok := err.(*AuthenticationError)
+	require.True(t, ok)
+	assert.Equal(t, INVALID_TICKET, authErr.Code)
+	assert.Equal(t, "Ticket ST-1856339-aA5Yuvrxzpv8Tau1cYQ7 not recognized", authErr.Message)
+}
+
+func TestParseJSONSuccessfulServiceResponse(t *testing.T) {
+	s := `{
+  "serviceResponse" : {
+    "authenticationSuccess" : {
+      "user" : "username",
+      "proxyGrantingTicket" : "PGTIOU-84678-8a9d",
+      "proxies" : [ "https://proxy1/pgtUrl", "https://proxy2/pgtUrl" ],
+      "attributes" : {
+        "firstname" : [ "John" ],
+        "affiliation" : [ "staff", "faculty" ],
+        "title" : "Dr.",
+        "employeeNumber" : [ 1234 ],
+        "active" : true,
+        "authenticationDate" : [ "2015-11-12T09:30:10.123Z[UTC]" ],
+        "longTermAuthenticationRequestTokenUsed" : [ false ],
+        "isFromNewLogin" : [ true ],
+        "memberOf" : [ "faculty", "staff" ]
+      }
+    }
+  }
+}`
+
+	sr, err := ParseServiceResponse([]byte(s))
+	require.NoError(t, err)
+
+	assert.Equal(t, "username", sr.User)
+	assert.Equal(t, "PGTIOU-84678-8a9d", sr.ProxyGrantingTicket)
+	assert.Equal(t, []string{"https://proxy1/pgtUrl", "https://proxy2/pgtUrl"}, sr.Proxies)
+	assert.Equal(t, time.Date(2015, 11, 12, 9, 30, 10, 123000000, time.UTC), sr.AuthenticationDate.UTC())
+	assert.False(t, sr.IsRememberedLogin)
+	assert.True(t, sr.IsNewLogin)
+	assert.Equal(t, []string{"faculty", "staff"}, sr.MemberOf)
+
+	assert.Equal(t, UserAttributes{
+		"firstname":      {"John"},
+		"affiliation":    {"staff", "faculty"},
+		"title":          {"Dr."},
+		"employeeNumber": {"1234"},
+		"active":         {"true"},
+	}, sr.Attributes)
+}
+
+func TestParseJSONServiceResponseWithoutResult(t *testing.T) {
+	_, err := ParseServiceResponseJSON([]byte(`{"serviceResponse": {}}`))
+	require.Error(t, err)
+}
+
+func TestParseJSONServiceResponseEpochAuthenticationDate(t *testing.T) {
+	s := `{"serviceResponse": {"authenticationSuccess": {"user": "username",
+		"attributes": {"authenticationDate": [ 1.473452473355E9 ]}}}}`
+
+	sr, err := ParseServiceResponseJSON([]byte(s))
+	require.NoError(t, err)
+
+	assert.Equal(t, time.UnixMilli(1473452473355), sr.AuthenticationDate)
+}
+
+func TestParseJSONServiceResponseIgnoresUnknownAuthenticationDate(t *testing.T) {
+	s := `{"serviceResponse": {"authenticationSuccess": {"user": "username",
+		"attributes": {"authenticationDate": [ "12 Nov 2015" ]}}}}`
+
+	sr, err := ParseServiceResponseJSON([]byte(s))
+	require.NoError(t, err)
+
+	assert.Equal(t, "username", sr.User)
+	assert.True(t, sr.AuthenticationDate.IsZero())
+}
+
+func TestServiceValidateUrlRequestsJSON(t *testing.T) {
+	casURL, _ := url.Parse("https://cas.example.com/cas")
+	service, _ := url.Parse("http://example.com/")
+
+	validator := NewServiceTicketValidator(ServiceTicketValidatorOptions{
+		CasURL:          casURL,
+		ProtocolVersion: ProtocolCAS3,
+		ResponseFormat:  ResponseFormatJSON,
+	})
+
+	u, err := validator.ServiceValidateUrl(service, "ST-1", newDisabledProxy())
+	require.NoError(t, err)
+	assert.Equal(t, "https://cas.example.com/cas/p3/serviceValidate?format=JSON&service=http%3A%2F%2Fexample.com%2F&ticket=ST-1", u)
+}
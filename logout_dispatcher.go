@@ -0,0 +1,221 @@
+package cas
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default LogoutDispatcher settings
+const (
+	defaultLogoutTimeout     = 5 * time.Second
+	defaultLogoutRetries     = 2
+	defaultLogoutRetryDelay  = 500 * time.Millisecond
+	defaultLogoutConcurrency = 10
+)
+
+// LogoutDispatcherOptions : LogoutDispatcher configuration options
+type LogoutDispatcherOptions struct {
+	Client      *http.Client  // Custom http client used to send logout requests
+	Timeout     time.Duration // Timeout of each attempt, defaults to 5 seconds
+	Retries     int           // Additional attempts after a failure, defaults to 2, negative disables retries
+	RetryDelay  time.Duration // Delay before the first retry, doubled for each further retry, defaults to 500ms
+	Concurrency int           // Maximum number of services notified at once, defaults to 10
+	Logger      *slog.Logger  // Optional logger
+}
+
+// LogoutTarget is a service which must be notified that a session ended.
+type LogoutTarget struct {
+	URL    string // Endpoint receiving the logout request, usually the service url
+	Ticket string // Service ticket which created the session
+}
+
+// LogoutResult reports the delivery of a logout request to a service.
+type LogoutResult struct {
+	Target     LogoutTarget
+	Attempts   int   // Number of requests sent
+	StatusCode int   // Status code of the last response, 0 if no response was received
+	Err        error // Error of the last attempt, nil if the request was delivered
+}
+
+// LogoutDispatcher sends back-channel single logout requests to services.
+//
+// Each service receives a POST with a logoutRequest form field holding a SAML LogoutRequest for its service ticket.
+type LogoutDispatcher struct {
+	client      *http.Client
+	timeout     time.Duration
+	retries     int
+	retryDelay  time.Duration
+	concurrency int
+	logger      *slog.Logger
+}
+
+// NewLogoutDispatcher creates a LogoutDispatcher with the provided options.
+func NewLogoutDispatcher(options *LogoutDispatcherOptions) *LogoutDispatcher {
+	// If logger isn't set then fallback to the default logger
+	if options.Logger == nil {
+		options.Logger = slog.Default()
+	}
+
+	var client *http.Client
+	if options.Client != nil {
+		client = options.Client
+	} else {
+		client = &http.Client{}
+	}
+
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = defaultLogoutTimeout
+	}
+
+	retries := options.Retries
+	if retries == 0 {
+		retries = defaultLogoutRetries
+	} else if retries < 0 {
+		retries = 0
+	}
+
+	retryDelay := options.RetryDelay
+	if retryDelay <= 0 {
+		retryDelay = defaultLogoutRetryDelay
+	}
+
+	concurrency := options.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultLogoutConcurrency
+	}
+
+	return &LogoutDispatcher{
+		client:      client,
+		timeout:     timeout,
+		retries:     retries,
+		retryDelay:  retryDelay,
+		concurrency: concurrency,
+		logger:      options.Logger,
+	}
+}
+
+// Dispatch notifies all targets concurrently and returns a result per target, in the order of the targets.
+func (d *LogoutDispatcher) Dispatch(targets []LogoutTarget) []LogoutResult {
+	return d.DispatchContext(context.Background(), targets)
+}
+
+// DispatchContext is like Dispatch, but stops retrying and sending logout requests when the context is done.
+func (d *LogoutDispatcher) DispatchContext(ctx context.Context, targets []LogoutTarget) []LogoutResult {
+	results := make([]LogoutResult, len(targets))
+	sem := make(chan struct{}, d.concurrency)
+
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int, target LogoutTarget) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			results[i] = d.deliver(ctx, target)
+		}(i, target)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// DispatchAsync notifies all targets in the background and returns immediately.
+//
+// Failed deliveries are logged, done is called with the results when it is not nil.
+func (d *LogoutDispatcher) DispatchAsync(targets []LogoutTarget, done func([]LogoutResult)) {
+	go func() {
+		results := d.Dispatch(targets)
+		if done != nil {
+			done(results)
+		}
+	}()
+}
+
+// deliver sends the logout request to a target, retrying failed attempts.
+func (d *LogoutDispatcher) deliver(ctx context.Context, target LogoutTarget) LogoutResult {
+	result := LogoutResult{Target: target}
+
+	body, err := xmlLogoutRequest(target.Ticket)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	form := url.Values{"logoutRequest": {string(body)}}.Encode()
+
+	delay := d.retryDelay
+	for {
+		result.Attempts++
+
+		var retry bool
+		result.StatusCode, retry, result.Err = d.send(ctx, target.URL, form)
+		if result.Err == nil || !retry || result.Attempts > d.retries {
+			break
+		}
+
+		d.logger.Debug("retrying logout request",
+			slog.String("url", target.URL),
+			slog.Int("attempt", result.Attempts),
+			slog.String("error", result.Err.Error()))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			result.Err = ctx.Err()
+		case <-timer.C:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		delay *= 2
+	}
+
+	if result.Err != nil {
+		d.logger.Warn("logout request failed",
+			slog.String("url", target.URL),
+			slog.Int("attempts", result.Attempts),
+			slog.String("error", result.Err.Error()))
+	}
+
+	return result
+}
+
+// send makes a single attempt, reporting whether a failure may succeed when retried.
+func (d *LogoutDispatcher) send(ctx context.Context, endpoint, form string) (int, bool, error) {
+	attemptCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(attemptCtx, "POST", endpoint, strings.NewReader(form))
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// Attempts abandoned by the caller are not retried, only those which timed out
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, ctx.Err() == nil, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return resp.StatusCode, false, nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return resp.StatusCode, true, fmt.Errorf("cas: logout request returned status code %v", resp.StatusCode)
+	default:
+		return resp.StatusCode, false, fmt.Errorf("cas: logout request returned status code %v", resp.StatusCode)
+	}
+}
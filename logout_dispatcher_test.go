@@ -0,0 +1,138 @@
+package cas
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDispatcher() *LogoutDispatcher {
+	return NewLogoutDispatcher(&LogoutDispatcherOptions{
+		Timeout:    100 * time.Millisecond,
+		RetryDelay: time.Millisecond,
+	})
+}
+
+func TestLogoutDispatcherDispatch(t *testing.T) {
+	tickets := make(chan string, 1)
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l, err := parseLogoutRequest([]byte(r.PostFormValue("logoutRequest")))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		tickets <- l.SessionIndex
+	}))
+	defer ok.Close()
+
+	var calls int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer flaky.Close()
+
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+
+	results := newTestDispatcher().Dispatch([]LogoutTarget{
+		{URL: ok.URL, Ticket: "ST-1"},
+		{URL: flaky.URL, Ticket: "ST-2"},
+		{URL: missing.URL, Ticket: "ST-3"},
+	})
+	require.Len(t, results, 3)
+
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, 1, results[0].Attempts)
+	assert.Equal(t, "ST-1", <-tickets)
+
+	assert.NoError(t, results[1].Err)
+	assert.Equal(t, 2, results[1].Attempts)
+	assert.Equal(t, http.StatusOK, results[1].StatusCode)
+
+	// Client errors are not retried
+	assert.Error(t, results[2].Err)
+	assert.Equal(t, 1, results[2].Attempts)
+	assert.Equal(t, http.StatusNotFound, results[2].StatusCode)
+	assert.Equal(t, "ST-3", results[2].Target.Ticket)
+}
+
+func TestLogoutDispatcherTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	d := NewLogoutDispatcher(&LogoutDispatcherOptions{
+		Timeout: 10 * time.Millisecond,
+		Retries: -1,
+	})
+
+	results := d.Dispatch([]LogoutTarget{{URL: slow.URL, Ticket: "ST-1"}})
+	assert.Error(t, results[0].Err)
+	assert.Equal(t, 1, results[0].Attempts)
+	assert.Equal(t, 0, results[0].StatusCode)
+}
+
+func TestLogoutDispatcherRetriesExhausted(t *testing.T) {
+	var calls int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	results := newTestDispatcher().Dispatch([]LogoutTarget{{URL: failing.URL, Ticket: "ST-1"}})
+	assert.Error(t, results[0].Err)
+	assert.Equal(t, 1+defaultLogoutRetries, results[0].Attempts)
+	assert.Equal(t, int32(1+defaultLogoutRetries), atomic.LoadInt32(&calls))
+}
+
+func TestLogoutDispatcherDispatchContextCanceled(t *testing.T) {
+	var calls int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	d := NewLogoutDispatcher(&LogoutDispatcherOptions{RetryDelay: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	results := d.DispatchContext(ctx, []LogoutTarget{{URL: failing.URL, Ticket: "ST-1"}})
+	assert.Less(t, time.Since(start), time.Second)
+	assert.ErrorIs(t, results[0].Err, context.DeadlineExceeded)
+	assert.Equal(t, 1, results[0].Attempts)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestLogoutDispatcherDispatchAsync(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	done := make(chan []LogoutResult)
+	newTestDispatcher().DispatchAsync([]LogoutTarget{{URL: server.URL, Ticket: "ST-1"}}, func(results []LogoutResult) {
+		done <- results
+	})
+
+	select {
+	case results := <-done:
+		require.Len(t, results, 1)
+		assert.NoError(t, results[0].Err)
+	case <-time.After(time.Second):
+		t.Fatal("DispatchAsync did not complete")
+	}
+}
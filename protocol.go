@@ -0,0 +1,57 @@
+package cas
+
+// ProtocolVersion selects the CAS protocol used for service ticket validation.
+type ProtocolVersion int
+
+const (
+	// ProtocolAuto probes the CAS server for the newest supported protocol and
+	// remembers the result for subsequent validations.
+	ProtocolAuto ProtocolVersion = iota
+	// ProtocolCAS1 uses the CAS 1.0 /validate endpoint.
+	ProtocolCAS1
+	// ProtocolCAS2 uses the CAS 2.0 /serviceValidate endpoint.
+	ProtocolCAS2
+	// ProtocolCAS3 uses the CAS 3.0 /p3/serviceValidate endpoint.
+	ProtocolCAS3
+	// ProtocolSAML11 uses the SAML 1.1 /samlValidate endpoint. It is never selected by ProtocolAuto.
+	// Renewed logins are validated by adding renew=true to the samlValidate url, as for the other endpoints.
+	ProtocolSAML11
+)
+
+func (v ProtocolVersion) String() string {
+	switch v {
+	case ProtocolAuto:
+		return "auto"
+	case ProtocolCAS1:
+		return "CAS1"
+	case ProtocolCAS2:
+		return "CAS2"
+	case ProtocolCAS3:
+		return "CAS3"
+	case ProtocolSAML11:
+		return "SAML11"
+	default:
+		return ""
+	}
+}
+
+// ResponseFormat selects the format of CAS service validation responses.
+type ResponseFormat int
+
+const (
+	// ResponseFormatXML requests XML service responses, the CAS default.
+	ResponseFormatXML ResponseFormat = iota
+	// ResponseFormatJSON requests CAS 3 JSON service responses by sending format=JSON.
+	ResponseFormatJSON
+)
+
+func (f ResponseFormat) String() string {
+	switch f {
+	case ResponseFormatXML:
+		return "XML"
+	case ResponseFormatJSON:
+		return "JSON"
+	default:
+		return ""
+	}
+}
@@ -1,21 +1,76 @@
 package proxy
 
 import (
+	"context"
 	"errors"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/mattmohan-flipp/cas/v2/internal/netutil"
 	"github.com/mattmohan-flipp/cas/v2/proxy/store"
 	"github.com/mattmohan-flipp/cas/v2/urlscheme"
 )
 
+// Default time allowed for the CAS server's callback to deliver a PGT, and interval between store lookups
+const (
+	defaultWaitTimeout  = 5 * time.Second
+	defaultPollInterval = 50 * time.Millisecond
+)
+
+// Maximum length of proxy granting tickets and IOUs, CAS clients must accept tickets of up to 256 characters
+const maxTicketLength = 256
+
+// CallbackRejection is the reason a proxy callback request was refused.
+type CallbackRejection int
+
+const (
+	// RejectedInsecure callbacks were not received over TLS while RequireTLS is set
+	RejectedInsecure CallbackRejection = iota
+	// RejectedCaller callbacks came from a network or client certificate which is not allowed
+	RejectedCaller
+	// RejectedFormat callbacks carried an IOU or PGT which is not a valid ticket
+	RejectedFormat
+	// RejectedDuplicate callbacks attempted to overwrite the PGT of an already stored IOU
+	RejectedDuplicate
+
+	callbackRejections
+)
+
+func (cr CallbackRejection) String() string {
+	switch cr {
+	case RejectedInsecure:
+		return "insecure"
+	case RejectedCaller:
+		return "caller"
+	case RejectedFormat:
+		return "format"
+	case RejectedDuplicate:
+		return "duplicate"
+	default:
+		return ""
+	}
+}
+
 type Proxy struct {
 	requestProxy     bool
 	proxyCallbackURL *url.URL
 	proxyStore       store.ProxyStore
 	logger           *slog.Logger
 	urlScheme        urlscheme.URLScheme
+	waitTimeout      time.Duration
+	pollInterval     time.Duration
+
+	requireTLS          bool
+	allowedNetworks     []netip.Prefix
+	allowedCertificates []string
+	strictTicketFormat  bool
+	rejections          *[callbackRejections]atomic.Uint64 // shared by copies of the Proxy
 }
 
 type ProxyOptions struct {
@@ -24,6 +79,14 @@ type ProxyOptions struct {
 	ProxyStore       store.ProxyStore
 	Logger           *slog.Logger
 	UrlScheme        urlscheme.URLScheme
+
+	WaitTimeout  time.Duration // How long validation waits for the PGT of an IOU to arrive, defaults to 5 seconds
+	PollInterval time.Duration // Interval between lookups of stores which do not implement store.Waiter, defaults to 50ms
+
+	RequireTLS          bool     // Refuse callbacks which were not received over TLS
+	AllowedNetworks     []string // IPs and CIDRs the CAS server calls back from, callers are not restricted when empty
+	AllowedCertificates []string // Common or DNS names of verified client certificates allowed to call back
+	StrictTicketFormat  bool     // Refuse IOUs and PGTs without the PGTIOU- and PGT- prefixes or longer than 256 characters
 }
 
 func NewProxy(urlScheme urlscheme.URLScheme, options *ProxyOptions) *Proxy {
@@ -42,32 +105,173 @@ func NewProxy(urlScheme urlscheme.URLScheme, options *ProxyOptions) *Proxy {
 		proxyStore = store.NewMemoryProxyStore()
 	}
 
+	waitTimeout := options.WaitTimeout
+	if waitTimeout <= 0 {
+		waitTimeout = defaultWaitTimeout
+	}
+
+	pollInterval := options.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+
+	allowedNetworks := make([]netip.Prefix, 0, len(options.AllowedNetworks))
+	for _, network := range options.AllowedNetworks {
+		prefix, err := netutil.ParseNetwork(network)
+		if err != nil {
+			logger.Error("Failed to parse proxy callback network", slog.String("network", network), slog.Any("error", err))
+			return nil
+		}
+		allowedNetworks = append(allowedNetworks, prefix)
+	}
+
 	return &Proxy{
 		requestProxy:     options.RequestProxy,
 		proxyCallbackURL: url,
 		proxyStore:       proxyStore,
 		logger:           logger,
 		urlScheme:        urlScheme,
+		waitTimeout:      waitTimeout,
+		pollInterval:     pollInterval,
+
+		requireTLS:          options.RequireTLS,
+		allowedNetworks:     allowedNetworks,
+		allowedCertificates: options.AllowedCertificates,
+		strictTicketFormat:  options.StrictTicketFormat,
+		rejections:          new([callbackRejections]atomic.Uint64),
 	}
 }
 
 /**
  * Handle the proxy callback request
  * NOTE: This should return a 200 status code to the CAS server even if the expected params are missing 🙄
+ *
+ * Callbacks from callers which are not allowed, with malformed tickets or for an IOU which is already
+ * stored with another PGT are refused, logged and counted in Rejections. A repeated callback delivering the
+ * same PGT, e.g. a retry of the CAS server, is accepted.
  */
 func (p *Proxy) Handle(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+
+	if p.requireTLS && r.TLS == nil {
+		p.reject(w, r, RejectedInsecure, http.StatusForbidden)
+		return
+	}
+
+	if !p.isAllowedCaller(r) {
+		p.reject(w, r, RejectedCaller, http.StatusForbidden)
+		return
+	}
+
 	pgtIou := r.URL.Query().Get("pgtIou")
 	pgtId := r.URL.Query().Get("pgtId")
 	if pgtId != "" && pgtIou != "" {
-		p.proxyStore.Set(r.URL.Query().Get("pgtIou"), r.URL.Query().Get("pgtId"))
+		if p.strictTicketFormat && (!isValidTicket(pgtIou, "PGTIOU-") || !isValidTicket(pgtId, "PGT-")) {
+			p.reject(w, r, RejectedFormat, http.StatusBadRequest)
+			return
+		}
+
+		added, err := p.addProxyTgt(pgtIou, pgtId)
+		if err != nil {
+			p.logger.Error("Failed to store proxy granting ticket", slog.Any("error", err))
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if !added {
+			p.reject(w, r, RejectedDuplicate, http.StatusConflict)
+			return
+		}
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
+// addProxyTgt stores the PGT unless the IOU is already stored, atomically when the store is an Adder.
+//
+// Returns false only if the IOU is stored with another PGT.
+func (p *Proxy) addProxyTgt(pgtIou, pgtId string) (bool, error) {
+	if adder, ok := p.proxyStore.(store.Adder); ok {
+		added, err := adder.Add(pgtIou, pgtId)
+		if err != nil || added {
+			return added, err
+		}
+
+		stored, ok := p.proxyStore.Get(pgtIou)
+		return ok && stored == pgtId, nil
+	}
+
+	if stored, ok := p.proxyStore.Get(pgtIou); ok {
+		return stored == pgtId, nil
+	}
+
+	return true, p.proxyStore.Set(pgtIou, pgtId)
+}
+
+// Rejections returns the number of refused proxy callbacks by reason.
+func (p Proxy) Rejections() map[CallbackRejection]uint64 {
+	counts := make(map[CallbackRejection]uint64, callbackRejections)
+	for reason := range callbackRejections {
+		counts[reason] = p.rejections[reason].Load()
+	}
+	return counts
+}
+
+func (p *Proxy) reject(w http.ResponseWriter, r *http.Request, reason CallbackRejection, code int) {
+	p.rejections[reason].Add(1)
+	p.logger.Warn("Rejected proxy callback",
+		slog.String("reason", reason.String()),
+		slog.String("remoteAddr", r.RemoteAddr),
+		slog.String("pgtIou", r.URL.Query().Get("pgtIou")))
+
+	http.Error(w, http.StatusText(code), code)
+}
+
+// isAllowedCaller determines whether the callback comes from an allowed network or presents an allowed
+// client certificate. Callers are not restricted when neither is configured.
+func (p *Proxy) isAllowedCaller(r *http.Request) bool {
+	if len(p.allowedNetworks) == 0 && len(p.allowedCertificates) == 0 {
+		return true
+	}
+
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if addr, err := netip.ParseAddr(host); err == nil {
+			addr = addr.Unmap()
+			for _, network := range p.allowedNetworks {
+				if network.Contains(addr) {
+					return true
+				}
+			}
+		}
+	}
+
+	// Only certificates verified by the TLS server are trusted
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return false
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	for _, name := range p.allowedCertificates {
+		if cert.Subject.CommonName == name {
+			return true
+		}
+		for _, dnsName := range cert.DNSNames {
+			if strings.EqualFold(dnsName, name) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isValidTicket determines whether the ticket has the prefix, a value following it and an acceptable length.
+func isValidTicket(ticket, prefix string) bool {
+	return strings.HasPrefix(ticket, prefix) && len(ticket) > len(prefix) && len(ticket) <= maxTicketLength
+}
+
 func (p Proxy) IsEnabled() bool {
 	return p.requestProxy
 }
@@ -83,6 +287,11 @@ func (p Proxy) GetProxyURL(targetService, pgtIou string) (string, error) {
 	if !ok {
 		return "", errProxyIouNotFound
 	}
+	return p.GetProxyURLForPgt(targetService, pgt)
+}
+
+// GetProxyURLForPgt returns the url requesting a proxy ticket for the target service with an already resolved PGT.
+func (p Proxy) GetProxyURLForPgt(targetService, pgt string) (string, error) {
 	casUrl, err := p.urlScheme.Proxy()
 	if err != nil {
 		return "", err
@@ -97,3 +306,73 @@ func (p Proxy) GetProxyURL(targetService, pgtIou string) (string, error) {
 func (p Proxy) GetProxyTgt(pgtIou string) (string, bool) {
 	return p.proxyStore.Get(pgtIou)
 }
+
+// WaitProxyTgt returns the PGT for an IOU, waiting up to the configured WaitTimeout for the CAS server's callback
+// to deliver it.
+//
+// In a cluster the callback may be handled by another instance sharing the store, so the PGT can arrive after
+// the validation response. The returned PGT is owned by the validated session from then on, so the IOU is
+// consumed in stores implementing store.Consumer.
+func (p Proxy) WaitProxyTgt(pgtIou string) (string, bool) {
+	return p.WaitProxyTgtContext(context.Background(), pgtIou)
+}
+
+// WaitProxyTgtContext is like WaitProxyTgt, but stops waiting when the context is done.
+func (p Proxy) WaitProxyTgtContext(ctx context.Context, pgtIou string) (string, bool) {
+	ctx, cancel := context.WithTimeout(ctx, p.waitTimeout)
+	defer cancel()
+
+	pgt, ok := p.wait(ctx, pgtIou)
+	if ok {
+		p.consume(pgtIou)
+	}
+	return pgt, ok
+}
+
+// wait looks up the IOU until it is found or the context is done.
+//
+// Stores implementing store.Waiter are waited on in slices of the poll interval, so that cancellation is noticed.
+func (p Proxy) wait(ctx context.Context, pgtIou string) (string, bool) {
+	deadline, _ := ctx.Deadline()
+	w, isWaiter := p.proxyStore.(store.Waiter)
+
+	for {
+		interval := min(p.pollInterval, time.Until(deadline))
+		if isWaiter {
+			if pgt, ok := w.Wait(pgtIou, interval); ok {
+				return pgt, true
+			}
+		} else {
+			if pgt, ok := p.proxyStore.Get(pgtIou); ok {
+				return pgt, true
+			}
+
+			timer := time.NewTimer(interval)
+			select {
+			case <-ctx.Done():
+			case <-timer.C:
+			}
+			timer.Stop()
+		}
+
+		if ctx.Err() != nil {
+			return "", false
+		}
+	}
+}
+
+// DeleteProxyTgt removes the IOU of a session which ended from the store.
+func (p Proxy) DeleteProxyTgt(pgtIou string) error {
+	return p.proxyStore.Delete(pgtIou)
+}
+
+func (p Proxy) consume(pgtIou string) {
+	c, ok := p.proxyStore.(store.Consumer)
+	if !ok {
+		return
+	}
+
+	if err := c.Consume(pgtIou); err != nil {
+		p.logger.Warn("Failed to consume proxy granting ticket IOU", slog.Any("error", err))
+	}
+}
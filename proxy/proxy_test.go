@@ -1,10 +1,18 @@
 package proxy
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/mattmohan-flipp/cas/v2/proxy/store"
 	"github.com/mattmohan-flipp/cas/v2/urlscheme"
@@ -147,3 +155,301 @@ func TestGetProxyTgt(t *testing.T) {
 	assert.True(t, ok)
 	assert.Equal(t, "testPgt", pgt)
 }
+
+// pollingStore hides the Waiter implementation of the MemoryProxyStore.
+type pollingStore struct {
+	mu    sync.Mutex
+	store map[string]string
+}
+
+func (s *pollingStore) Get(iou string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pgt, ok := s.store[iou]
+	return pgt, ok
+}
+
+func (s *pollingStore) Set(iou, pgt string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store[iou] = pgt
+	return nil
+}
+
+func (s *pollingStore) Delete(iou string) error { return nil }
+func (s *pollingStore) Clear() error            { return nil }
+
+func TestWaitProxyTgt(t *testing.T) {
+	urlScheme := urlscheme.NewDefaultURLScheme(defaultURL)
+
+	stores := map[string]store.ProxyStore{
+		"waiter":  store.NewMemoryProxyStore(),
+		"polling": &pollingStore{store: make(map[string]string)},
+	}
+
+	for name, proxyStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			proxy := NewProxy(urlScheme, &ProxyOptions{
+				RequestProxy:     true,
+				ProxyCallbackURL: "http://example.com/callback",
+				ProxyStore:       proxyStore,
+				WaitTimeout:      time.Second,
+				PollInterval:     time.Millisecond,
+			})
+
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				proxyStore.Set("testIou", "testPgt")
+			}()
+
+			pgt, ok := proxy.WaitProxyTgt("testIou")
+			assert.True(t, ok)
+			assert.Equal(t, "testPgt", pgt)
+		})
+	}
+}
+
+func TestWaitProxyTgtTimeout(t *testing.T) {
+	urlScheme := urlscheme.NewDefaultURLScheme(defaultURL)
+	proxy := NewProxy(urlScheme, &ProxyOptions{
+		RequestProxy:     true,
+		ProxyCallbackURL: "http://example.com/callback",
+		ProxyStore:       &pollingStore{store: make(map[string]string)},
+		WaitTimeout:      20 * time.Millisecond,
+	})
+
+	start := time.Now()
+	pgt, ok := proxy.WaitProxyTgt("testIou")
+	assert.False(t, ok)
+	assert.Equal(t, "", pgt)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+}
+
+func TestGetProxyURLForPgt(t *testing.T) {
+	urlScheme := urlscheme.NewDefaultURLScheme(defaultURL)
+	proxy := NewProxy(urlScheme, &ProxyOptions{RequestProxy: true})
+
+	proxyURL, err := proxy.GetProxyURLForPgt("http://example.com/service", "testPgt")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://example.com/proxy?pgt=testPgt&targetService=http%3A%2F%2Fexample.com%2Fservice", proxyURL)
+}
+
+func TestWaitProxyTgtConsumesIou(t *testing.T) {
+	urlScheme := urlscheme.NewDefaultURLScheme(defaultURL)
+	proxyStore := store.NewMemoryProxyStoreWithOptions(&store.MemoryProxyStoreOptions{
+		ConsumedTTL: 10 * time.Millisecond,
+	})
+	defer proxyStore.Close()
+
+	proxy := NewProxy(urlScheme, &ProxyOptions{
+		RequestProxy:     true,
+		ProxyCallbackURL: "http://example.com/callback",
+		ProxyStore:       proxyStore,
+	})
+
+	proxyStore.Set("testIou", "testPgt")
+	pgt, ok := proxy.WaitProxyTgt("testIou")
+	assert.True(t, ok)
+	assert.Equal(t, "testPgt", pgt)
+
+	assert.Eventually(t, func() bool {
+		_, ok := proxy.GetProxyTgt("testIou")
+		return !ok
+	}, time.Second, 5*time.Millisecond)
+
+	proxyStore.Set("otherIou", "otherPgt")
+	assert.NoError(t, proxy.DeleteProxyTgt("otherIou"))
+	_, ok = proxy.GetProxyTgt("otherIou")
+	assert.False(t, ok)
+}
+
+func TestHandleRejections(t *testing.T) {
+	urlScheme := urlscheme.NewDefaultURLScheme(defaultURL)
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+		{Subject: pkix.Name{CommonName: "cas.example.com"}, DNSNames: []string{"cas-1.example.com"}},
+	}}}
+	pgtIou := "PGTIOU-" + strings.Repeat("a", 32)
+
+	tests := []struct {
+		name       string
+		options    ProxyOptions
+		remoteAddr string
+		tls        *tls.ConnectionState
+		query      string
+		code       int
+		reason     CallbackRejection
+	}{
+		{"tls required", ProxyOptions{RequireTLS: true}, "10.0.0.1:1234", nil, "", http.StatusForbidden, RejectedInsecure},
+		{"network not allowed", ProxyOptions{AllowedNetworks: []string{"10.0.0.0/8"}}, "192.168.0.1:1234", nil, "", http.StatusForbidden, RejectedCaller},
+		{"ip not allowed", ProxyOptions{AllowedNetworks: []string{"10.0.0.1"}}, "10.0.0.2:1234", nil, "", http.StatusForbidden, RejectedCaller},
+		{"certificate not allowed", ProxyOptions{AllowedCertificates: []string{"other.example.com"}}, "10.0.0.1:1234", verified, "", http.StatusForbidden, RejectedCaller},
+		{"unverified certificate", ProxyOptions{AllowedCertificates: []string{"cas.example.com"}}, "10.0.0.1:1234", &tls.ConnectionState{}, "", http.StatusForbidden, RejectedCaller},
+		{"bad iou prefix", ProxyOptions{StrictTicketFormat: true}, "10.0.0.1:1234", nil, "pgtIou=IOU-1&pgtId=PGT-1", http.StatusBadRequest, RejectedFormat},
+		{"bad pgt prefix", ProxyOptions{StrictTicketFormat: true}, "10.0.0.1:1234", nil, "pgtIou=PGTIOU-1&pgtId=TGT-1", http.StatusBadRequest, RejectedFormat},
+		{"bare prefix", ProxyOptions{StrictTicketFormat: true}, "10.0.0.1:1234", nil, "pgtIou=PGTIOU-&pgtId=PGT-1", http.StatusBadRequest, RejectedFormat},
+		{"too long", ProxyOptions{StrictTicketFormat: true}, "10.0.0.1:1234", nil, "pgtIou=PGTIOU-1&pgtId=PGT-" + strings.Repeat("a", 253), http.StatusBadRequest, RejectedFormat},
+		{"duplicate", ProxyOptions{}, "10.0.0.1:1234", nil, "pgtIou=" + pgtIou + "&pgtId=PGT-2", http.StatusConflict, RejectedDuplicate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := tt.options
+			options.RequestProxy = true
+			options.ProxyCallbackURL = "https://example.com/callback"
+			proxy := NewProxy(urlScheme, &options)
+			proxy.proxyStore.Set(pgtIou, "PGT-1")
+
+			req := httptest.NewRequest(http.MethodGet, "/callback?"+tt.query, nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.TLS = tt.tls
+
+			rr := httptest.NewRecorder()
+			proxy.Handle(rr, req)
+
+			assert.Equal(t, tt.code, rr.Code)
+			assert.Equal(t, uint64(1), proxy.Rejections()[tt.reason])
+
+			pgt, _ := proxy.GetProxyTgt(pgtIou)
+			assert.Equal(t, "PGT-1", pgt)
+		})
+	}
+}
+
+func TestHandleAllowedCallers(t *testing.T) {
+	urlScheme := urlscheme.NewDefaultURLScheme(defaultURL)
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+		{Subject: pkix.Name{CommonName: "cas.example.com"}, DNSNames: []string{"cas-1.example.com"}},
+	}}}
+
+	tests := []struct {
+		name       string
+		options    ProxyOptions
+		remoteAddr string
+		tls        *tls.ConnectionState
+	}{
+		{"network", ProxyOptions{AllowedNetworks: []string{"10.0.0.0/8"}}, "10.1.2.3:1234", nil},
+		{"ip", ProxyOptions{AllowedNetworks: []string{"192.168.0.1", "::1"}}, "[::1]:1234", nil},
+		{"common name", ProxyOptions{RequireTLS: true, AllowedCertificates: []string{"cas.example.com"}}, "192.168.0.1:1234", verified},
+		{"dns name", ProxyOptions{AllowedNetworks: []string{"10.0.0.0/8"}, AllowedCertificates: []string{"CAS-1.example.com"}}, "192.168.0.1:1234", verified},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := tt.options
+			options.RequestProxy = true
+			options.ProxyCallbackURL = "https://example.com/callback"
+			options.StrictTicketFormat = true
+			proxy := NewProxy(urlScheme, &options)
+
+			req := httptest.NewRequest(http.MethodGet, "/callback?pgtIou=PGTIOU-1&pgtId=PGT-1", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.TLS = tt.tls
+
+			rr := httptest.NewRecorder()
+			proxy.Handle(rr, req)
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			pgt, ok := proxy.GetProxyTgt("PGTIOU-1")
+			assert.True(t, ok)
+			assert.Equal(t, "PGT-1", pgt)
+		})
+	}
+}
+
+func TestNewProxyInvalidNetwork(t *testing.T) {
+	urlScheme := urlscheme.NewDefaultURLScheme(defaultURL)
+	proxy := NewProxy(urlScheme, &ProxyOptions{AllowedNetworks: []string{"10.0.0.0/33"}})
+	assert.Nil(t, proxy)
+}
+
+func TestWaitProxyTgtContextCanceled(t *testing.T) {
+	urlScheme := urlscheme.NewDefaultURLScheme(defaultURL)
+
+	stores := map[string]store.ProxyStore{
+		"waiter":  store.NewMemoryProxyStore(),
+		"polling": &pollingStore{store: make(map[string]string)},
+	}
+
+	for name, proxyStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			proxy := NewProxy(urlScheme, &ProxyOptions{
+				RequestProxy:     true,
+				ProxyCallbackURL: "http://example.com/callback",
+				ProxyStore:       proxyStore,
+				WaitTimeout:      time.Minute,
+				PollInterval:     5 * time.Millisecond,
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(20*time.Millisecond, cancel)
+
+			start := time.Now()
+			pgt, ok := proxy.WaitProxyTgtContext(ctx, "testIou")
+			assert.False(t, ok)
+			assert.Equal(t, "", pgt)
+			assert.Less(t, time.Since(start), time.Second)
+		})
+	}
+}
+
+func TestHandleRepeatedCallback(t *testing.T) {
+	proxy := NewProxy(urlscheme.NewDefaultURLScheme(defaultURL), &ProxyOptions{
+		RequestProxy:     true,
+		ProxyCallbackURL: "https://example.com/callback",
+	})
+
+	// A CAS server retrying the callback delivers the same PGT again
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		proxy.Handle(rr, httptest.NewRequest(http.MethodGet, "/callback?pgtIou=PGTIOU-1&pgtId=PGT-1", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	rr := httptest.NewRecorder()
+	proxy.Handle(rr, httptest.NewRequest(http.MethodGet, "/callback?pgtIou=PGTIOU-1&pgtId=PGT-2", nil))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	assert.Equal(t, uint64(1), proxy.Rejections()[RejectedDuplicate])
+	pgt, _ := proxy.GetProxyTgt("PGTIOU-1")
+	assert.Equal(t, "PGT-1", pgt)
+}
+
+func TestHandleConcurrentDuplicates(t *testing.T) {
+	proxy := NewProxy(urlscheme.NewDefaultURLScheme(defaultURL), &ProxyOptions{
+		RequestProxy:     true,
+		ProxyCallbackURL: "https://example.com/callback",
+	})
+
+	const callbacks = 50
+	codes := make(chan int, callbacks)
+	start := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < callbacks; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/callback?pgtIou=PGTIOU-1&pgtId=PGT-%d", i), nil)
+			rr := httptest.NewRecorder()
+			proxy.Handle(rr, req)
+			codes <- rr.Code
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	close(codes)
+
+	accepted := 0
+	for code := range codes {
+		if code == http.StatusOK {
+			accepted++
+		} else {
+			assert.Equal(t, http.StatusConflict, code)
+		}
+	}
+
+	assert.Equal(t, 1, accepted)
+	assert.Equal(t, uint64(callbacks-1), proxy.Rejections()[RejectedDuplicate])
+}
@@ -1,23 +1,82 @@
 package store
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
+
+// Default lifetimes of stored IOUs, and minimum interval between sweeps of expired entries when storing IOUs
+const (
+	defaultTTL         = 5 * time.Minute
+	defaultConsumedTTL = 30 * time.Second
+	lazySweepInterval  = time.Minute
+)
+
+// MemoryProxyStoreOptions : MemoryProxyStore configuration options
+type MemoryProxyStoreOptions struct {
+	TTL           time.Duration // How long an IOU which was never consumed is kept, defaults to 5 minutes
+	ConsumedTTL   time.Duration // How long an IOU is kept after being consumed, defaults to 30 seconds
+	SweepInterval time.Duration // Interval of a background sweeper of expired IOUs, none runs if not positive
+}
 
 type MemoryProxyStore struct {
-	mutex sync.RWMutex
-	store map[string]string
+	mutex       sync.RWMutex
+	store       map[string]memoryProxyEntry
+	changed     chan struct{} // closed and replaced whenever an IOU is stored
+	ttl         time.Duration
+	consumedTTL time.Duration
+	lastSweep   time.Time
+	done        chan struct{}
+	closeOnce   sync.Once
 }
 
+type memoryProxyEntry struct {
+	pgt       string
+	expiresAt time.Time
+}
+
+// NewMemoryProxyStore creates a MemoryProxyStore with the default expiry settings.
+//
+// No background sweeper is started, expired IOUs are never returned and are removed while storing new IOUs.
 func NewMemoryProxyStore() *MemoryProxyStore {
-	return &MemoryProxyStore{
-		store: make(map[string]string),
+	return NewMemoryProxyStoreWithOptions(&MemoryProxyStoreOptions{})
+}
+
+// NewMemoryProxyStoreWithOptions creates a MemoryProxyStore with the given expiry settings.
+//
+// A positive SweepInterval starts a background sweeper, which must be stopped by Close.
+func NewMemoryProxyStoreWithOptions(options *MemoryProxyStoreOptions) *MemoryProxyStore {
+	ttl := options.TTL
+	if ttl <= 0 {
+		ttl = defaultTTL
 	}
+
+	consumedTTL := options.ConsumedTTL
+	if consumedTTL <= 0 {
+		consumedTTL = defaultConsumedTTL
+	}
+
+	m := &MemoryProxyStore{
+		store:       make(map[string]memoryProxyEntry),
+		changed:     make(chan struct{}),
+		ttl:         ttl,
+		consumedTTL: consumedTTL,
+		lastSweep:   time.Now(),
+		done:        make(chan struct{}),
+	}
+
+	if options.SweepInterval > 0 {
+		go m.sweeper(options.SweepInterval)
+	}
+
+	return m
 }
 
 // Clear implements ProxyStore.
 func (m *MemoryProxyStore) Clear() error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
-	m.store = make(map[string]string)
+	m.store = make(map[string]memoryProxyEntry)
 	return nil
 }
 
@@ -33,16 +92,128 @@ func (m *MemoryProxyStore) Delete(iou string) error {
 func (m *MemoryProxyStore) Get(iou string) (string, bool) {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
-	pgt, ok := m.store[iou]
-	return pgt, ok
+	return m.lookup(iou, time.Now())
 }
 
 // Set implements ProxyStore.
 func (m *MemoryProxyStore) Set(iou string, pgt string) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
-	m.store[iou] = pgt
+	m.insert(iou, pgt, time.Now())
+	return nil
+}
+
+// Add implements Adder.
+func (m *MemoryProxyStore) Add(iou string, pgt string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	if _, ok := m.lookup(iou, now); ok {
+		return false, nil
+	}
+	m.insert(iou, pgt, now)
+	return true, nil
+}
+
+// insert stores the IOU and wakes waiters, the caller must hold the mutex.
+func (m *MemoryProxyStore) insert(iou, pgt string, now time.Time) {
+	if now.Sub(m.lastSweep) >= lazySweepInterval {
+		m.sweep(now)
+	}
+	m.store[iou] = memoryProxyEntry{pgt: pgt, expiresAt: now.Add(m.ttl)}
+
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// Consume implements Consumer.
+func (m *MemoryProxyStore) Consume(iou string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	e, ok := m.store[iou]
+	if !ok {
+		return nil
+	}
+
+	if expiresAt := time.Now().Add(m.consumedTTL); expiresAt.Before(e.expiresAt) {
+		e.expiresAt = expiresAt
+		m.store[iou] = e
+	}
+	return nil
+}
+
+// Wait implements Waiter.
+func (m *MemoryProxyStore) Wait(iou string, timeout time.Duration) (string, bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		m.mutex.RLock()
+		pgt, ok := m.lookup(iou, time.Now())
+		changed := m.changed
+		m.mutex.RUnlock()
+
+		if ok {
+			return pgt, true
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return "", false
+		}
+	}
+}
+
+// Sweep removes all expired IOUs from the store.
+func (m *MemoryProxyStore) Sweep() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.sweep(time.Now())
+}
+
+// sweep removes the IOUs expired at now, the caller must hold the mutex.
+func (m *MemoryProxyStore) sweep(now time.Time) {
+	for iou, e := range m.store {
+		if !now.Before(e.expiresAt) {
+			delete(m.store, iou)
+		}
+	}
+	m.lastSweep = now
+}
+
+// Close stops the background sweeper, if any. The store remains usable, expired IOUs are still never returned.
+func (m *MemoryProxyStore) Close() error {
+	m.closeOnce.Do(func() { close(m.done) })
 	return nil
 }
 
+// lookup returns the PGT of an IOU which has not expired, the caller must hold the mutex.
+func (m *MemoryProxyStore) lookup(iou string, now time.Time) (string, bool) {
+	e, ok := m.store[iou]
+	if !ok || !now.Before(e.expiresAt) {
+		return "", false
+	}
+	return e.pgt, true
+}
+
+func (m *MemoryProxyStore) sweeper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.Sweep()
+		case <-m.done:
+			return
+		}
+	}
+}
+
 var _ ProxyStore = &MemoryProxyStore{}
+var _ Waiter = &MemoryProxyStore{}
+var _ Consumer = &MemoryProxyStore{}
+var _ Adder = &MemoryProxyStore{}
@@ -2,6 +2,7 @@ package store
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -34,3 +35,110 @@ func TestNewMemoryProxyStore(t *testing.T) {
 	assert.False(t, ok)
 	assert.Equal(t, "", pgt)
 }
+
+func TestMemoryProxyStoreWait(t *testing.T) {
+	store := NewMemoryProxyStore()
+	store.Set("iou", "pgt")
+
+	// Already stored
+	pgt, ok := store.Wait("iou", time.Millisecond)
+	assert.True(t, ok)
+	assert.Equal(t, "pgt", pgt)
+
+	// Stored while waiting, other IOUs do not end the wait
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		store.Set("other", "pgt-other")
+		time.Sleep(10 * time.Millisecond)
+		store.Set("late", "pgt-late")
+	}()
+
+	pgt, ok = store.Wait("late", time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, "pgt-late", pgt)
+
+	// Never stored
+	pgt, ok = store.Wait("missing", 10*time.Millisecond)
+	assert.False(t, ok)
+	assert.Equal(t, "", pgt)
+}
+
+func TestMemoryProxyStoreExpiry(t *testing.T) {
+	store := NewMemoryProxyStoreWithOptions(&MemoryProxyStoreOptions{
+		TTL:         200 * time.Millisecond,
+		ConsumedTTL: 10 * time.Millisecond,
+	})
+	defer store.Close()
+
+	store.Set("consumed", "pgt-consumed")
+	store.Set("unconsumed", "pgt-unconsumed")
+	assert.NoError(t, store.Consume("consumed"))
+	assert.NoError(t, store.Consume("missing"))
+
+	// Consumed IOUs remain available for the grace period
+	pgt, ok := store.Get("consumed")
+	assert.True(t, ok)
+	assert.Equal(t, "pgt-consumed", pgt)
+
+	time.Sleep(30 * time.Millisecond)
+	_, ok = store.Get("consumed")
+	assert.False(t, ok)
+	_, ok = store.Get("unconsumed")
+	assert.True(t, ok)
+
+	time.Sleep(200 * time.Millisecond)
+	_, ok = store.Get("unconsumed")
+	assert.False(t, ok)
+	_, ok = store.Wait("unconsumed", time.Millisecond)
+	assert.False(t, ok)
+
+	// Expired entries are only removed by a sweep
+	assert.Len(t, store.store, 2)
+	store.Sweep()
+	assert.Len(t, store.store, 0)
+
+	// or while storing IOUs once the last sweep is old enough
+	store.Set("expired", "pgt-expired")
+	time.Sleep(250 * time.Millisecond)
+	store.lastSweep = time.Now().Add(-lazySweepInterval)
+	store.Set("fresh", "pgt-fresh")
+	assert.Len(t, store.store, 1)
+}
+
+func TestMemoryProxyStoreSweeper(t *testing.T) {
+	store := NewMemoryProxyStoreWithOptions(&MemoryProxyStoreOptions{
+		TTL:           time.Millisecond,
+		SweepInterval: 5 * time.Millisecond,
+	})
+
+	store.Set("iou", "pgt")
+	assert.Eventually(t, func() bool {
+		store.mutex.RLock()
+		defer store.mutex.RUnlock()
+		return len(store.store) == 0
+	}, time.Second, 5*time.Millisecond)
+
+	assert.NoError(t, store.Close())
+	assert.NoError(t, store.Close())
+}
+
+func TestMemoryProxyStoreAdd(t *testing.T) {
+	store := NewMemoryProxyStoreWithOptions(&MemoryProxyStoreOptions{TTL: 10 * time.Millisecond})
+
+	added, err := store.Add("iou", "pgt-1")
+	assert.NoError(t, err)
+	assert.True(t, added)
+
+	added, err = store.Add("iou", "pgt-2")
+	assert.NoError(t, err)
+	assert.False(t, added)
+
+	pgt, _ := store.Get("iou")
+	assert.Equal(t, "pgt-1", pgt)
+
+	// Expired IOUs can be added again
+	time.Sleep(20 * time.Millisecond)
+	added, err = store.Add("iou", "pgt-3")
+	assert.NoError(t, err)
+	assert.True(t, added)
+}
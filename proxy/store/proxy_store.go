@@ -1,8 +1,33 @@
 package store
 
+import "time"
+
 type ProxyStore interface {
 	Get(iou string) (string, bool)
 	Set(iou, pgt string) error
 	Delete(iou string) error
 	Clear() error
 }
+
+// Waiter is implemented by ProxyStores which can wake callers waiting for an IOU as soon as it is stored.
+// Stores which do not implement Waiter are polled instead.
+type Waiter interface {
+	// Wait blocks until the IOU is stored or the timeout elapses, and returns the PGT like Get.
+	Wait(iou string, timeout time.Duration) (string, bool)
+}
+
+// Consumer is implemented by ProxyStores which expire IOUs once their PGT was handed to a session.
+//
+// After validation the PGT is owned by the session it was resolved for and expires with it, so the IOU
+// only needs to outlive the lookups of other instances which are still resolving it.
+type Consumer interface {
+	// Consume shortens the lifetime of the IOU to the store's grace period for consumed IOUs.
+	Consume(iou string) error
+}
+
+// Adder is implemented by ProxyStores which can store an IOU only if it is not stored yet, atomically.
+// Stores which do not implement Adder are checked with Get before Set, which concurrent callbacks can race.
+type Adder interface {
+	// Add stores the PGT of the IOU and returns true, or returns false if the IOU is already stored.
+	Add(iou, pgt string) (bool, error)
+}
@@ -0,0 +1,241 @@
+package cas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ErrTargetServiceNotAllowed is returned by a ProxyTransport when the target service is not in its allowlist.
+var ErrTargetServiceNotAllowed = errors.New("cas: proxy transport: target service not allowed")
+
+// ProxyTransportOptions : ProxyTransport configuration options
+type ProxyTransportOptions struct {
+	// Base performs the requests to the target services, defaults to http.DefaultTransport
+	Base http.RoundTripper
+
+	// AllowedServices lists the target services proxy tickets may be obtained for, no requests are allowed
+	// when empty. Entries starting with "^" are regular expressions which must match the whole target service,
+	// other entries must have the same scheme and host as the target service and a path which prefixes it.
+	AllowedServices []string
+
+	// TargetService returns the service a proxy ticket is requested for,
+	// defaults to the request url without its fragment
+	TargetService func(r *http.Request) string
+
+	// Header sends the proxy ticket in this request header instead of the ticket query parameter
+	Header string
+}
+
+// ProxyTransport is a http.RoundTripper which obtains a fresh proxy ticket for every request
+// and attaches it to the request.
+//
+// Proxy tickets are requested with the proxy granting ticket of an authenticated request, so a
+// ProxyTransport must only be used while handling that request.
+type ProxyTransport struct {
+	c               *Client
+	a               *AuthenticationResponse
+	base            http.RoundTripper
+	allowedServices []allowedService
+	targetService   func(r *http.Request) string
+	header          string
+}
+
+// allowedService is a compiled ProxyTransportOptions.AllowedServices entry
+type allowedService struct {
+	re     *regexp.Regexp
+	prefix *url.URL
+}
+
+// NewProxyTransport creates a ProxyTransport which uses the proxy granting ticket of the authenticated request.
+func NewProxyTransport(r *http.Request, options *ProxyTransportOptions) (*ProxyTransport, error) {
+	c := getClient(r)
+	if c == nil {
+		return nil, errNoClient
+	}
+
+	a := getAuthenticationResponse(r)
+	if a == nil {
+		return nil, errNoAuthenticationResponse
+	}
+	if a.ProxyGrantingTicket == "" && a.ProxyGrantingTicketID == "" {
+		return nil, errors.New("cas: no proxy granting ticket available in authentication response")
+	}
+
+	var base http.RoundTripper
+	if options.Base != nil {
+		base = options.Base
+	} else {
+		base = http.DefaultTransport
+	}
+
+	var targetService func(r *http.Request) string
+	if options.TargetService != nil {
+		targetService = options.TargetService
+	} else {
+		targetService = defaultTargetService
+	}
+
+	allowed := make([]allowedService, 0, len(options.AllowedServices))
+	for _, entry := range options.AllowedServices {
+		if strings.HasPrefix(entry, "^") {
+			re, err := regexp.Compile("(?:" + entry + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("cas: proxy transport: allowed service %q: %w", entry, err)
+			}
+			allowed = append(allowed, allowedService{re: re})
+			continue
+		}
+
+		u, err := url.Parse(entry)
+		if err != nil {
+			return nil, fmt.Errorf("cas: proxy transport: allowed service %q: %w", entry, err)
+		}
+		allowed = append(allowed, allowedService{prefix: u})
+	}
+
+	return &ProxyTransport{
+		c:               c,
+		a:               a,
+		base:            base,
+		allowedServices: allowed,
+		targetService:   targetService,
+		header:          options.Header,
+	}, nil
+}
+
+// NewProxyClient creates a http.Client which attaches proxy tickets obtained with the proxy granting ticket
+// of the authenticated request.
+func NewProxyClient(r *http.Request, options *ProxyTransportOptions) (*http.Client, error) {
+	t, err := NewProxyTransport(r, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{Transport: t}, nil
+}
+
+// RoundTrip requests a proxy ticket for the target service of the request and sends the request with it.
+//
+// A proxyFailure returned by the CAS server is reported as an *AuthenticationError.
+func (t *ProxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	service := t.targetService(req)
+	if !t.isAllowed(service) {
+		closeRequestBody(req)
+		return nil, ErrTargetServiceNotAllowed
+	}
+
+	pt, err := t.c.requestProxyTicket(req.Context(), t.a, service)
+	if err != nil {
+		closeRequestBody(req)
+		return nil, err
+	}
+
+	// RoundTrippers must not modify the request
+	r := req.Clone(req.Context())
+	if t.header != "" {
+		r.Header.Set(t.header, pt)
+	} else {
+		q := r.URL.Query()
+		q.Set("ticket", pt)
+		r.URL.RawQuery = q.Encode()
+	}
+
+	return t.base.RoundTrip(r)
+}
+
+// isAllowed determines whether proxy tickets may be requested for the target service.
+func (t *ProxyTransport) isAllowed(service string) bool {
+	u, err := url.Parse(service)
+	if err != nil {
+		return false
+	}
+
+	for _, allowed := range t.allowedServices {
+		if allowed.re != nil {
+			if allowed.re.MatchString(service) {
+				return true
+			}
+			continue
+		}
+
+		p := allowed.prefix
+		if !strings.EqualFold(p.Scheme, u.Scheme) || !strings.EqualFold(p.Host, u.Host) {
+			continue
+		}
+
+		if p.Path == "" || p.Path == "/" || u.Path == p.Path ||
+			strings.HasPrefix(u.Path, strings.TrimSuffix(p.Path, "/")+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// defaultTargetService uses the request url without its fragment as the target service.
+func defaultTargetService(r *http.Request) string {
+	u := *r.URL
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	return u.String()
+}
+
+func closeRequestBody(r *http.Request) {
+	if r.Body != nil {
+		r.Body.Close()
+	}
+}
+
+// requestProxyTicket requests a proxy ticket for the target service from the CAS server.
+//
+// The PGT resolved during validation is used, falling back to looking up the IOU in the ProxyStore.
+func (c *Client) requestProxyTicket(ctx context.Context, a *AuthenticationResponse, targetService string) (string, error) {
+	var u string
+	var err error
+	if a.ProxyGrantingTicketID != "" {
+		u, err = c.proxy.GetProxyURLForPgt(targetService, a.ProxyGrantingTicketID)
+	} else {
+		u, err = c.proxy.GetProxyURL(targetService, a.ProxyGrantingTicket)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := sendFailover(c.urlScheme, c.client, req, c.retry, c.breaker, c.logger)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("cas: proxy endpoint returned status code %v", resp.StatusCode)
+	}
+
+	pt, err := ParseProxyResponse(data)
+	if err != nil {
+		c.logger.Warn("proxy ticket request failed",
+			slog.String("targetService", targetService),
+			slog.String("error", err.Error()))
+		return "", err
+	}
+
+	return pt, nil
+}
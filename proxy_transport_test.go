@@ -0,0 +1,157 @@
+package cas
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mattmohan-flipp/cas/v2/proxy"
+	"github.com/mattmohan-flipp/cas/v2/proxy/store"
+	"github.com/mattmohan-flipp/cas/v2/urlscheme"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// proxyCasHandler serves proxy tickets for the PGT-1 proxy granting ticket, numbered in the order they are issued.
+func proxyCasHandler() http.HandlerFunc {
+	w := &ServiceResponseWriter{}
+	var issued atomic.Int32
+
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/proxy" || r.URL.Query().Get("pgt") != "PGT-1" {
+			w.WriteProxyFailure(rw, INVALID_TICKET, "Ticket not recognized")
+			return
+		}
+
+		w.WriteProxySuccess(rw, fmt.Sprintf("PT-%d", issued.Add(1)))
+	}
+}
+
+// newProxyRequest returns a request authenticated by a client which knows the PGT-1 proxy granting ticket.
+func newProxyRequest(t *testing.T, casURL string) *http.Request {
+	u, err := url.Parse(casURL)
+	require.NoError(t, err)
+
+	proxyStore := store.NewMemoryProxyStore()
+	proxyStore.Set("PGTIOU-1", "PGT-1")
+
+	c := NewClient(&Options{
+		URL: u,
+		Proxy: proxy.NewProxy(urlscheme.NewDefaultURLScheme(u), &proxy.ProxyOptions{
+			RequestProxy:     true,
+			ProxyCallbackURL: "https://web.example.com/pgtCallback",
+			ProxyStore:       proxyStore,
+		}),
+	})
+
+	r := httptest.NewRequest("GET", "https://web.example.com/", nil)
+	setClient(r, c)
+	setAuthenticationResponse(r, &AuthenticationResponse{User: "enoch.root", ProxyGrantingTicket: "PGTIOU-1"})
+
+	return r
+}
+
+func TestProxyTransportAttachesTicket(t *testing.T) {
+	server := newFakeCasServer(proxyCasHandler())
+	defer server.Close()
+
+	var tickets []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tickets = append(tickets, r.URL.Query().Get("ticket")+r.Header.Get("X-Proxy-Ticket"))
+		assert.Equal(t, "1", r.URL.Query().Get("page"))
+	}))
+	defer api.Close()
+
+	r := newProxyRequest(t, server.URL)
+
+	client, err := NewProxyClient(r, &ProxyTransportOptions{AllowedServices: []string{api.URL + "/data"}})
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(api.URL + "/data/items?page=1")
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	// Every request gets a fresh proxy ticket
+	assert.Equal(t, []string{"PT-1", "PT-2"}, tickets)
+
+	client, err = NewProxyClient(r, &ProxyTransportOptions{AllowedServices: []string{api.URL}, Header: "X-Proxy-Ticket"})
+	require.NoError(t, err)
+
+	resp, err := client.Get(api.URL + "/data?page=1")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, "PT-3", tickets[2])
+}
+
+func TestProxyTransportAllowlist(t *testing.T) {
+	server := newFakeCasServer(proxyCasHandler())
+	defer server.Close()
+
+	r := newProxyRequest(t, server.URL)
+	transport, err := NewProxyTransport(r, &ProxyTransportOptions{
+		AllowedServices: []string{"https://api.example.com/v1/", `^https://data\.example\.com/.*`, `^https://front.example.com`},
+	})
+	require.NoError(t, err)
+
+	cases := map[string]bool{
+		"https://api.example.com/v1/items":         true,
+		"https://API.example.com/v1/":              true,
+		"https://data.example.com/x":               true,
+		"https://api.example.com/v2/items":         false,
+		"https://api.example.com/v1evil":           false,
+		"http://api.example.com/v1/items":          false,
+		"https://api.example.com.evil.com/v1/item": false,
+		"https://front.example.com":                true,
+		"https://front.example.com.evil/x":         false,
+		"https://data.example.com.evil/x":          false,
+	}
+
+	for service, allowed := range cases {
+		assert.Equal(t, allowed, transport.isAllowed(service), service)
+	}
+
+	req, _ := http.NewRequest("GET", "https://evil.example.com/", nil)
+	_, err = (&http.Client{Transport: transport}).Do(req)
+	assert.True(t, errors.Is(err, ErrTargetServiceNotAllowed))
+	assert.Equal(t, 0, server.Count())
+
+	// An empty allowlist refuses every service
+	transport, err = NewProxyTransport(r, &ProxyTransportOptions{})
+	require.NoError(t, err)
+	assert.False(t, transport.isAllowed("https://api.example.com/v1/"))
+}
+
+func TestProxyTransportProxyFailure(t *testing.T) {
+	server := newFakeCasServer(proxyCasHandler())
+	defer server.Close()
+
+	r := newProxyRequest(t, server.URL)
+	setAuthenticationResponse(r, &AuthenticationResponse{User: "enoch.root", ProxyGrantingTicket: "PGTIOU-2"})
+	getClient(r).proxy.Handle(httptest.NewRecorder(), httptest.NewRequest("GET", "/?pgtIou=PGTIOU-2&pgtId=PGT-expired", nil))
+
+	client, err := NewProxyClient(r, &ProxyTransportOptions{AllowedServices: []string{"https://api.example.com/"}})
+	require.NoError(t, err)
+
+	_, err = client.Get("https://api.example.com/")
+	var authErr *AuthenticationError
+	require.True(t, errors.As(err, &authErr), "%v", err)
+	assert.Equal(t, INVALID_TICKET, authErr.Code)
+}
+
+func TestNewProxyTransportRequiresProxyGrantingTicket(t *testing.T) {
+	r := httptest.NewRequest("GET", "https://web.example.com/", nil)
+	_, err := NewProxyTransport(r, &ProxyTransportOptions{})
+	assert.Equal(t, errNoClient, err)
+
+	setClient(r, NewClient(&Options{URL: &url.URL{}}))
+	setAuthenticationResponse(r, &AuthenticationResponse{User: "enoch.root"})
+	_, err = NewProxyTransport(r, &ProxyTransportOptions{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,171 @@
+package cas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/mattmohan-flipp/cas/v2/proxy"
+	"github.com/mattmohan-flipp/cas/v2/urlscheme"
+)
+
+var errProxyValidateUnsupported = errors.New("cas: proxy ticket validation requires the CAS 2 or CAS 3 protocol")
+
+// ProxyChain describes a list of proxies which are allowed to proxy into the service.
+//
+// Entries are compared with AuthenticationResponse.Proxies, which lists the most recent proxy first. Entries
+// starting with "^" are regular expressions which must match the whole proxy callback url, all other entries must
+// match the proxy callback url exactly.
+type ProxyChain []string
+
+// Matches determines whether the proxies reported by CAS match the chain.
+//
+// The patterns are compiled on every call, the ServiceTicketValidator compiles its allowed chains once.
+func (c ProxyChain) Matches(proxies []string) bool {
+	compiled, err := c.compile()
+	if err != nil {
+		return false
+	}
+
+	return compiled.matches(proxies)
+}
+
+// compile turns every entry of the chain into a regular expression matching the whole proxy callback url.
+func (c ProxyChain) compile() (compiledProxyChain, error) {
+	compiled := make(compiledProxyChain, len(c))
+	for i, pattern := range c {
+		if !strings.HasPrefix(pattern, "^") {
+			pattern = "^" + regexp.QuoteMeta(pattern)
+		}
+
+		re, err := regexp.Compile("(?:" + pattern + ")$")
+		if err != nil {
+			return nil, err
+		}
+		compiled[i] = re
+	}
+
+	return compiled, nil
+}
+
+type compiledProxyChain []*regexp.Regexp
+
+func (c compiledProxyChain) matches(proxies []string) bool {
+	if len(c) != len(proxies) {
+		return false
+	}
+
+	for i, re := range c {
+		if !re.MatchString(proxies[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// compileProxyChains compiles the allowed proxy chains, chains with invalid patterns are logged and never match.
+func compileProxyChains(chains []ProxyChain, logger *slog.Logger) []compiledProxyChain {
+	compiled := make([]compiledProxyChain, 0, len(chains))
+	for _, chain := range chains {
+		c, err := chain.compile()
+		if err != nil {
+			logger.Error("Ignoring invalid proxy chain", slog.Any("chain", chain), slog.Any("error", err))
+			continue
+		}
+		compiled = append(compiled, c)
+	}
+
+	return compiled
+}
+
+// ValidateProxyTicket validates a proxy or service ticket using the proxyValidate endpoint.
+//
+// The proxies reported by CAS must match one of the allowed proxy chains, unless the validator accepts any proxy.
+// Service tickets, which have no proxies, are always accepted.
+//
+// When the proxy is enabled a pgtUrl is sent as well, so a service accepting proxy tickets can in turn
+// obtain proxy tickets for the services behind it. The PGT is resolved like in ValidateTicket.
+func (validator *ServiceTicketValidator) ValidateProxyTicket(serviceURL *url.URL, ticket string, proxy *proxy.Proxy) (*AuthenticationResponse, error) {
+	return validator.ValidateProxyTicketContext(context.Background(), serviceURL, ticket, proxy)
+}
+
+// ValidateProxyTicketContext is like ValidateProxyTicket, but canceled when the context is done.
+func (validator *ServiceTicketValidator) ValidateProxyTicketContext(ctx context.Context, serviceURL *url.URL, ticket string, proxy *proxy.Proxy) (*AuthenticationResponse, error) {
+	validator.logger.Info("Validating proxy ticket", slog.String("ticket", ticket), slog.String("serviceURL", serviceURL.String()))
+
+	success, err := validator.validateWithVersion([]ProtocolVersion{ProtocolCAS3, ProtocolCAS2}, func(version ProtocolVersion) (*AuthenticationResponse, error) {
+		u, err := validator.proxyValidateUrl(serviceURL, ticket, proxy, version)
+		if err != nil {
+			return nil, err
+		}
+
+		return validator.validateServiceResponse(ctx, u)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !validator.isAllowedProxyChain(success.Proxies) {
+		validator.logger.Warn("Rejected proxy chain", slog.String("ticket", ticket), slog.Any("proxies", success.Proxies))
+
+		msg := fmt.Sprintf("proxy chain %v is not allowed for service %s", success.Proxies, sanitisedURLString(serviceURL))
+		return nil, &AuthenticationError{Code: UNAUTHORIZED_SERVICE_PROXY, Message: msg}
+	}
+
+	validator.resolveProxyGrantingTicket(ctx, success, proxy)
+
+	return success, nil
+}
+
+// ProxyValidateUrl creates the proxy validation url for the cas >= 2 protocol.
+func (validator *ServiceTicketValidator) ProxyValidateUrl(serviceURL *url.URL, ticket string, proxy *proxy.Proxy) (string, error) {
+	version := validator.ProtocolVersion()
+	if version == ProtocolAuto {
+		version = ProtocolCAS3
+	}
+
+	return validator.proxyValidateUrl(serviceURL, ticket, proxy, version)
+}
+
+func (validator *ServiceTicketValidator) proxyValidateUrl(serviceURL *url.URL, ticket string, proxy *proxy.Proxy, version ProtocolVersion) (string, error) {
+	var u *url.URL
+	var err error
+	switch version {
+	case ProtocolCAS3:
+		u, err = urlscheme.P3ProxyValidate(validator.urlScheme)
+	case ProtocolCAS2:
+		u, err = validator.urlScheme.ProxyValidate()
+	default:
+		return "", errProxyValidateUnsupported
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return validator.validationUrl(u, serviceURL, ticket, proxy, false), nil
+}
+
+// acceptsProxyTickets indicates whether tickets should be validated with proxyValidate.
+func (validator *ServiceTicketValidator) acceptsProxyTickets() bool {
+	return validator.acceptAnyProxy || len(validator.allowedProxyChains) > 0
+}
+
+// isAllowedProxyChain determines whether the proxies match the configured policy.
+func (validator *ServiceTicketValidator) isAllowedProxyChain(proxies []string) bool {
+	if len(proxies) == 0 || validator.acceptAnyProxy {
+		return true
+	}
+
+	for _, chain := range validator.allowedProxyChains {
+		if chain.matches(proxies) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,166 @@
+package cas
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/mattmohan-flipp/cas/v2/proxy"
+	"github.com/mattmohan-flipp/cas/v2/proxy/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const proxyValidateResponse = `<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">
+  <cas:authenticationSuccess>
+    <cas:user>enoch.root</cas:user>
+    <cas:proxies>
+      <cas:proxy>https://api.example.com/pgtCallback</cas:proxy>
+      <cas:proxy>https://web.example.com/pgtCallback</cas:proxy>
+    </cas:proxies>
+  </cas:authenticationSuccess>
+</cas:serviceResponse>`
+
+func TestProxyChainMatches(t *testing.T) {
+	proxies := []string{"https://api.example.com/pgtCallback", "https://web.example.com/pgtCallback"}
+
+	assert.True(t, ProxyChain{"https://api.example.com/pgtCallback", "https://web.example.com/pgtCallback"}.Matches(proxies))
+	assert.True(t, ProxyChain{`^https://api\.example\.com/.*`, "https://web.example.com/pgtCallback"}.Matches(proxies))
+	assert.False(t, ProxyChain{"https://api.example.com/pgtCallback"}.Matches(proxies))
+	assert.False(t, ProxyChain{"https://web.example.com/pgtCallback", "https://api.example.com/pgtCallback"}.Matches(proxies))
+	assert.False(t, ProxyChain{`^https://evil\.example\.com/`, "https://web.example.com/pgtCallback"}.Matches(proxies))
+	assert.False(t, ProxyChain{`^(`, "https://web.example.com/pgtCallback"}.Matches(proxies))
+
+	// Patterns must match the whole proxy callback url
+	assert.False(t, ProxyChain{`^https://api\.example\.com/`, "https://web.example.com/pgtCallback"}.Matches(proxies))
+	assert.False(t, ProxyChain{`^https://front.example.com`}.Matches([]string{"https://front.example.com.evil/pgtCallback"}))
+	assert.True(t, ProxyChain{`^https://front.example.com`}.Matches([]string{"https://front.example.com"}))
+	assert.False(t, ProxyChain{"https://front.example.com"}.Matches([]string{"https://front.example.com.evil/pgtCallback"}))
+}
+
+// proxyValidateHandler answers PT-1 with a proxy chain and other tickets as service tickets.
+func proxyValidateHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Query().Get("ticket") {
+	case "PT-1":
+		fmt.Fprint(w, proxyValidateResponse)
+	default:
+		fmt.Fprint(w, validateSuccessResponse)
+	}
+}
+
+func TestValidateProxyTicket(t *testing.T) {
+	server := newFakeCasServer(proxyValidateHandler)
+	defer server.Close()
+
+	casURL, _ := url.Parse(server.URL)
+	service, _ := url.Parse("http://example.com/")
+
+	validator := NewServiceTicketValidator(ServiceTicketValidatorOptions{
+		Client:          server.Client(),
+		CasURL:          casURL,
+		ProtocolVersion: ProtocolCAS2,
+		AllowedProxyChains: []ProxyChain{
+			{`^https://api\.example\.com/.*`, "https://web.example.com/pgtCallback"},
+		},
+	})
+
+	success, err := validator.ValidateProxyTicket(service, "PT-1", newDisabledProxy())
+	require.NoError(t, err)
+	assert.Equal(t, "enoch.root", success.User)
+	assert.Equal(t, []string{"https://api.example.com/pgtCallback", "https://web.example.com/pgtCallback"}, success.Proxies)
+	assert.Equal(t, []string{"/proxyValidate"}, server.Paths())
+
+	success, err = validator.ValidateProxyTicket(service, "ST-1", newDisabledProxy())
+	require.NoError(t, err)
+	assert.Empty(t, success.Proxies)
+}
+
+func TestValidateProxyTicketRejectsUnknownChain(t *testing.T) {
+	server := newFakeCasServer(proxyValidateHandler)
+	defer server.Close()
+
+	casURL, _ := url.Parse(server.URL)
+	service, _ := url.Parse("http://example.com/")
+
+	validator := NewServiceTicketValidator(ServiceTicketValidatorOptions{
+		Client:             server.Client(),
+		CasURL:             casURL,
+		AllowedProxyChains: []ProxyChain{{"https://web.example.com/pgtCallback"}},
+	})
+
+	success, err := validator.ValidateProxyTicket(service, "PT-1", newDisabledProxy())
+	assert.Nil(t, success)
+	require.Error(t, err)
+	assert.Equal(t, []string{"/p3/proxyValidate"}, server.Paths())
+
+	authErr, ok := err.(*AuthenticationError)
+	require.True(t, ok)
+	assert.Equal(t, UNAUTHORIZED_SERVICE_PROXY, authErr.Code)
+}
+
+func TestValidateProxyTicketAcceptAnyProxy(t *testing.T) {
+	server := newFakeCasServer(proxyValidateHandler)
+	defer server.Close()
+
+	casURL, _ := url.Parse(server.URL)
+	service, _ := url.Parse("http://example.com/")
+
+	validator := NewServiceTicketValidator(ServiceTicketValidatorOptions{
+		Client:         server.Client(),
+		CasURL:         casURL,
+		AcceptAnyProxy: true,
+	})
+
+	success, err := validator.ValidateProxyTicket(service, "PT-1", newDisabledProxy())
+	require.NoError(t, err)
+	assert.Len(t, success.Proxies, 2)
+}
+
+func TestValidateProxyTicketUnsupportedProtocol(t *testing.T) {
+	casURL, _ := url.Parse("https://cas.example.com/")
+	service, _ := url.Parse("http://example.com/")
+
+	validator := NewServiceTicketValidator(ServiceTicketValidatorOptions{CasURL: casURL, ProtocolVersion: ProtocolCAS1})
+
+	_, err := validator.ValidateProxyTicket(service, "PT-1", newDisabledProxy())
+	assert.Equal(t, errProxyValidateUnsupported, err)
+}
+
+func TestValidateProxyTicketResolvesProxyGrantingTicket(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/p3/proxyValidate", r.URL.Path)
+		assert.Equal(t, "https://api.example.com/pgtCallback", r.URL.Query().Get("pgtUrl"))
+
+		(&ServiceResponseWriter{}).WriteSuccess(w, &AuthenticationResponse{
+			User:                "enoch.root",
+			ProxyGrantingTicket: "PGTIOU-2",
+			Proxies:             []string{"https://web.example.com/pgtCallback"},
+		})
+	}))
+	defer server.Close()
+
+	casURL, _ := url.Parse(server.URL)
+	validator := NewServiceTicketValidator(ServiceTicketValidatorOptions{
+		Client:             server.Client(),
+		CasURL:             casURL,
+		AllowedProxyChains: []ProxyChain{{"https://web.example.com/pgtCallback"}},
+	})
+
+	proxyStore := store.NewMemoryProxyStore()
+	defer proxyStore.Close()
+	proxyStore.Set("PGTIOU-2", "PGT-2")
+
+	p := proxy.NewProxy(validator.urlScheme, &proxy.ProxyOptions{
+		RequestProxy:     true,
+		ProxyCallbackURL: "https://api.example.com/pgtCallback",
+		ProxyStore:       proxyStore,
+	})
+
+	service, _ := url.Parse("https://api.example.com/")
+	success, err := validator.ValidateProxyTicket(service, "PT-1", p)
+	require.NoError(t, err)
+	assert.Equal(t, "PGTIOU-2", success.ProxyGrantingTicket)
+	assert.Equal(t, "PGT-2", success.ProxyGrantingTicketID)
+}
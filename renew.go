@@ -0,0 +1,113 @@
+package cas
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	renewCookieName = "_cas_renew"
+
+	// defaultRenewMaxAge is how long after a primary authentication a login satisfies Renew
+	defaultRenewMaxAge = 5 * time.Minute
+)
+
+// RenewUrlForRequest determines the CAS login URL forcing a new primary authentication for the http.Request.
+func (c *Client) RenewUrlForRequest(r *http.Request) (string, error) {
+	return c.loginUrlForRequest(r, url.Values{"renew": {"true"}})
+}
+
+// RedirectToRenew replies to the request with a redirect URL to authenticate with CAS using fresh credentials.
+//
+// The ticket returned by CAS is validated with renew=true.
+func (c *Client) RedirectToRenew(w http.ResponseWriter, r *http.Request) {
+	u, err := c.RenewUrlForRequest(r)
+	if err != nil {
+		c.logger.Error("Error generating renew URL", slog.Any("error", err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	c.logger.Info("Renewing login, redirecting client", slog.String("to", u), slog.Int("status", http.StatusFound))
+
+	c.setRenewCookie(w)
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+// Renew returns a http.Handler which requires a recent primary authentication for sensitive handlers.
+//
+// The request is served when CAS reported a new login within RenewMaxAge, otherwise the client is redirected to
+// log in again with renew=true.
+//
+// CAS 1 and CAS 2 servers do not report the authentication date. Only for responses without it the renew=true
+// validation is trusted: the CAS server only accepts the ticket when it was issued from a fresh primary
+// authentication. This is only known while serving the request which presented the ticket, so later requests are
+// renewed again. A response reporting an old login is never trusted.
+//
+// Like Handler, Renew must be wrapped by Handle so that tickets are validated.
+func (c *Client) Renew(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setClient(r, c)
+
+		if a := getAuthenticationResponse(r); a != nil && (c.isRecentLogin(a) || isRenewed(r) && a.AuthenticationDate.IsZero()) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		c.RedirectToRenew(w, r)
+	})
+}
+
+// isRecentLogin determines whether the authentication response reports a new login within the renew max age.
+func (c *Client) isRecentLogin(a *AuthenticationResponse) bool {
+	if !a.IsNewLogin || a.AuthenticationDate.IsZero() {
+		return false
+	}
+
+	return time.Since(a.AuthenticationDate) <= c.renewMaxAge
+}
+
+// setRenewed records that the ticket of the request was validated with renew=true.
+func setRenewed(r *http.Request) {
+	ctx := context.WithValue(r.Context(), renewedKey, true)
+	r2 := r.WithContext(ctx)
+	*r = *r2
+}
+
+// isRenewed determines whether the ticket of the request was validated with renew=true.
+func isRenewed(r *http.Request) bool {
+	renewed, _ := r.Context().Value(renewedKey).(bool)
+	return renewed
+}
+
+// isRenewPending determines whether the client was redirected to CAS with renew=true.
+func (c *Client) isRenewPending(r *http.Request) bool {
+	_, err := r.Cookie(c.cookieName(renewCookieName))
+	return err == nil
+}
+
+// setRenewCookie records on the client that the next ticket must be validated with renew=true.
+func (c *Client) setRenewCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.cookieName(renewCookieName),
+		Value:    "1",
+		Path:     c.cookie.Path,
+		Domain:   c.cookie.Domain,
+		HttpOnly: true,
+		Secure:   c.cookie.Secure,
+		SameSite: c.cookie.SameSite,
+	})
+}
+
+// clearRenewCookie removes the pending renew marker from the client.
+func (c *Client) clearRenewCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:   c.cookieName(renewCookieName),
+		Path:   c.cookie.Path,
+		Domain: c.cookie.Domain,
+		MaxAge: -1,
+	})
+}
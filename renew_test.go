@@ -0,0 +1,158 @@
+package cas
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const renewStaleResponse = `<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">
+  <cas:authenticationSuccess>
+    <cas:user>enoch.root</cas:user>
+    <cas:attributes>
+      <cas:authenticationDate>2015-02-10T14:28:42Z</cas:authenticationDate>
+      <cas:isFromNewLogin>false</cas:isFromNewLogin>
+    </cas:attributes>
+  </cas:authenticationSuccess>
+</cas:serviceResponse>`
+
+const failureResponse = `<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">
+  <cas:authenticationFailure code="INVALID_TICKET">Ticket not recognized</cas:authenticationFailure>
+</cas:serviceResponse>`
+
+// renewCasHandler answers like a CAS 2 server which only accepts ST-renewed when validated with renew=true.
+func renewCasHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	switch {
+	case q.Get("ticket") == "ST-renewed" && q.Get("renew") == "true":
+		fmt.Fprint(w, validateSuccessResponse)
+	case q.Get("ticket") == "ST-stale":
+		fmt.Fprint(w, renewStaleResponse)
+	default:
+		fmt.Fprint(w, failureResponse)
+	}
+}
+
+func TestRenewRedirectsStaleLogin(t *testing.T) {
+	server := newFakeCasServer(renewCasHandler)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	tickets := &MemoryStore{}
+	client := NewClient(&Options{
+		URL:             u,
+		Store:           tickets,
+		ProtocolVersion: ProtocolCAS2,
+	})
+
+	handler := client.Handle(client.Renew(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Approved by %s", Username(r))
+	})))
+
+	// Logged in, but not recently
+	req, err := http.NewRequest("GET", "http://example.com/approve?ticket=ST-stale", nil)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, server.URL+"/login?renew=true&service=http%3A%2F%2Fexample.com%2Fapprove", w.Header().Get("Location"))
+
+	// CAS returns with a ticket from a fresh login
+	req, err = http.NewRequest("GET", "http://example.com/approve?ticket=ST-renewed", nil)
+	require.NoError(t, err)
+
+	resp := http.Response{Header: w.Header()}
+	cookies := resp.Cookies()
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Approved by enoch.root", w.Body.String())
+	queries := server.Queries()
+	assert.Equal(t, "true", queries[len(queries)-1].Get("renew"))
+
+	// The renewed ticket replaces the stale one, which is removed from the store
+	_, err = tickets.Read("ST-stale")
+	assert.Error(t, err)
+
+	// The CAS 2 response is not altered, so the next request renews again
+	a, err := tickets.Read("ST-renewed")
+	require.NoError(t, err)
+	assert.False(t, a.IsNewLogin)
+	assert.True(t, a.AuthenticationDate.IsZero())
+
+	req, err = http.NewRequest("GET", "http://example.com/approve", nil)
+	require.NoError(t, err)
+	for _, cookie := range cookies {
+		if cookie.Name == sessionCookieName {
+			req.AddCookie(cookie)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusFound, w.Code)
+}
+
+func TestRenewRejectsTicketWithoutRenew(t *testing.T) {
+	server := newFakeCasServer(renewCasHandler)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	client := NewClient(&Options{
+		URL:             u,
+		ProtocolVersion: ProtocolCAS2,
+	})
+
+	handler := client.Handle(client.Renew(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	// Without the renew marker the ticket is validated without renew=true and rejected by CAS
+	req, err := http.NewRequest("GET", "http://example.com/approve?ticket=ST-renewed", nil)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "", server.Queries()[0].Get("renew"))
+}
+
+func TestRenewRejectsOldLoginReportedByCas3(t *testing.T) {
+	server := newFakeCasServer(renewCasHandler)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	client := NewClient(&Options{
+		URL:             u,
+		ProtocolVersion: ProtocolCAS3,
+	})
+
+	handler := client.Handle(client.Renew(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	// The ticket is validated with renew=true, but CAS reports a login from long ago
+	req, err := http.NewRequest("GET", "http://example.com/approve?ticket=ST-stale", nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: renewCookieName, Value: "1"})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, "true", server.Queries()[0].Get("renew"))
+	assert.Equal(t, http.StatusFound, w.Code)
+}
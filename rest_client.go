@@ -1,12 +1,14 @@
 package cas
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
 	"path"
+	"strings"
 
 	"github.com/mattmohan-flipp/cas/v2/proxy"
 	"github.com/mattmohan-flipp/cas/v2/urlscheme"
@@ -28,6 +30,13 @@ type RestOptions struct {
 	URLScheme  urlscheme.URLScheme
 	Logger     *slog.Logger
 	Proxy      *proxy.Proxy
+
+	ProtocolVersion ProtocolVersion // CAS protocol used to validate tickets, defaults to ProtocolAuto
+	ResponseFormat  ResponseFormat  // Format requested for service responses, defaults to ResponseFormatXML
+
+	Retry          *RetryOptions   // Retry service ticket requests and validations failing transiently, no retries if nil
+	CircuitBreaker *CircuitBreaker // Fail requests fast while the CAS server is down, disabled if nil
+	FailoverURLs   []*url.URL      // Further CAS nodes, in order, used while the node at CasURL is down
 }
 
 // RestClient uses the rest protocol provided by cas
@@ -38,6 +47,8 @@ type RestClient struct {
 	stValidator *ServiceTicketValidator
 	logger      *slog.Logger
 	proxy       *proxy.Proxy
+	retry       *RetryOptions
+	breaker     *CircuitBreaker
 }
 
 // NewRestClient creates a new client for the cas rest protocol with the provided options
@@ -57,6 +68,8 @@ func NewRestClient(options *RestOptions) *RestClient {
 	var urlSchemeInstance urlscheme.URLScheme
 	if options.URLScheme != nil {
 		urlSchemeInstance = options.URLScheme
+	} else if len(options.FailoverURLs) > 0 {
+		urlSchemeInstance = newFailoverURLScheme(options.CasURL, options.FailoverURLs, options.Logger)
 	} else {
 		urlSchemeInstance = urlscheme.NewDefaultURLScheme(options.CasURL)
 	}
@@ -66,13 +79,26 @@ func NewRestClient(options *RestOptions) *RestClient {
 		proxyInstance = proxy.NewProxy(urlSchemeInstance, &proxy.ProxyOptions{RequestProxy: false, Logger: options.Logger})
 	}
 
+	stValidator := NewServiceTicketValidator(ServiceTicketValidatorOptions{
+		Client:          client,
+		CasURL:          options.CasURL,
+		URLScheme:       urlSchemeInstance,
+		Logger:          options.Logger,
+		ProtocolVersion: options.ProtocolVersion,
+		ResponseFormat:  options.ResponseFormat,
+		Retry:           options.Retry,
+		CircuitBreaker:  options.CircuitBreaker,
+	})
+
 	return &RestClient{
 		urlScheme:   urlSchemeInstance,
 		serviceURL:  options.ServiceURL,
 		client:      client,
-		stValidator: NewServiceTicketValidator(ServiceTicketValidatorOptions{Client: client, CasURL: options.CasURL, Logger: options.Logger}),
+		stValidator: stValidator,
 		logger:      options.Logger,
 		proxy:       proxyInstance,
+		retry:       options.Retry,
+		breaker:     options.CircuitBreaker,
 	}
 }
 
@@ -91,6 +117,11 @@ func (c *RestClient) HandleFunc(h func(http.ResponseWriter, *http.Request)) http
 
 // RequestGrantingTicket returns a new TGT, if the username and password authentication was successful
 func (c *RestClient) RequestGrantingTicket(username string, password string) (TicketGrantingTicket, error) {
+	return c.RequestGrantingTicketContext(context.Background(), username, password)
+}
+
+// RequestGrantingTicketContext is like RequestGrantingTicket, but canceled when the context is done.
+func (c *RestClient) RequestGrantingTicketContext(ctx context.Context, username string, password string) (TicketGrantingTicket, error) {
 	// request:
 	// POST /cas/v1/tickets HTTP/1.0
 	// username=battags&password=password&additionalParam1=paramvalue
@@ -104,10 +135,12 @@ func (c *RestClient) RequestGrantingTicket(username string, password string) (Ti
 	values.Set("username", username)
 	values.Set("password", password)
 
-	resp, err := c.client.PostForm(endpoint.String(), values)
+	// Not retried, every attempt counts against the lockout policies of the CAS server
+	resp, err := c.postForm(ctx, endpoint.String(), values, nil)
 	if err != nil {
 		return "", err
 	}
+	resp.Body.Close()
 
 	// response:
 	// 201 Created
@@ -127,6 +160,11 @@ func (c *RestClient) RequestGrantingTicket(username string, password string) (Ti
 
 // RequestServiceTicket requests a service ticket with the TGT for the configured service url
 func (c *RestClient) RequestServiceTicket(tgt TicketGrantingTicket) (ServiceTicket, error) {
+	return c.RequestServiceTicketContext(context.Background(), tgt)
+}
+
+// RequestServiceTicketContext is like RequestServiceTicket, but canceled when the context is done.
+func (c *RestClient) RequestServiceTicketContext(ctx context.Context, tgt TicketGrantingTicket) (ServiceTicket, error) {
 	// request:
 	// POST /cas/v1/tickets/{TGT id} HTTP/1.0
 	// service={form encoded parameter for the service url}
@@ -138,10 +176,11 @@ func (c *RestClient) RequestServiceTicket(tgt TicketGrantingTicket) (ServiceTick
 	values := url.Values{}
 	values.Set("service", c.serviceURL.String())
 
-	resp, err := c.client.PostForm(endpoint.String(), values)
+	resp, err := c.postForm(ctx, endpoint.String(), values, c.retry)
 	if err != nil {
 		return "", err
 	}
+	defer resp.Body.Close()
 
 	// response:
 	// 200 OK
@@ -151,8 +190,6 @@ func (c *RestClient) RequestServiceTicket(tgt TicketGrantingTicket) (ServiceTick
 		return "", fmt.Errorf("service ticket endoint returned status code %v", resp.StatusCode)
 	}
 
-	defer resp.Body.Close()
-
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return "", err
@@ -163,26 +200,37 @@ func (c *RestClient) RequestServiceTicket(tgt TicketGrantingTicket) (ServiceTick
 
 // ValidateServiceTicket validates the service ticket and returns an AuthenticationResponse
 func (c *RestClient) ValidateServiceTicket(st ServiceTicket) (*AuthenticationResponse, error) {
-	return c.stValidator.ValidateTicket(c.serviceURL, string(st), c.proxy)
+	return c.ValidateServiceTicketContext(context.Background(), st)
+}
+
+// ValidateServiceTicketContext is like ValidateServiceTicket, but canceled when the context is done.
+func (c *RestClient) ValidateServiceTicketContext(ctx context.Context, st ServiceTicket) (*AuthenticationResponse, error) {
+	return c.stValidator.ValidateTicketContext(ctx, c.serviceURL, string(st), c.proxy)
 }
 
 // Logout destroys the given granting ticket
 func (c *RestClient) Logout(tgt TicketGrantingTicket) error {
+	return c.LogoutContext(context.Background(), tgt)
+}
+
+// LogoutContext is like Logout, but canceled when the context is done.
+func (c *RestClient) LogoutContext(ctx context.Context, tgt TicketGrantingTicket) error {
 	// DELETE /cas/v1/tickets/TGT-fdsjfsdfjkalfewrihfdhfaie HTTP/1.0
 	endpoint, err := c.urlScheme.RestLogout(string(tgt))
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequest("DELETE", endpoint.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", endpoint.String(), nil)
 	if err != nil {
 		return err
 	}
 
-	resp, err := c.client.Do(req)
+	resp, err := sendFailover(c.urlScheme, c.client, req, nil, c.breaker, c.logger)
 	if err != nil {
 		return err
 	}
+	resp.Body.Close()
 
 	if resp.StatusCode != 200 && resp.StatusCode != 204 {
 		return fmt.Errorf("could not destroy granting ticket %v, server returned %v", tgt, resp.StatusCode)
@@ -190,3 +238,16 @@ func (c *RestClient) Logout(tgt TicketGrantingTicket) error {
 
 	return nil
 }
+
+// postForm posts the form values to the url, retrying transient failures when retry is set and failing over
+// between CAS nodes.
+// The caller must close the response body.
+func (c *RestClient) postForm(ctx context.Context, u string, values url.Values, retry *RetryOptions) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", u, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return sendFailover(c.urlScheme, c.client, req, retry, c.breaker, c.logger)
+}
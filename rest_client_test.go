@@ -1,6 +1,8 @@
 package cas
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -186,3 +188,75 @@ func TestLogout(t *testing.T) {
 		t.Errorf("logout should failed for this TGT")
 	}
 }
+
+func TestRestClientContextCanceled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	casURL, _ := url.Parse(server.URL + "/cas/")
+	serviceURL, _ := url.Parse("https://example.com/")
+	restClient := NewRestClient(&RestOptions{
+		CasURL:     casURL,
+		ServiceURL: serviceURL,
+		Client:     server.Client(),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := restClient.RequestGrantingTicketContext(ctx, "tricia", "hitchhiker"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected granting ticket request to be canceled, got %v", err)
+	}
+
+	if _, err := restClient.RequestServiceTicketContext(ctx, "TGT-abc"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected service ticket request to be canceled, got %v", err)
+	}
+
+	if err := restClient.LogoutContext(ctx, "TGT-abc"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected logout to be canceled, got %v", err)
+	}
+
+	if _, err := restClient.ValidateServiceTicketContext(ctx, "ST-abc"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected validation to be canceled, got %v", err)
+	}
+}
+
+func TestRequestGrantingTicketFailover(t *testing.T) {
+	primary := httptest.NewServer(http.NotFoundHandler())
+	primaryURL, _ := url.Parse(primary.URL + "/cas/")
+	primary.Close()
+
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cas/v1/tickets" || r.Method != "POST" || r.FormValue("username") != "tricia" {
+			w.WriteHeader(400)
+			return
+		}
+
+		w.Header().Set("Location", "/cas/v1/tickets/TGT-abc")
+		w.WriteHeader(201)
+	}))
+	defer secondary.Close()
+
+	secondaryURL, _ := url.Parse(secondary.URL + "/cas/")
+	restClient := NewRestClient(&RestOptions{
+		CasURL:       primaryURL,
+		FailoverURLs: []*url.URL{secondaryURL},
+		Client:       secondary.Client(),
+	})
+
+	tgt, err := restClient.RequestGrantingTicket("tricia", "hitchhiker")
+	if err != nil {
+		t.Fatalf("requesting granting ticket failed: %v", err)
+	}
+
+	if tgt != "TGT-abc" {
+		t.Errorf("expected %s but received %v", "TGT-abc", tgt)
+	}
+}
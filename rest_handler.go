@@ -1,6 +1,7 @@
 package cas
 
 import (
+	"context"
 	"log/slog"
 	"net/http"
 )
@@ -26,7 +27,7 @@ func (ch *restClientHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// TODO we should implement a short cache to avoid hitting cas server on every request
 	// the cache could use the authorization header as key and the authenticationResponse as value
 
-	success, err := ch.authenticate(username, password)
+	success, err := ch.authenticate(r.Context(), username, password)
 	if err != nil {
 		ch.c.logger.Warn("rest authentication failed", slog.String("error", err.Error()))
 
@@ -40,16 +41,16 @@ func (ch *restClientHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
-func (ch *restClientHandler) authenticate(username string, password string) (*AuthenticationResponse, error) {
-	tgt, err := ch.c.RequestGrantingTicket(username, password)
+func (ch *restClientHandler) authenticate(ctx context.Context, username string, password string) (*AuthenticationResponse, error) {
+	tgt, err := ch.c.RequestGrantingTicketContext(ctx, username, password)
 	if err != nil {
 		return nil, err
 	}
 
-	st, err := ch.c.RequestServiceTicket(tgt)
+	st, err := ch.c.RequestServiceTicketContext(ctx, tgt)
 	if err != nil {
 		return nil, err
 	}
 
-	return ch.c.ValidateServiceTicket(st)
+	return ch.c.ValidateServiceTicketContext(ctx, st)
 }
@@ -0,0 +1,355 @@
+package cas
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/mattmohan-flipp/cas/v2/urlscheme"
+)
+
+// ErrCircuitOpen is returned without contacting the CAS server while the circuit breaker is open.
+var ErrCircuitOpen = errors.New("cas: circuit breaker open, CAS server unavailable")
+
+// Retry and circuit breaker defaults
+const (
+	defaultRetryAttempts    = 3
+	defaultRetryBackoff     = 100 * time.Millisecond
+	defaultRetryMaxBackoff  = 2 * time.Second
+	defaultFailureThreshold = 5
+	defaultOpenTimeout      = 30 * time.Second
+)
+
+// RetryOptions : configuration of retries of idempotent requests to the CAS server
+//
+// Ticket validation, proxy ticket and REST service ticket requests are retried when the CAS server can not be
+// reached, or answers with a 5xx or 429 status. A CAS server which consumed a ticket before failing answers the
+// retry with INVALID_TICKET, which is not retried.
+type RetryOptions struct {
+	MaxAttempts    int           // Attempts per request including the first, defaults to 3
+	InitialBackoff time.Duration // Delay before the first retry, doubled for every further retry, defaults to 100ms
+	MaxBackoff     time.Duration // Upper bound of the delay between attempts, defaults to 2 seconds
+}
+
+// backoff returns the delay before the retry following the attempt, with equal jitter so that clients
+// retrying together spread out.
+func (o *RetryOptions) backoff(attempt int) time.Duration {
+	initial := o.InitialBackoff
+	if initial <= 0 {
+		initial = defaultRetryBackoff
+	}
+
+	maxBackoff := o.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+
+	d := initial << (attempt - 1)
+	if d <= 0 || d > maxBackoff {
+		d = maxBackoff
+	}
+
+	return d/2 + rand.N(d/2+1)
+}
+
+func (o *RetryOptions) attempts() int {
+	if o == nil {
+		return 1
+	}
+	if o.MaxAttempts <= 0 {
+		return defaultRetryAttempts
+	}
+	return o.MaxAttempts
+}
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets all requests through
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails all requests without contacting the CAS server
+	CircuitOpen
+	// CircuitHalfOpen lets a single trial request through to find out whether the CAS server recovered
+	CircuitHalfOpen
+)
+
+func (cs CircuitState) String() string {
+	switch cs {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return ""
+	}
+}
+
+// CircuitBreakerOptions : CircuitBreaker configuration options
+type CircuitBreakerOptions struct {
+	FailureThreshold int           // Consecutive failed requests which open the circuit, defaults to 5
+	OpenTimeout      time.Duration // How long the circuit stays open before a trial request, defaults to 30 seconds
+	Logger           *slog.Logger  // Logs state changes, defaults to slog.Default()
+}
+
+// CircuitBreaker fails requests to the CAS server fast while it is down.
+//
+// Requests which can not reach the server or are answered with a 5xx or 429 status are failures, any other answer
+// is a success. Once FailureThreshold requests failed in a row the circuit opens and requests fail with
+// ErrCircuitOpen, after OpenTimeout a single trial request decides whether it closes again.
+//
+// A CircuitBreaker can be shared by a Client and a RestClient, its State can be reported by health checks.
+type CircuitBreaker struct {
+	failureThreshold int
+	openTimeout      time.Duration
+	logger           *slog.Logger
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	trial    bool // a trial request is in flight while half-open
+}
+
+// NewCircuitBreaker creates a closed CircuitBreaker.
+func NewCircuitBreaker(options *CircuitBreakerOptions) *CircuitBreaker {
+	threshold := options.FailureThreshold
+	if threshold <= 0 {
+		threshold = defaultFailureThreshold
+	}
+
+	openTimeout := options.OpenTimeout
+	if openTimeout <= 0 {
+		openTimeout = defaultOpenTimeout
+	}
+
+	logger := options.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &CircuitBreaker{
+		failureThreshold: threshold,
+		openTimeout:      openTimeout,
+		logger:           logger,
+	}
+}
+
+// State returns the current state of the circuit.
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.openTimeout {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+// allow determines whether a request may be sent.
+func (b *CircuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.openTimeout {
+			return false
+		}
+		b.setState(CircuitHalfOpen)
+		fallthrough
+	case CircuitHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+	}
+
+	return true
+}
+
+// record updates the circuit with the outcome of an allowed request.
+func (b *CircuitBreaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+	if success {
+		b.failures = 0
+		b.setState(CircuitClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.failureThreshold {
+		b.openedAt = time.Now()
+		b.setState(CircuitOpen)
+	}
+}
+
+// release returns the trial of an allowed request which ended without an outcome.
+func (b *CircuitBreaker) release() {
+	b.mu.Lock()
+	b.trial = false
+	b.mu.Unlock()
+}
+
+// setState changes the state, the caller must hold the lock.
+func (b *CircuitBreaker) setState(state CircuitState) {
+	if b.state == state {
+		return
+	}
+
+	b.logger.Warn("CAS circuit breaker changed state",
+		slog.String("from", b.state.String()),
+		slog.String("to", state.String()))
+	b.state = state
+}
+
+// send performs a request to the CAS server, retrying transient failures of idempotent requests when retry is set
+// and failing fast while the breaker is open. Either may be nil.
+//
+// Every attempt is bounded by the default request timeout if the client has no Timeout. The returned response
+// has been read completely, its body can be consumed after the attempt finished.
+func send(client *http.Client, req *http.Request, retry *RetryOptions, breaker *CircuitBreaker, logger *slog.Logger) (*http.Response, error) {
+	ctx := req.Context()
+	attempts := retry.attempts()
+
+	for attempt := 1; ; attempt++ {
+		if breaker != nil && !breaker.allow() {
+			return nil, ErrCircuitOpen
+		}
+
+		resp, err := sendAttempt(client, req)
+		transient := isTransientFailure(resp, err)
+
+		// Requests abandoned by the caller say nothing about the health of the CAS server
+		if breaker != nil {
+			if ctx.Err() != nil {
+				breaker.release()
+			} else {
+				breaker.record(!transient)
+			}
+		}
+
+		if !transient || attempt >= attempts || ctx.Err() != nil {
+			return resp, err
+		}
+
+		delay := retry.backoff(attempt)
+		logger.Warn("Retrying CAS request",
+			slog.String("url", sanitisedURLString(req.URL)),
+			slog.Int("attempt", attempt),
+			slog.Duration("backoff", delay),
+			slog.Any("error", transientError(resp, err)))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// sendFailover is like send, but when the scheme fails over between CAS nodes a node failing to answer is marked
+// down and the request is sent again to the next healthy node.
+func sendFailover(scheme urlscheme.URLScheme, client *http.Client, req *http.Request, retry *RetryOptions, breaker *CircuitBreaker, logger *slog.Logger) (*http.Response, error) {
+	failover, ok := scheme.(urlscheme.Failover)
+	if !ok {
+		return send(client, req, retry, breaker, logger)
+	}
+
+	for {
+		node, _ := failover.Node(req.URL)
+		resp, err := send(client, req, retry, breaker, logger)
+		if errors.Is(err, ErrCircuitOpen) || req.Context().Err() != nil {
+			return resp, err
+		}
+
+		if !isTransientFailure(resp, err) {
+			failover.MarkUp(req.URL)
+			logger.Info("CAS node served request",
+				slog.String("node", nodeString(node)),
+				slog.String("status", resp.Status))
+			return resp, nil
+		}
+
+		failover.MarkDown(req.URL)
+		next, ok := failover.Rewrite(req.URL)
+		if !ok {
+			return resp, err
+		}
+
+		nextNode, _ := failover.Node(next)
+		logger.Warn("CAS node failed, failing over",
+			slog.String("node", nodeString(node)),
+			slog.String("next", nodeString(nextNode)),
+			slog.Any("error", transientError(resp, err)))
+
+		req = req.Clone(req.Context())
+		req.URL = next
+		req.Host = next.Host
+	}
+}
+
+func nodeString(node *url.URL) string {
+	if node == nil {
+		return ""
+	}
+	return node.String()
+}
+
+// sendAttempt sends the request once and reads the response within the request timeout.
+func sendAttempt(client *http.Client, req *http.Request) (*http.Response, error) {
+	ctx, cancel := requestContext(req.Context(), client)
+	defer cancel()
+
+	r := req.Clone(ctx)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+
+	resp, err := client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+
+	return resp, nil
+}
+
+// isTransientFailure determines whether the CAS server could not be reached or was unable to answer.
+func isTransientFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+}
+
+func transientError(resp *http.Response, err error) any {
+	if err != nil {
+		return err
+	}
+	return resp.Status
+}
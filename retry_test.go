@@ -0,0 +1,177 @@
+package cas
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTicketRetries(t *testing.T) {
+	server := newFlakyCasServer(2, http.StatusServiceUnavailable, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, validateSuccessResponse)
+	})
+	defer server.Close()
+
+	casURL, _ := url.Parse(server.URL)
+	service, _ := url.Parse("http://example.com/")
+
+	validator := NewServiceTicketValidator(ServiceTicketValidatorOptions{
+		Client:          server.Client(),
+		CasURL:          casURL,
+		ProtocolVersion: ProtocolCAS3,
+		Retry:           &RetryOptions{InitialBackoff: time.Millisecond},
+	})
+
+	success, err := validator.ValidateTicket(service, "ST-1", newDisabledProxy())
+	require.NoError(t, err)
+	assert.Equal(t, "enoch.root", success.User)
+	assert.Equal(t, 3, server.Count())
+
+	// Without retries the first failure is returned
+	server.Reset()
+	_, err = newTestValidator(t, server.Server, ProtocolCAS3).ValidateTicket(service, "ST-2", newDisabledProxy())
+	assert.Error(t, err)
+	assert.Equal(t, 1, server.Count())
+}
+
+func TestValidateTicketDoesNotRetryMissingEndpoint(t *testing.T) {
+	server := newFakeCasServer(serveValidation("/serviceValidate"))
+	defer server.Close()
+
+	casURL, _ := url.Parse(server.URL)
+	service, _ := url.Parse("http://example.com/")
+
+	validator := NewServiceTicketValidator(ServiceTicketValidatorOptions{
+		Client: server.Client(),
+		CasURL: casURL,
+		Retry:  &RetryOptions{InitialBackoff: time.Millisecond},
+	})
+
+	_, err := validator.ValidateTicket(service, "ST-1", newDisabledProxy())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/p3/serviceValidate", "/serviceValidate"}, server.Paths())
+}
+
+func TestRestClientRetries(t *testing.T) {
+	server := newFlakyCasServer(1, http.StatusBadGateway, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cas/v1/tickets/TGT-abc" && r.FormValue("service") == "https://hitchhiker.com/heartOfGold" {
+			w.Write([]byte("ST-123"))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	defer server.Close()
+
+	casURL, _ := url.Parse(server.URL + "/cas/")
+	serviceURL, _ := url.Parse("https://hitchhiker.com/heartOfGold")
+
+	restClient := NewRestClient(&RestOptions{
+		CasURL:     casURL,
+		ServiceURL: serviceURL,
+		Client:     server.Client(),
+		Retry:      &RetryOptions{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+	})
+
+	st, err := restClient.RequestServiceTicket(TicketGrantingTicket("TGT-abc"))
+	require.NoError(t, err)
+	assert.Equal(t, ServiceTicket("ST-123"), st)
+	assert.Equal(t, 2, server.Count())
+
+	// Credentials are never retried
+	server.Reset()
+	_, err = restClient.RequestGrantingTicket("enoch.root", "secret")
+	assert.Error(t, err)
+	assert.Equal(t, 1, server.Count())
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	down := atomic.Bool{}
+	down.Store(true)
+	server := newFakeCasServer(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, validateSuccessResponse)
+	})
+	defer server.Close()
+
+	casURL, _ := url.Parse(server.URL)
+	service, _ := url.Parse("http://example.com/")
+
+	breaker := NewCircuitBreaker(&CircuitBreakerOptions{FailureThreshold: 2, OpenTimeout: 50 * time.Millisecond})
+	validator := NewServiceTicketValidator(ServiceTicketValidatorOptions{
+		Client:          server.Client(),
+		CasURL:          casURL,
+		ProtocolVersion: ProtocolCAS3,
+		CircuitBreaker:  breaker,
+	})
+	validate := func() error {
+		_, err := validator.ValidateTicket(service, "ST-1", newDisabledProxy())
+		return err
+	}
+
+	assert.Equal(t, CircuitClosed, breaker.State())
+	assert.Error(t, validate())
+	assert.Equal(t, CircuitClosed, breaker.State())
+	assert.Error(t, validate())
+	assert.Equal(t, CircuitOpen, breaker.State())
+
+	// Fails fast while open
+	assert.ErrorIs(t, validate(), ErrCircuitOpen)
+	assert.Equal(t, 2, server.Count())
+
+	// A failed trial opens the circuit again
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, CircuitHalfOpen, breaker.State())
+	assert.Error(t, validate())
+	assert.Equal(t, CircuitOpen, breaker.State())
+	assert.ErrorIs(t, validate(), ErrCircuitOpen)
+
+	// A successful trial closes it
+	down.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	assert.NoError(t, validate())
+	assert.Equal(t, CircuitClosed, breaker.State())
+	assert.Equal(t, "closed", breaker.State().String())
+}
+
+func TestCircuitBreakerSingleTrial(t *testing.T) {
+	breaker := NewCircuitBreaker(&CircuitBreakerOptions{FailureThreshold: 1, OpenTimeout: time.Millisecond})
+	require.True(t, breaker.allow())
+	breaker.record(false)
+
+	time.Sleep(5 * time.Millisecond)
+	assert.True(t, breaker.allow())
+	assert.False(t, breaker.allow())
+
+	breaker.release()
+	assert.True(t, breaker.allow())
+}
+
+func TestRetryBackoff(t *testing.T) {
+	o := &RetryOptions{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+
+	for i := 0; i < 50; i++ {
+		d := o.backoff(1)
+		assert.GreaterOrEqual(t, d, 50*time.Millisecond)
+		assert.LessOrEqual(t, d, 100*time.Millisecond)
+
+		d = o.backoff(2)
+		assert.GreaterOrEqual(t, d, 100*time.Millisecond)
+		assert.LessOrEqual(t, d, 200*time.Millisecond)
+
+		d = o.backoff(10)
+		assert.GreaterOrEqual(t, d, 150*time.Millisecond)
+		assert.LessOrEqual(t, d, 300*time.Millisecond)
+	}
+
+	assert.Equal(t, 1, (*RetryOptions)(nil).attempts())
+	assert.Equal(t, defaultRetryAttempts, (&RetryOptions{}).attempts())
+}
@@ -0,0 +1,253 @@
+package cas
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+var errNoRoleSource = errors.New("cas: role mapper was not loaded from a file")
+
+// RoleRule grants an application role to users which are a member of any of the groups, or have any of
+// the values of any of the attributes.
+//
+// Groups are compared case insensitively, as LDAP DNs are. Groups and values starting with "^" are regular
+// expressions.
+type RoleRule struct {
+	Role       string              `yaml:"role" json:"role"`
+	Groups     []string            `yaml:"groups" json:"groups"`
+	Attributes map[string][]string `yaml:"attributes" json:"attributes"`
+}
+
+// roleRules is the layout of a rules file.
+type roleRules struct {
+	Rules []RoleRule `yaml:"rules" json:"rules"`
+}
+
+// compiledRoleRule is a RoleRule with its patterns compiled.
+type compiledRoleRule struct {
+	role       string
+	groups     []rolePattern
+	attributes map[string][]rolePattern
+}
+
+type rolePattern struct {
+	re    *regexp.Regexp
+	value string
+}
+
+// RoleMapper maps the groups and attributes of authenticated users to application roles.
+//
+// A RoleMapper can be reloaded while in use, for example when a SIGHUP is received:
+//
+//	roles, err := cas.LoadRoleMapper("/etc/app/roles.yaml")
+//	...
+//	go func() {
+//		for range hup {
+//			if err := roles.Reload(); err != nil {
+//				log.Print(err)
+//			}
+//		}
+//	}()
+type RoleMapper struct {
+	mu    sync.RWMutex
+	rules []compiledRoleRule
+	path  string
+}
+
+// NewRoleMapper creates a RoleMapper with the rules.
+func NewRoleMapper(rules []RoleRule) (*RoleMapper, error) {
+	m := &RoleMapper{}
+	if err := m.Update(rules); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// LoadRoleMapper creates a RoleMapper with the rules of a YAML or JSON file, which Reload reads again.
+//
+// The file lists the rules under a rules key:
+//
+//	rules:
+//	  - role: admin
+//	    groups: ["cn=admins,ou=groups,dc=corp"]
+//	  - role: support
+//	    attributes:
+//	      department: ["^(Support|Helpdesk)$"]
+func LoadRoleMapper(path string) (*RoleMapper, error) {
+	m := &RoleMapper{path: path}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// ParseRoleRules parses the rules of a YAML or JSON rules file.
+func ParseRoleRules(data []byte) ([]RoleRule, error) {
+	// JSON documents are valid YAML
+	var rules roleRules
+	if err := yaml.UnmarshalStrict(data, &rules); err != nil {
+		return nil, fmt.Errorf("cas: role rules: %w", err)
+	}
+
+	return rules.Rules, nil
+}
+
+// Reload reads the rules file again and replaces the rules. The current rules are kept when the file
+// can not be read or contains invalid rules.
+func (m *RoleMapper) Reload() error {
+	if m.path == "" {
+		return errNoRoleSource
+	}
+
+	data, err := os.ReadFile(m.path)
+	if err != nil {
+		return fmt.Errorf("cas: role rules: %w", err)
+	}
+
+	rules, err := ParseRoleRules(data)
+	if err != nil {
+		return err
+	}
+
+	return m.Update(rules)
+}
+
+// Update replaces the rules of the RoleMapper.
+func (m *RoleMapper) Update(rules []RoleRule) error {
+	compiled := make([]compiledRoleRule, 0, len(rules))
+	for i, rule := range rules {
+		if rule.Role == "" {
+			return fmt.Errorf("cas: role rules: rule %d has no role", i)
+		}
+
+		c := compiledRoleRule{role: rule.Role, attributes: make(map[string][]rolePattern, len(rule.Attributes))}
+
+		var err error
+		if c.groups, err = compileRolePatterns(rule.Groups, "(?i)"); err != nil {
+			return fmt.Errorf("cas: role rules: role %s: %w", rule.Role, err)
+		}
+
+		for name, values := range rule.Attributes {
+			if c.attributes[name], err = compileRolePatterns(values, ""); err != nil {
+				return fmt.Errorf("cas: role rules: role %s: %w", rule.Role, err)
+			}
+		}
+
+		compiled = append(compiled, c)
+	}
+
+	m.mu.Lock()
+	m.rules = compiled
+	m.mu.Unlock()
+
+	return nil
+}
+
+// Roles returns the sorted roles of the user.
+func (m *RoleMapper) Roles(a *AuthenticationResponse) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	granted := make(map[string]bool)
+	for _, rule := range m.rules {
+		if !granted[rule.role] && rule.matches(a) {
+			granted[rule.role] = true
+		}
+	}
+
+	roles := make([]string, 0, len(granted))
+	for role := range granted {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+
+	return roles
+}
+
+// RequireRole authorizes users which have any of the roles.
+func (m *RoleMapper) RequireRole(roles ...string) Authorizer {
+	return func(a *AuthenticationResponse) bool {
+		for _, role := range m.Roles(a) {
+			for _, r := range roles {
+				if role == r {
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+}
+
+func (rule *compiledRoleRule) matches(a *AuthenticationResponse) bool {
+	for _, group := range a.MemberOf {
+		for _, p := range rule.groups {
+			if (p.re != nil && p.re.MatchString(group)) || (p.re == nil && strings.EqualFold(p.value, group)) {
+				return true
+			}
+		}
+	}
+
+	for name, patterns := range rule.attributes {
+		for _, value := range a.Attributes[name] {
+			for _, p := range patterns {
+				if (p.re != nil && p.re.MatchString(value)) || (p.re == nil && p.value == value) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// compileRolePatterns compiles the "^" prefixed entries as regular expressions with the flags.
+func compileRolePatterns(values []string, flags string) ([]rolePattern, error) {
+	patterns := make([]rolePattern, 0, len(values))
+	for _, v := range values {
+		if !strings.HasPrefix(v, "^") {
+			patterns = append(patterns, rolePattern{value: v})
+			continue
+		}
+
+		re, err := regexp.Compile(flags + v)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, rolePattern{re: re})
+	}
+
+	return patterns, nil
+}
+
+// Roles returns the roles of the authenticated user, as mapped by the RoleMapper of the Client.
+func Roles(r *http.Request) []string {
+	c := getClient(r)
+	a := getAuthenticationResponse(r)
+	if c == nil || c.roles == nil || a == nil {
+		return nil
+	}
+
+	return c.roles.Roles(a)
+}
+
+// HasRole indicates whether the authenticated user has the role.
+func HasRole(r *http.Request, role string) bool {
+	for _, v := range Roles(r) {
+		if v == role {
+			return true
+		}
+	}
+
+	return false
+}
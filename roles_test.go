@@ -0,0 +1,115 @@
+package cas
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const roleRulesYAML = `rules:
+  - role: admin
+    groups: ["CN=Admins,OU=Groups,DC=corp"]
+  - role: support
+    attributes:
+      department: ["^(Support|Helpdesk)$"]
+  - role: staff
+    groups: ["^cn=[^,]+,ou=groups,dc=corp$"]
+`
+
+const roleRulesJSON = `{"rules": [{"role": "auditor", "attributes": {"mail": ["audit@example.com"]}}]}`
+
+func TestRoleMapper(t *testing.T) {
+	rules, err := ParseRoleRules([]byte(roleRulesYAML))
+	require.NoError(t, err)
+
+	m, err := NewRoleMapper(rules)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		a        *AuthenticationResponse
+		expected []string
+	}{
+		{"none", &AuthenticationResponse{MemberOf: []string{"cn=admins,ou=people,dc=corp"}}, []string{}},
+		{"group", &AuthenticationResponse{MemberOf: []string{"cn=admins,ou=groups,dc=corp"}}, []string{"admin", "staff"}},
+		{"pattern group", &AuthenticationResponse{MemberOf: []string{"CN=Devs,OU=Groups,DC=corp"}}, []string{"staff"}},
+		{"attribute", &AuthenticationResponse{Attributes: UserAttributes{"department": {"Sales", "Helpdesk"}}}, []string{"support"}},
+		{"attribute mismatch", &AuthenticationResponse{Attributes: UserAttributes{"department": {"Helpdesk EMEA"}}}, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, m.Roles(tt.a))
+		})
+	}
+
+	admin := &AuthenticationResponse{MemberOf: []string{"cn=admins,ou=groups,dc=corp"}}
+	assert.True(t, m.RequireRole("auditor", "admin")(admin))
+	assert.False(t, m.RequireRole("support")(admin))
+}
+
+func TestRoleMapperInvalidRules(t *testing.T) {
+	_, err := NewRoleMapper([]RoleRule{{Groups: []string{"admins"}}})
+	assert.Error(t, err)
+
+	_, err = NewRoleMapper([]RoleRule{{Role: "admin", Groups: []string{"^("}}})
+	assert.Error(t, err)
+
+	_, err = ParseRoleRules([]byte("rules:\n  - role: admin\n    group: [admins]\n"))
+	assert.Error(t, err)
+}
+
+func TestRoleMapperReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roles.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(roleRulesYAML), 0o600))
+
+	m, err := LoadRoleMapper(path)
+	require.NoError(t, err)
+
+	a := &AuthenticationResponse{
+		MemberOf:   []string{"cn=admins,ou=groups,dc=corp"},
+		Attributes: UserAttributes{"mail": {"audit@example.com"}},
+	}
+	assert.Equal(t, []string{"admin", "staff"}, m.Roles(a))
+
+	require.NoError(t, os.WriteFile(path, []byte(roleRulesJSON), 0o600))
+	require.NoError(t, m.Reload())
+	assert.Equal(t, []string{"auditor"}, m.Roles(a))
+
+	// Invalid rules keep the current ones
+	require.NoError(t, os.WriteFile(path, []byte(`{"rules": [{"role": ""}]}`), 0o600))
+	assert.Error(t, m.Reload())
+	assert.Equal(t, []string{"auditor"}, m.Roles(a))
+
+	m, err = NewRoleMapper(nil)
+	require.NoError(t, err)
+	assert.Equal(t, errNoRoleSource, m.Reload())
+
+	_, err = LoadRoleMapper(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestRolesHelpers(t *testing.T) {
+	rules, err := ParseRoleRules([]byte(roleRulesYAML))
+	require.NoError(t, err)
+	m, err := NewRoleMapper(rules)
+	require.NoError(t, err)
+
+	u, _ := url.Parse("https://cas.example.com/")
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	setClient(req, NewClient(&Options{URL: u, RoleMapper: m}))
+
+	assert.Nil(t, Roles(req))
+	assert.False(t, HasRole(req, "admin"))
+
+	setAuthenticationResponse(req, &AuthenticationResponse{MemberOf: []string{"cn=admins,ou=groups,dc=corp"}})
+	assert.Equal(t, []string{"admin", "staff"}, Roles(req))
+	assert.True(t, HasRole(req, "admin"))
+	assert.False(t, HasRole(req, "support"))
+}
@@ -0,0 +1,234 @@
+package cas
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattmohan-flipp/cas/v2/urlscheme"
+)
+
+// samlClockSkew is the tolerance applied to the assertion validity window
+const samlClockSkew = time.Minute
+
+const samlRequestTemplate = `<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/">` +
+	`<SOAP-ENV:Header/>` +
+	`<SOAP-ENV:Body>` +
+	`<samlp:Request xmlns:samlp="urn:oasis:names:tc:SAML:1.0:protocol" MajorVersion="1" MinorVersion="1" RequestID="%s" IssueInstant="%s">` +
+	`<samlp:AssertionArtifact>%s</samlp:AssertionArtifact>` +
+	`</samlp:Request>` +
+	`</SOAP-ENV:Body>` +
+	`</SOAP-ENV:Envelope>`
+
+// Represents the SOAP wrapped SAML 1.1 response returned by samlValidate
+type samlEnvelope struct {
+	XMLName  xml.Name     `xml:"Envelope"`
+	Response samlResponse `xml:"Body>Response"`
+}
+
+type samlResponse struct {
+	Status    samlStatus     `xml:"Status"`
+	Assertion *samlAssertion `xml:"Assertion"`
+}
+
+type samlStatus struct {
+	StatusCode    samlStatusCode `xml:"StatusCode"`
+	StatusMessage string         `xml:"StatusMessage"`
+}
+
+type samlStatusCode struct {
+	Value string `xml:"Value,attr"`
+}
+
+type samlAssertion struct {
+	Conditions              samlConditions               `xml:"Conditions"`
+	AttributeStatement      *samlAttributeStatement      `xml:"AttributeStatement"`
+	AuthenticationStatement *samlAuthenticationStatement `xml:"AuthenticationStatement"`
+}
+
+type samlConditions struct {
+	NotBefore    time.Time `xml:"NotBefore,attr"`
+	NotOnOrAfter time.Time `xml:"NotOnOrAfter,attr"`
+	Audiences    []string  `xml:"AudienceRestrictionCondition>Audience"`
+}
+
+type samlAttributeStatement struct {
+	NameIdentifier string           `xml:"Subject>NameIdentifier"`
+	Attributes     []*samlAttribute `xml:"Attribute"`
+}
+
+type samlAttribute struct {
+	Name   string   `xml:"AttributeName,attr"`
+	Values []string `xml:"AttributeValue"`
+}
+
+type samlAuthenticationStatement struct {
+	AuthenticationInstant time.Time `xml:"AuthenticationInstant,attr"`
+	NameIdentifier        string    `xml:"Subject>NameIdentifier"`
+}
+
+// SamlValidateUrl creates the validation url for the saml 1.1 protocol.
+func (validator *ServiceTicketValidator) SamlValidateUrl(serviceURL *url.URL) (string, error) {
+	return validator.samlValidateUrl(serviceURL, false)
+}
+
+// samlValidateUrl creates the validation url for the saml 1.1 protocol, with renew=true when renew is set.
+func (validator *ServiceTicketValidator) samlValidateUrl(serviceURL *url.URL, renew bool) (string, error) {
+	u, err := urlscheme.SamlValidate(validator.urlScheme)
+	if err != nil {
+		return "", err
+	}
+
+	q := u.Query()
+	q.Add("TARGET", sanitisedURLString(serviceURL))
+	if renew {
+		q.Add("renew", "true")
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// validateTicketSaml validates the service ticket using the samlValidate endpoint.
+//
+// The renew flag is forwarded as the renew=true url parameter, the SAML request itself has no equivalent.
+func (validator *ServiceTicketValidator) validateTicketSaml(ctx context.Context, serviceURL *url.URL, ticket string, renew bool) (*AuthenticationResponse, error) {
+	u, err := validator.samlValidateUrl(serviceURL, renew)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := xmlSamlRequest(ticket)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	r.Header.Set("Content-Type", "text/xml")
+	r.Header.Set("SOAPAction", "http://www.oasis-open.org/committees/security")
+
+	data, err := validator.do(r)
+	if err != nil {
+		return nil, err
+	}
+
+	validator.logger.Debug("Received authentication response", slog.String("response", string(data)))
+
+	success, err := parseSamlResponse(data, sanitisedURLString(serviceURL), time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	validator.logger.Debug("Parsed ServiceResponse", slog.Any("response", success))
+
+	return success, nil
+}
+
+// xmlSamlRequest builds the SOAP wrapped SAML 1.1 request for a ticket.
+func xmlSamlRequest(ticket string) ([]byte, error) {
+	var artifact bytes.Buffer
+	if err := xml.EscapeText(&artifact, []byte(ticket)); err != nil {
+		return nil, err
+	}
+
+	id := "_" + newLogoutRequestID()
+	instant := time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+
+	return []byte(fmt.Sprintf(samlRequestTemplate, id, instant, artifact.String())), nil
+}
+
+// parseSamlResponse returns a successful response or an error.
+//
+// The assertion must be valid at the time now and be restricted to the service.
+func parseSamlResponse(data []byte, service string, now time.Time) (*AuthenticationResponse, error) {
+	var e samlEnvelope
+	if err := xml.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+
+	status := e.Response.Status
+	if !strings.HasSuffix(status.StatusCode.Value, "Success") {
+		msg := strings.TrimSpace(status.StatusMessage)
+		if msg == "" {
+			msg = status.StatusCode.Value
+		}
+		return nil, &AuthenticationError{Code: INVALID_TICKET, Message: msg}
+	}
+
+	a := e.Response.Assertion
+	if a == nil {
+		return nil, &AuthenticationError{Code: INVALID_TICKET, Message: "saml response contains no assertion"}
+	}
+
+	if c := a.Conditions; !c.NotBefore.IsZero() && now.Add(samlClockSkew).Before(c.NotBefore) {
+		return nil, &AuthenticationError{Code: INVALID_TICKET, Message: fmt.Sprintf("assertion is not valid before %v", c.NotBefore)}
+	}
+
+	if c := a.Conditions; !c.NotOnOrAfter.IsZero() && !now.Add(-samlClockSkew).Before(c.NotOnOrAfter) {
+		return nil, &AuthenticationError{Code: INVALID_TICKET, Message: fmt.Sprintf("assertion expired at %v", c.NotOnOrAfter)}
+	}
+
+	if !samlAudienceMatches(a.Conditions.Audiences, service) {
+		return nil, &AuthenticationError{Code: INVALID_SERVICE, Message: fmt.Sprintf("assertion is not intended for service %s", service)}
+	}
+
+	r := &AuthenticationResponse{
+		Attributes: make(UserAttributes),
+	}
+
+	if s := a.AuthenticationStatement; s != nil {
+		r.User = strings.TrimSpace(s.NameIdentifier)
+		r.AuthenticationDate = s.AuthenticationInstant
+	}
+
+	if s := a.AttributeStatement; s != nil {
+		if r.User == "" {
+			r.User = strings.TrimSpace(s.NameIdentifier)
+		}
+
+		for _, attr := range s.Attributes {
+			for _, v := range attr.Values {
+				v = strings.TrimSpace(v)
+
+				switch attr.Name {
+				case "isFromNewLogin":
+					r.IsNewLogin, _ = strconv.ParseBool(v)
+				case "longTermAuthenticationRequestTokenUsed":
+					r.IsRememberedLogin, _ = strconv.ParseBool(v)
+				case "memberOf":
+					r.MemberOf = append(r.MemberOf, v)
+				default:
+					r.Attributes.Add(attr.Name, v)
+				}
+			}
+		}
+	}
+
+	if r.User == "" {
+		return nil, &AuthenticationError{Code: INVALID_TICKET, Message: "assertion contains no subject"}
+	}
+
+	return r, nil
+}
+
+// samlAudienceMatches determines whether the service is one of the assertion audiences.
+func samlAudienceMatches(audiences []string, service string) bool {
+	for _, audience := range audiences {
+		if strings.TrimSpace(audience) == service {
+			return true
+		}
+	}
+
+	return false
+}
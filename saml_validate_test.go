@@ -0,0 +1,160 @@
+package cas
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const samlSuccessResponse = `<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/">
+  <SOAP-ENV:Header />
+  <SOAP-ENV:Body>
+    <Response xmlns="urn:oasis:names:tc:SAML:1.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:1.0:assertion"
+      IssueInstant="2008-12-10T14:12:14.817Z" MajorVersion="1" MinorVersion="1"
+      Recipient="%[3]s" ResponseID="_5c94b5431c540365e5a70b2874b75996">
+      <Status>
+        <StatusCode Value="samlp:Success"></StatusCode>
+      </Status>
+      <Assertion xmlns="urn:oasis:names:tc:SAML:1.0:assertion" AssertionID="_e5c23ff7a3889e12fa01802a47331653"
+        IssueInstant="2008-12-10T14:12:14.817Z" Issuer="localhost" MajorVersion="1" MinorVersion="1">
+        <Conditions NotBefore="%[1]s" NotOnOrAfter="%[2]s">
+          <AudienceRestrictionCondition>
+            <Audience>%[3]s</Audience>
+          </AudienceRestrictionCondition>
+        </Conditions>
+        <AttributeStatement>
+          <Subject>
+            <NameIdentifier>johnq</NameIdentifier>
+          </Subject>
+          <Attribute AttributeName="uid" AttributeNamespace="http://www.ja-sig.org/products/cas/">
+            <AttributeValue>12345</AttributeValue>
+          </Attribute>
+          <Attribute AttributeName="groupMembership" AttributeNamespace="http://www.ja-sig.org/products/cas/">
+            <AttributeValue>staff</AttributeValue>
+            <AttributeValue>faculty</AttributeValue>
+          </Attribute>
+          <Attribute AttributeName="isFromNewLogin" AttributeNamespace="http://www.ja-sig.org/products/cas/">
+            <AttributeValue>true</AttributeValue>
+          </Attribute>
+        </AttributeStatement>
+        <AuthenticationStatement AuthenticationInstant="2008-12-10T14:12:14.741Z"
+          AuthenticationMethod="urn:oasis:names:tc:SAML:1.0:am:password">
+          <Subject>
+            <NameIdentifier>johnq</NameIdentifier>
+          </Subject>
+        </AuthenticationStatement>
+      </Assertion>
+    </Response>
+  </SOAP-ENV:Body>
+</SOAP-ENV:Envelope>`
+
+const samlFailureResponse = `<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/">
+  <SOAP-ENV:Header />
+  <SOAP-ENV:Body>
+    <Response xmlns="urn:oasis:names:tc:SAML:1.0:protocol" IssueInstant="2008-12-10T14:12:14.817Z"
+      MajorVersion="1" MinorVersion="1" ResponseID="_5c94b5431c540365e5a70b2874b75996">
+      <Status>
+        <StatusCode Value="samlp:Responder"></StatusCode>
+        <StatusMessage>Ticket ST-1 not recognized</StatusMessage>
+      </Status>
+    </Response>
+  </SOAP-ENV:Body>
+</SOAP-ENV:Envelope>`
+
+func samlResponseFor(notBefore, notOnOrAfter time.Time, audience string) []byte {
+	return []byte(fmt.Sprintf(samlSuccessResponse, notBefore.Format(time.RFC3339), notOnOrAfter.Format(time.RFC3339), audience))
+}
+
+func TestParseSamlResponse(t *testing.T) {
+	now := time.Now()
+	data := samlResponseFor(now.Add(-time.Second), now.Add(30*time.Second), "https://service.example.com/")
+
+	r, err := parseSamlResponse(data, "https://service.example.com/", now)
+	require.NoError(t, err)
+
+	assert.Equal(t, "johnq", r.User)
+	assert.True(t, r.IsNewLogin)
+	assert.Equal(t, time.Date(2008, 12, 10, 14, 12, 14, 741000000, time.UTC), r.AuthenticationDate)
+	assert.Equal(t, UserAttributes{
+		"uid":             {"12345"},
+		"groupMembership": {"staff", "faculty"},
+	}, r.Attributes)
+}
+
+func TestParseSamlResponseRejectsInvalidAssertions(t *testing.T) {
+	now := time.Now()
+	service := "https://service.example.com/"
+
+	tests := []struct {
+		name string
+		data []byte
+		code string
+	}{
+		{"expired", samlResponseFor(now.Add(-time.Hour), now.Add(-10*time.Minute), service), INVALID_TICKET},
+		{"not yet valid", samlResponseFor(now.Add(10*time.Minute), now.Add(time.Hour), service), INVALID_TICKET},
+		{"wrong audience", samlResponseFor(now.Add(-time.Second), now.Add(30*time.Second), "https://other.example.com/"), INVALID_SERVICE},
+		{"failure status", []byte(samlFailureResponse), INVALID_TICKET},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := parseSamlResponse(tt.data, service, now)
+			assert.Nil(t, r)
+			require.Error(t, err)
+
+			authErr, ok := err.(*AuthenticationError)
+			require.True(t, ok)
+			assert.Equal(t, tt.code, authErr.Code)
+		})
+	}
+}
+
+func TestValidateTicketSaml(t *testing.T) {
+	service, _ := url.Parse("http://example.com/")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/samlValidate" || r.Method != "POST" {
+			http.NotFound(w, r)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		ticket := "ST-1"
+		if r.URL.Query().Get("renew") == "true" {
+			ticket = "ST-renewed"
+		}
+		if r.URL.Query().Get("TARGET") != service.String() || !strings.Contains(string(body), "<samlp:AssertionArtifact>"+ticket+"</samlp:AssertionArtifact>") {
+			fmt.Fprint(w, samlFailureResponse)
+			return
+		}
+
+		now := time.Now()
+		w.Write(samlResponseFor(now.Add(-time.Second), now.Add(30*time.Second), r.URL.Query().Get("TARGET")))
+	}))
+	defer server.Close()
+
+	validator := newTestValidator(t, server, ProtocolSAML11)
+
+	success, err := validator.ValidateTicket(service, "ST-1", newDisabledProxy())
+	require.NoError(t, err)
+	assert.Equal(t, "johnq", success.User)
+
+	_, err = validator.ValidateTicket(service, "ST-2", newDisabledProxy())
+	require.Error(t, err)
+
+	// renew is forwarded to the samlValidate endpoint
+	_, err = validator.ValidateTicket(service, "ST-renewed", newDisabledProxy())
+	require.Error(t, err)
+
+	success, err = validator.ValidateRenewedTicket(service, "ST-renewed", newDisabledProxy())
+	require.NoError(t, err)
+	assert.Equal(t, "johnq", success.User)
+}
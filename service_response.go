@@ -41,14 +41,15 @@ func (e AuthenticationError) Error() string {
 
 // AuthenticationResponse captures authenticated user information
 type AuthenticationResponse struct {
-	User                string         // Users login name
-	ProxyGrantingTicket string         // Proxy Granting Ticket
-	Proxies             []string       // List of proxies
-	AuthenticationDate  time.Time      // Time at which authentication was performed
-	IsNewLogin          bool           // Whether new authentication was used to grant the service ticket
-	IsRememberedLogin   bool           // Whether a long term token was used to grant the service ticket
-	MemberOf            []string       // List of groups which the user is a member of
-	Attributes          UserAttributes // Additional information about the user
+	User                  string         // Users login name
+	ProxyGrantingTicket   string         // Proxy Granting Ticket IOU
+	ProxyGrantingTicketID string         // Proxy Granting Ticket delivered to the proxy callback for the IOU
+	Proxies               []string       // List of proxies
+	AuthenticationDate    time.Time      // Time at which authentication was performed
+	IsNewLogin            bool           // Whether new authentication was used to grant the service ticket
+	IsRememberedLogin     bool           // Whether a long term token was used to grant the service ticket
+	MemberOf              []string       // List of groups which the user is a member of
+	Attributes            UserAttributes // Additional information about the user
 }
 
 // UserAttributes represents additional data about the user
@@ -58,7 +59,7 @@ type UserAttributes map[string][]string
 //
 // Attributes are stored in arrays. Get will only return the first element.
 func (a UserAttributes) Get(name string) string {
-	if v, ok := a[name]; ok {
+	if v, ok := a[name]; ok && len(v) > 0 {
 		return v[0]
 	}
 
@@ -71,7 +72,13 @@ func (a UserAttributes) Add(name, value string) {
 }
 
 // ParseServiceResponse returns a successful response or an error
+//
+// Both XML and CAS 3 JSON service responses are understood.
 func ParseServiceResponse(data []byte) (*AuthenticationResponse, error) {
+	if isJSONServiceResponse(data) {
+		return ParseServiceResponseJSON(data)
+	}
+
 	var x xmlServiceResponse
 
 	if err := xml.Unmarshal(data, &x); err != nil {
@@ -79,11 +86,15 @@ func ParseServiceResponse(data []byte) (*AuthenticationResponse, error) {
 	}
 
 	if x.Failure != nil {
-		msg := strings.TrimSpace(x.Failure.Message)
+		msg := strings.TrimSpace(xmlInnerText(x.Failure.Message))
 		err := &AuthenticationError{Code: x.Failure.Code, Message: msg}
 		return nil, err
 	}
 
+	if x.Success == nil {
+		return nil, &AuthenticationError{Code: INTERNAL_ERROR, Message: "service response contains no authentication result"}
+	}
+
 	r := &AuthenticationResponse{
 		User:                x.Success.User,
 		ProxyGrantingTicket: x.Success.ProxyGrantingTicket,
@@ -106,7 +117,7 @@ func ParseServiceResponse(data []byte) (*AuthenticationResponse, error) {
 					continue
 				}
 
-				r.Attributes.Add(ua.Name, strings.TrimSpace(ua.Value))
+				r.Attributes.Add(ua.Name, strings.TrimSpace(xmlInnerText(ua.Value)))
 			}
 
 			for _, ea := range a.UserAttributes.AnyAttributes {
@@ -0,0 +1,416 @@
+package cas
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// AttributesStyle selects how user attributes are rendered in XML service responses.
+type AttributesStyle int
+
+const (
+	// CasThreeZeroNamedAttributesStyle renders <userAttributes><attribute name="mail">...</attribute></userAttributes>
+	CasThreeZeroNamedAttributesStyle AttributesStyle = iota
+	// CasThreeZeroAnyAttributesStyle renders <userAttributes><mail>...</mail></userAttributes>
+	CasThreeZeroAnyAttributesStyle
+	// RubyCasAttributesStyle renders attributes as children of <authenticationSuccess>, multiple values as YAML lists
+	RubyCasAttributesStyle
+)
+
+func (as AttributesStyle) String() string {
+	switch as {
+	case CasThreeZeroNamedAttributesStyle:
+		return "CasThreeZeroNamedAttributesStyle"
+	case CasThreeZeroAnyAttributesStyle:
+		return "CasThreeZeroAnyAttributesStyle"
+	case RubyCasAttributesStyle:
+		return "RubyCasAttributesStyle"
+	default:
+		return ""
+	}
+}
+
+var (
+	// errReservedAttribute is returned when a user attribute would replace a protocol element or attribute.
+	errReservedAttribute = errors.New("cas: user attribute uses a reserved protocol attribute name")
+	// errInvalidAttributeName is returned when a user attribute rendered as an element is not named by an XML NCName.
+	errInvalidAttributeName = errors.New("cas: user attribute name is not a valid xml element name")
+)
+
+// Names user attributes must not use, as they share their parent with protocol elements or attributes.
+var (
+	rubyCasReservedAttributes = map[string]bool{"user": true, "proxyGrantingTicket": true, "proxies": true, "attributes": true}
+	anyReservedAttributes     = map[string]bool{"attribute": true}
+	jsonReservedAttributes    = map[string]bool{
+		"authenticationDate":                     true,
+		"longTermAuthenticationRequestTokenUsed": true,
+		"isFromNewLogin":                         true,
+		"memberOf":                               true,
+	}
+)
+
+// ServiceResponseWriter renders CAS service responses, for use by servers, fakes and bridges.
+//
+// Responses written by a ServiceResponseWriter can be read by ParseServiceResponse and ParseProxyResponse.
+type ServiceResponseWriter struct {
+	Format          ResponseFormat  // XML or JSON, defaults to ResponseFormatXML
+	AttributesStyle AttributesStyle // Layout of XML user attributes, defaults to CasThreeZeroNamedAttributesStyle
+	Indent          int             // Number of spaces to indent nested elements with, 0 disables indentation
+}
+
+// ContentType returns the media type of the rendered responses.
+func (w *ServiceResponseWriter) ContentType() string {
+	if w.Format == ResponseFormatJSON {
+		return "application/json; charset=utf-8"
+	}
+
+	return "application/xml; charset=utf-8"
+}
+
+// MarshalSuccess renders an authenticationSuccess response.
+func (w *ServiceResponseWriter) MarshalSuccess(r *AuthenticationResponse) ([]byte, error) {
+	if w.Format == ResponseFormatJSON {
+		success, err := jsonSuccess(r)
+		if err != nil {
+			return nil, err
+		}
+
+		return w.marshalJSON(&jsonServiceResponse{ServiceResponse: jsonServiceResponseBody{Success: success}})
+	}
+
+	success, err := w.xmlSuccess(r)
+	if err != nil {
+		return nil, err
+	}
+
+	xsr := &xmlServiceResponse{Success: success}
+	return xsr.marshalXML(w.Indent)
+}
+
+// MarshalFailure renders an authenticationFailure response.
+func (w *ServiceResponseWriter) MarshalFailure(code, message string) ([]byte, error) {
+	if w.Format == ResponseFormatJSON {
+		failure := &jsonAuthenticationFailure{Code: code, Description: message}
+		return w.marshalJSON(&jsonServiceResponse{ServiceResponse: jsonServiceResponseBody{Failure: failure}})
+	}
+
+	msg, err := escapeXML(message)
+	if err != nil {
+		return nil, err
+	}
+
+	return failureServiceResponse(code, msg).marshalXML(w.Indent)
+}
+
+// MarshalProxySuccess renders a proxySuccess response.
+func (w *ServiceResponseWriter) MarshalProxySuccess(proxyTicket string) ([]byte, error) {
+	if w.Format == ResponseFormatJSON {
+		success := &jsonProxySuccess{ProxyTicket: proxyTicket}
+		return w.marshalJSON(&jsonServiceResponse{ServiceResponse: jsonServiceResponseBody{ProxySuccess: success}})
+	}
+
+	xsr := &xmlServiceResponse{ProxySuccess: &xmlProxySuccess{ProxyTicket: proxyTicket}}
+	return xsr.marshalXML(w.Indent)
+}
+
+// MarshalProxyFailure renders a proxyFailure response.
+func (w *ServiceResponseWriter) MarshalProxyFailure(code, message string) ([]byte, error) {
+	if w.Format == ResponseFormatJSON {
+		failure := &jsonAuthenticationFailure{Code: code, Description: message}
+		return w.marshalJSON(&jsonServiceResponse{ServiceResponse: jsonServiceResponseBody{ProxyFailure: failure}})
+	}
+
+	msg, err := escapeXML(message)
+	if err != nil {
+		return nil, err
+	}
+
+	xsr := &xmlServiceResponse{ProxyFailure: &xmlProxyFailure{Code: code, Message: msg}}
+	return xsr.marshalXML(w.Indent)
+}
+
+// WriteSuccess writes an authenticationSuccess response.
+func (w *ServiceResponseWriter) WriteSuccess(rw http.ResponseWriter, r *AuthenticationResponse) error {
+	data, err := w.MarshalSuccess(r)
+	return w.write(rw, data, err)
+}
+
+// WriteFailure writes an authenticationFailure response.
+func (w *ServiceResponseWriter) WriteFailure(rw http.ResponseWriter, code, message string) error {
+	data, err := w.MarshalFailure(code, message)
+	return w.write(rw, data, err)
+}
+
+// WriteProxySuccess writes a proxySuccess response.
+func (w *ServiceResponseWriter) WriteProxySuccess(rw http.ResponseWriter, proxyTicket string) error {
+	data, err := w.MarshalProxySuccess(proxyTicket)
+	return w.write(rw, data, err)
+}
+
+// WriteProxyFailure writes a proxyFailure response.
+func (w *ServiceResponseWriter) WriteProxyFailure(rw http.ResponseWriter, code, message string) error {
+	data, err := w.MarshalProxyFailure(code, message)
+	return w.write(rw, data, err)
+}
+
+func (w *ServiceResponseWriter) write(rw http.ResponseWriter, data []byte, err error) error {
+	if err != nil {
+		http.Error(rw, "Internal Server Error", http.StatusInternalServerError)
+		return err
+	}
+
+	rw.Header().Set("Content-Type", w.ContentType())
+	_, err = rw.Write(data)
+	return err
+}
+
+func (w *ServiceResponseWriter) marshalJSON(v interface{}) ([]byte, error) {
+	if w.Indent == 0 {
+		return json.Marshal(v)
+	}
+
+	return json.MarshalIndent(v, "", strings.Repeat(" ", w.Indent))
+}
+
+// xmlSuccess converts the AuthenticationResponse into the XML structure of the configured attributes style.
+func (w *ServiceResponseWriter) xmlSuccess(r *AuthenticationResponse) (*xmlAuthenticationSuccess, error) {
+	success := successServiceResponse(r.User, r.ProxyGrantingTicket).Success
+
+	if len(r.Proxies) > 0 {
+		success.Proxies = &xmlProxies{Proxies: r.Proxies}
+	}
+
+	names := sortedAttributeNames(r.Attributes)
+	if w.AttributesStyle == RubyCasAttributesStyle {
+		for _, name := range names {
+			if err := checkElementName(name, rubyCasReservedAttributes); err != nil {
+				return nil, err
+			}
+
+			value := r.Attributes[name][0]
+			if len(r.Attributes[name]) > 1 {
+				list, err := rubyCasList(r.Attributes[name])
+				if err != nil {
+					return nil, err
+				}
+				value = list
+			}
+
+			success.ExtraAttributes = append(success.ExtraAttributes, &xmlAnyAttribute{XMLName: xml.Name{Local: name}, Value: value})
+		}
+	}
+
+	if !hasResponseAttributes(r) {
+		return success, nil
+	}
+
+	attributes := &xmlAttributes{
+		AuthenticationDate:                     r.AuthenticationDate,
+		LongTermAuthenticationRequestTokenUsed: r.IsRememberedLogin,
+		IsFromNewLogin:                         r.IsNewLogin,
+		MemberOf:                               r.MemberOf,
+	}
+
+	if w.AttributesStyle != RubyCasAttributesStyle && len(names) > 0 {
+		attributes.UserAttributes = &xmlUserAttributes{}
+
+		for _, name := range names {
+			if w.AttributesStyle == CasThreeZeroAnyAttributesStyle {
+				if err := checkElementName(name, anyReservedAttributes); err != nil {
+					return nil, err
+				}
+			}
+
+			for _, value := range r.Attributes[name] {
+				switch w.AttributesStyle {
+				case CasThreeZeroAnyAttributesStyle:
+					attributes.UserAttributes.AnyAttributes = append(attributes.UserAttributes.AnyAttributes,
+						&xmlAnyAttribute{XMLName: xml.Name{Local: name}, Value: value})
+				default:
+					escaped, err := escapeXML(value)
+					if err != nil {
+						return nil, err
+					}
+
+					attributes.UserAttributes.Attributes = append(attributes.UserAttributes.Attributes,
+						&xmlNamedAttribute{Name: name, Value: escaped})
+				}
+			}
+		}
+	}
+
+	success.Attributes = attributes
+	return success, nil
+}
+
+// jsonSuccess converts the AuthenticationResponse into the CAS 3 JSON structure.
+//
+// User attributes share the attributes object with the protocol attributes, so a user attribute named like a protocol
+// attribute is rejected rather than overwriting it.
+func jsonSuccess(r *AuthenticationResponse) (*jsonAuthenticationSuccess, error) {
+	success := &jsonAuthenticationSuccess{
+		User:                r.User,
+		ProxyGrantingTicket: r.ProxyGrantingTicket,
+		Proxies:             r.Proxies,
+	}
+
+	if !hasResponseAttributes(r) {
+		return success, nil
+	}
+
+	values := map[string]interface{}{
+		"longTermAuthenticationRequestTokenUsed": []bool{r.IsRememberedLogin},
+		"isFromNewLogin":                         []bool{r.IsNewLogin},
+	}
+
+	if !r.AuthenticationDate.IsZero() {
+		values["authenticationDate"] = []string{r.AuthenticationDate.UTC().Format(time.RFC3339Nano)}
+	}
+
+	if len(r.MemberOf) > 0 {
+		values["memberOf"] = r.MemberOf
+	}
+
+	for name, v := range r.Attributes {
+		if jsonReservedAttributes[name] {
+			return nil, fmt.Errorf("%w: %s", errReservedAttribute, name)
+		}
+		values[name] = v
+	}
+
+	success.Attributes = make(map[string]json.RawMessage, len(values))
+	for name, v := range values {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+
+		success.Attributes[name] = raw
+	}
+
+	return success, nil
+}
+
+// checkElementName determines whether a user attribute can be rendered as an element named after it.
+func checkElementName(name string, reserved map[string]bool) error {
+	if reserved[name] {
+		return fmt.Errorf("%w: %s", errReservedAttribute, name)
+	}
+
+	if !isNCName(name) {
+		return fmt.Errorf("%w: %q", errInvalidAttributeName, name)
+	}
+
+	return nil
+}
+
+// isNCName determines whether name is an XML name without a namespace prefix.
+func isNCName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i, c := range name {
+		switch {
+		case unicode.IsLetter(c) || c == '_':
+		case i > 0 && (unicode.IsDigit(c) || c == '-' || c == '.' || unicode.Is(unicode.Mn, c) || unicode.Is(unicode.Mc, c)):
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasResponseAttributes determines whether the response carries anything besides the user and tickets.
+func hasResponseAttributes(r *AuthenticationResponse) bool {
+	return !r.AuthenticationDate.IsZero() || r.IsNewLogin || r.IsRememberedLogin ||
+		len(r.MemberOf) > 0 || len(r.Attributes) > 0
+}
+
+func sortedAttributeNames(attributes UserAttributes) []string {
+	names := make([]string, 0, len(attributes))
+	for name, values := range attributes {
+		if len(values) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// rubyCasList renders multiple attribute values as the YAML list used by RubyCAS.
+func rubyCasList(values []string) (string, error) {
+	var b strings.Builder
+	b.WriteString("---")
+
+	for _, v := range values {
+		// JSON strings are valid double quoted YAML scalars
+		quoted, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+
+		b.WriteString("\n- ")
+		b.Write(quoted)
+	}
+
+	return b.String(), nil
+}
+
+// escapeXML escapes text for use in fields marshalled as inner xml.
+func escapeXML(s string) (string, error) {
+	var b bytes.Buffer
+	if err := xml.EscapeText(&b, []byte(s)); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// ParseProxyResponse returns the proxy ticket of a proxySuccess response, or an error.
+//
+// A proxyFailure response is returned as an *AuthenticationError carrying the failure code.
+// Both XML and CAS 3 JSON responses are understood.
+func ParseProxyResponse(data []byte) (string, error) {
+	if isJSONServiceResponse(data) {
+		var x jsonServiceResponse
+		if err := json.Unmarshal(data, &x); err != nil {
+			return "", err
+		}
+
+		if f := x.ServiceResponse.ProxyFailure; f != nil {
+			return "", &AuthenticationError{Code: f.Code, Message: strings.TrimSpace(f.Description)}
+		}
+
+		if s := x.ServiceResponse.ProxySuccess; s != nil && s.ProxyTicket != "" {
+			return s.ProxyTicket, nil
+		}
+
+		return "", &AuthenticationError{Code: INTERNAL_ERROR, Message: "proxy response contains no proxy ticket"}
+	}
+
+	var x xmlServiceResponse
+	if err := xml.Unmarshal(data, &x); err != nil {
+		return "", err
+	}
+
+	if f := x.ProxyFailure; f != nil {
+		return "", &AuthenticationError{Code: f.Code, Message: strings.TrimSpace(xmlInnerText(f.Message))}
+	}
+
+	if s := x.ProxySuccess; s != nil && strings.TrimSpace(s.ProxyTicket) != "" {
+		return strings.TrimSpace(s.ProxyTicket), nil
+	}
+
+	return "", &AuthenticationError{Code: INTERNAL_ERROR, Message: "proxy response contains no proxy ticket"}
+}
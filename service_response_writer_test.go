@@ -0,0 +1,186 @@
+package cas
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fullAuthenticationResponse() *AuthenticationResponse {
+	return &AuthenticationResponse{
+		User:                "enoch.root",
+		ProxyGrantingTicket: "PGTIOU-84678-8a9d3r389439",
+		Proxies:             []string{"https://proxy2.example.com/pgtUrl", "https://proxy1.example.com/pgtUrl"},
+		AuthenticationDate:  time.Date(2015, 2, 10, 14, 28, 42, 0, time.UTC),
+		IsNewLogin:          true,
+		IsRememberedLogin:   true,
+		MemberOf:            []string{"cn=staff,ou=groups,dc=example,dc=com", "faculty"},
+		Attributes: UserAttributes{
+			"mail":      {"enoch@example.com"},
+			"nicknames": {"Enoch & friends", "a: b", "<root>"},
+		},
+	}
+}
+
+func TestServiceResponseWriterRoundTrip(t *testing.T) {
+	writers := []*ServiceResponseWriter{
+		{AttributesStyle: CasThreeZeroNamedAttributesStyle},
+		{AttributesStyle: CasThreeZeroAnyAttributesStyle},
+		{AttributesStyle: RubyCasAttributesStyle, Indent: 2},
+		{Format: ResponseFormatJSON},
+		{Format: ResponseFormatJSON, Indent: 2},
+	}
+
+	for _, w := range writers {
+		t.Run(w.Format.String()+"/"+w.AttributesStyle.String(), func(t *testing.T) {
+			expected := fullAuthenticationResponse()
+
+			data, err := w.MarshalSuccess(expected)
+			require.NoError(t, err)
+
+			r, err := ParseServiceResponse(data)
+			require.NoError(t, err, string(data))
+			assert.Equal(t, expected, r)
+		})
+	}
+}
+
+func TestServiceResponseWriterReservedJSONAttributes(t *testing.T) {
+	w := &ServiceResponseWriter{Format: ResponseFormatJSON}
+
+	for _, name := range []string{"authenticationDate", "longTermAuthenticationRequestTokenUsed", "isFromNewLogin", "memberOf"} {
+		r := fullAuthenticationResponse()
+		r.Attributes[name] = []string{"forged"}
+
+		_, err := w.MarshalSuccess(r)
+		assert.ErrorIs(t, err, errReservedAttribute, name)
+	}
+
+	// memberOf is reserved even when the response has no groups
+	r := &AuthenticationResponse{User: "enoch.root", Attributes: UserAttributes{"memberOf": {"admins"}}}
+	_, err := w.MarshalSuccess(r)
+	assert.ErrorIs(t, err, errReservedAttribute)
+}
+
+func TestServiceResponseWriterRubyCasCannotSpoofUser(t *testing.T) {
+	w := &ServiceResponseWriter{AttributesStyle: RubyCasAttributesStyle}
+
+	for _, name := range []string{"user", "proxyGrantingTicket", "proxies", "attributes"} {
+		r := &AuthenticationResponse{User: "alice", Attributes: UserAttributes{name: {"admin"}}}
+
+		data, err := w.MarshalSuccess(r)
+		assert.ErrorIs(t, err, errReservedAttribute, name)
+
+		if err == nil {
+			parsed, err := ParseServiceResponse(data)
+			require.NoError(t, err)
+			assert.Equal(t, "alice", parsed.User)
+		}
+	}
+
+	data, err := w.MarshalSuccess(&AuthenticationResponse{User: "alice", Attributes: UserAttributes{"role": {"admin"}}})
+	require.NoError(t, err)
+	parsed, err := ParseServiceResponse(data)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", parsed.User)
+	assert.Equal(t, "admin", parsed.Attributes.Get("role"))
+}
+
+func TestServiceResponseWriterInvalidElementNames(t *testing.T) {
+	for _, style := range []AttributesStyle{CasThreeZeroAnyAttributesStyle, RubyCasAttributesStyle} {
+		w := &ServiceResponseWriter{AttributesStyle: style}
+
+		for _, name := range []string{"display name", "urn:oid:0.9.2342.19200300.100.1.3", "1st", "a<b", ""} {
+			_, err := w.MarshalSuccess(&AuthenticationResponse{User: "alice", Attributes: UserAttributes{name: {"A"}}})
+			assert.ErrorIs(t, err, errInvalidAttributeName, "%s %q", style, name)
+		}
+	}
+
+	// Named attributes carry the name in an xml attribute, any name round trips
+	w := &ServiceResponseWriter{}
+	expected := &AuthenticationResponse{User: "alice", Attributes: UserAttributes{"urn:oid:0.9.2342.19200300.100.1.3": {"a@example.com"}}}
+	data, err := w.MarshalSuccess(expected)
+	require.NoError(t, err)
+	parsed, err := ParseServiceResponse(data)
+	require.NoError(t, err)
+	assert.Equal(t, expected, parsed)
+}
+
+func TestServiceResponseWriterOmitsZeroAuthenticationDate(t *testing.T) {
+	r := &AuthenticationResponse{User: "alice", IsNewLogin: true, Attributes: UserAttributes{"mail": {"a@example.com"}}}
+
+	for _, w := range []*ServiceResponseWriter{{}, {Format: ResponseFormatJSON}} {
+		data, err := w.MarshalSuccess(r)
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "authenticationDate")
+		assert.NotContains(t, string(data), "0001-01-01")
+
+		parsed, err := ParseServiceResponse(data)
+		require.NoError(t, err)
+		assert.True(t, parsed.AuthenticationDate.IsZero())
+	}
+}
+
+func TestServiceResponseWriterMinimalSuccess(t *testing.T) {
+	w := &ServiceResponseWriter{Indent: 2}
+
+	data, err := w.MarshalSuccess(&AuthenticationResponse{User: "username"})
+	require.NoError(t, err)
+
+	expected := `<serviceResponse xmlns="http://www.yale.edu/tp/cas">
+  <authenticationSuccess>
+    <user>username</user>
+  </authenticationSuccess>
+</serviceResponse>`
+	assert.Equal(t, expected, string(data))
+}
+
+func TestServiceResponseWriterFailure(t *testing.T) {
+	for _, format := range []ResponseFormat{ResponseFormatXML, ResponseFormatJSON} {
+		w := &ServiceResponseWriter{Format: format}
+
+		data, err := w.MarshalFailure(INVALID_TICKET, "Ticket ST-1 not recognized")
+		require.NoError(t, err)
+
+		_, err = ParseServiceResponse(data)
+		assert.Equal(t, &AuthenticationError{Code: INVALID_TICKET, Message: "Ticket ST-1 not recognized"}, err, format.String())
+	}
+}
+
+func TestServiceResponseWriterProxy(t *testing.T) {
+	for _, format := range []ResponseFormat{ResponseFormatXML, ResponseFormatJSON} {
+		w := &ServiceResponseWriter{Format: format}
+
+		data, err := w.MarshalProxySuccess("PT-1856392-b98xZrQN4p90ASrw96c8")
+		require.NoError(t, err)
+
+		pt, err := ParseProxyResponse(data)
+		require.NoError(t, err)
+		assert.Equal(t, "PT-1856392-b98xZrQN4p90ASrw96c8", pt)
+
+		data, err = w.MarshalProxyFailure(INVALID_REQUEST, "'pgt' and 'targetService' parameters are both required")
+		require.NoError(t, err)
+
+		_, err = ParseProxyResponse(data)
+		assert.Equal(t, &AuthenticationError{Code: INVALID_REQUEST, Message: "'pgt' and 'targetService' parameters are both required"}, err, format.String())
+
+		// A proxy response is not an authentication result
+		_, err = ParseServiceResponse(data)
+		require.Error(t, err)
+		assert.Equal(t, INTERNAL_ERROR, err.(*AuthenticationError).Code)
+	}
+}
+
+func TestServiceResponseWriterWrite(t *testing.T) {
+	w := &ServiceResponseWriter{Format: ResponseFormatJSON}
+	rec := httptest.NewRecorder()
+
+	require.NoError(t, w.WriteSuccess(rec, &AuthenticationResponse{User: "username"}))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"serviceResponse":{"authenticationSuccess":{"user":"username"}}}`, rec.Body.String())
+}
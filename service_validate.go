@@ -1,72 +1,286 @@
 package cas
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
-	"path"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/mattmohan-flipp/cas/v2/proxy"
+	"github.com/mattmohan-flipp/cas/v2/urlscheme"
 )
 
+// errEndpointNotFound is returned when the CAS server does not provide the requested validation endpoint
+var errEndpointNotFound = errors.New("cas: validation endpoint not found")
+
+// defaultRequestTimeout bounds requests to the CAS server when the http.Client has no Timeout
+const defaultRequestTimeout = 10 * time.Second
+
+// requestContext applies the default request timeout to the context unless the client has a Timeout of its own.
+func requestContext(ctx context.Context, client *http.Client) (context.Context, context.CancelFunc) {
+	if client.Timeout > 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, defaultRequestTimeout)
+}
+
 type ServiceTicketValidatorOptions struct {
-	Client *http.Client
-	CasURL *url.URL
-	Logger *slog.Logger
+	Client          *http.Client
+	CasURL          *url.URL
+	URLScheme       urlscheme.URLScheme // Custom url scheme, if nil a DefaultURLScheme for CasURL will be used
+	Logger          *slog.Logger
+	ProtocolVersion ProtocolVersion // CAS protocol used to validate tickets, defaults to ProtocolAuto
+	ResponseFormat  ResponseFormat  // Format requested for service responses, defaults to ResponseFormatXML
+
+	AllowedProxyChains []ProxyChain // Proxy chains accepted by ValidateProxyTicket
+	AcceptAnyProxy     bool         // Accept proxy tickets regardless of the proxy chain
+
+	Retry          *RetryOptions   // Retry validation requests failing transiently, no retries if nil
+	CircuitBreaker *CircuitBreaker // Fail validation fast while the CAS server is down, disabled if nil
 }
 
 // NewServiceTicketValidator create a new *ServiceTicketValidator
 func NewServiceTicketValidator(options ServiceTicketValidatorOptions) *ServiceTicketValidator {
+	logger := options.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	client := options.Client
+	if client == nil {
+		client = &http.Client{}
+	}
+
+	urlScheme := options.URLScheme
+	if urlScheme == nil {
+		urlScheme = urlscheme.NewDefaultURLScheme(options.CasURL)
+	}
+
 	return &ServiceTicketValidator{
-		client: options.Client,
-		casURL: options.CasURL,
-		logger: options.Logger,
+		client:          client,
+		urlScheme:       urlScheme,
+		logger:          logger,
+		protocolVersion: options.ProtocolVersion,
+		responseFormat:  options.ResponseFormat,
+
+		allowedProxyChains: compileProxyChains(options.AllowedProxyChains, logger),
+		acceptAnyProxy:     options.AcceptAnyProxy,
+
+		retry:   options.Retry,
+		breaker: options.CircuitBreaker,
 	}
 }
 
 // ServiceTicketValidator is responsible for the validation of a service ticket
 type ServiceTicketValidator struct {
-	client *http.Client
-	casURL *url.URL
-	logger *slog.Logger
+	client          *http.Client
+	urlScheme       urlscheme.URLScheme
+	logger          *slog.Logger
+	protocolVersion ProtocolVersion
+	responseFormat  ResponseFormat
+
+	allowedProxyChains []compiledProxyChain
+	acceptAnyProxy     bool
+
+	retry   *RetryOptions
+	breaker *CircuitBreaker
+
+	mu              sync.RWMutex
+	detectedVersion ProtocolVersion
+}
+
+// ProtocolVersion returns the protocol version used for validation.
+//
+// For a ProtocolAuto validator this is the detected version, or ProtocolAuto
+// if no ticket has been validated yet.
+func (validator *ServiceTicketValidator) ProtocolVersion() ProtocolVersion {
+	if validator.protocolVersion != ProtocolAuto {
+		return validator.protocolVersion
+	}
+
+	validator.mu.RLock()
+	defer validator.mu.RUnlock()
+	return validator.detectedVersion
 }
 
-// ValidateTicket validates the service ticket for the given server. The method will try to use the service validate
-// endpoint of the cas >= 2 protocol, if the service validate endpoint not available, the function will use the cas 1
-// validate endpoint.
+// setDetectedVersion remembers the protocol version supported by the CAS server.
+func (validator *ServiceTicketValidator) setDetectedVersion(version ProtocolVersion) {
+	validator.mu.Lock()
+	defer validator.mu.Unlock()
+
+	if validator.detectedVersion != version {
+		validator.logger.Info("Detected CAS protocol version", slog.String("protocol", version.String()))
+	}
+	validator.detectedVersion = version
+}
+
+// ValidateTicket validates the service ticket for the given server.
+//
+// The endpoint is chosen by the configured ProtocolVersion. With ProtocolAuto the CAS 3 p3/serviceValidate endpoint
+// is tried first, falling back to the CAS 2 serviceValidate and then the CAS 1 validate endpoint when the server
+// responds with 404. The first endpoint to answer is remembered and used for all further validations.
+// ProtocolSAML11 posts a SAML 1.1 request to the samlValidate endpoint instead.
 func (validator *ServiceTicketValidator) ValidateTicket(serviceURL *url.URL, ticket string, proxy *proxy.Proxy) (*AuthenticationResponse, error) {
+	return validator.ValidateTicketContext(context.Background(), serviceURL, ticket, proxy)
+}
+
+// ValidateTicketContext is like ValidateTicket, but the requests to the CAS server and the wait for the
+// proxy granting ticket are canceled when the context is done.
+func (validator *ServiceTicketValidator) ValidateTicketContext(ctx context.Context, serviceURL *url.URL, ticket string, proxy *proxy.Proxy) (*AuthenticationResponse, error) {
 	validator.logger.Info("Validating ticket", slog.String("ticket", ticket), slog.String("serviceURL", serviceURL.String()))
 
-	u, err := validator.ServiceValidateUrl(serviceURL, ticket, proxy)
+	return validator.validateTicket(ctx, serviceURL, ticket, proxy, false)
+}
+
+// ValidateRenewedTicket validates the service ticket like ValidateTicket, but sends renew=true so that CAS only
+// accepts tickets issued from a fresh primary authentication.
+//
+// The response is returned as sent by the server. CAS 1 and CAS 2 servers do not report how the user authenticated,
+// for these servers the fresh login is only guaranteed by the server enforcing renew=true during the validation.
+func (validator *ServiceTicketValidator) ValidateRenewedTicket(serviceURL *url.URL, ticket string, proxy *proxy.Proxy) (*AuthenticationResponse, error) {
+	return validator.ValidateRenewedTicketContext(context.Background(), serviceURL, ticket, proxy)
+}
+
+// ValidateRenewedTicketContext is like ValidateRenewedTicket, but canceled when the context is done.
+func (validator *ServiceTicketValidator) ValidateRenewedTicketContext(ctx context.Context, serviceURL *url.URL, ticket string, proxy *proxy.Proxy) (*AuthenticationResponse, error) {
+	validator.logger.Info("Validating renewed ticket", slog.String("ticket", ticket), slog.String("serviceURL", serviceURL.String()))
+
+	return validator.validateTicket(ctx, serviceURL, ticket, proxy, true)
+}
+
+func (validator *ServiceTicketValidator) validateTicket(ctx context.Context, serviceURL *url.URL, ticket string, proxy *proxy.Proxy, renew bool) (*AuthenticationResponse, error) {
+	success, err := validator.validateWithVersion([]ProtocolVersion{ProtocolCAS3, ProtocolCAS2, ProtocolCAS1}, func(version ProtocolVersion) (*AuthenticationResponse, error) {
+		return validator.validateTicketVersion(ctx, serviceURL, ticket, proxy, version, renew)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	validator.resolveProxyGrantingTicket(ctx, success, proxy)
+	return success, nil
+}
+
+// resolveProxyGrantingTicket looks up the PGT delivered to the proxy callback for the IOU of the response.
+//
+// The CAS server calls the callback before answering the validation request, but the callback may be handled by
+// another instance of a cluster, so the lookup waits for the PGT to arrive in the ProxyStore. A missing PGT does not
+// fail the validation, proxy tickets then cannot be requested for the session.
+func (validator *ServiceTicketValidator) resolveProxyGrantingTicket(ctx context.Context, success *AuthenticationResponse, proxy *proxy.Proxy) {
+	if proxy == nil || !proxy.IsEnabled() || success.ProxyGrantingTicket == "" {
+		return
+	}
+
+	pgt, ok := proxy.WaitProxyTgtContext(ctx, success.ProxyGrantingTicket)
+	if !ok {
+		validator.logger.Warn("Proxy granting ticket not received", slog.String("pgtIou", success.ProxyGrantingTicket))
+		return
+	}
+
+	success.ProxyGrantingTicketID = pgt
+}
+
+// validateWithVersion runs validate for the configured protocol version.
+//
+// For ProtocolAuto the candidate versions are tried in order until one of them answers, that version is then
+// remembered for subsequent validations.
+func (validator *ServiceTicketValidator) validateWithVersion(candidates []ProtocolVersion, validate func(ProtocolVersion) (*AuthenticationResponse, error)) (*AuthenticationResponse, error) {
+	if version := validator.ProtocolVersion(); version != ProtocolAuto {
+		return validate(version)
+	}
+
+	for _, version := range candidates {
+		success, err := validate(version)
+		if err == errEndpointNotFound {
+			validator.logger.Debug("Validation endpoint not available", slog.String("protocol", version.String()))
+			continue
+		}
+
+		// Only remember the version once the server has answered with a CAS response,
+		// transport and server errors say nothing about the supported protocol.
+		var authErr *AuthenticationError
+		if err == nil || errors.As(err, &authErr) {
+			validator.setDetectedVersion(version)
+		}
+
+		return success, err
+	}
+
+	return nil, errEndpointNotFound
+}
+
+// validateTicketVersion validates the service ticket against the endpoint for a specific protocol version.
+func (validator *ServiceTicketValidator) validateTicketVersion(ctx context.Context, serviceURL *url.URL, ticket string, proxy *proxy.Proxy, version ProtocolVersion, renew bool) (*AuthenticationResponse, error) {
+	switch version {
+	case ProtocolCAS1:
+		return validator.validateTicketCas1(ctx, serviceURL, ticket, renew)
+	case ProtocolSAML11:
+		return validator.validateTicketSaml(ctx, serviceURL, ticket, renew)
+	}
+
+	u, err := validator.serviceValidateUrl(serviceURL, ticket, proxy, version, renew)
+	if err != nil {
+		return nil, err
+	}
+
+	return validator.validateServiceResponse(ctx, u)
+}
+
+// validateServiceResponse requests a CAS service response from the url and parses it.
+func (validator *ServiceTicketValidator) validateServiceResponse(ctx context.Context, u string) (*AuthenticationResponse, error) {
+	body, err := validator.fetch(ctx, u)
 	if err != nil {
 		return nil, err
 	}
 
-	r, err := http.NewRequest("GET", u, nil)
+	validator.logger.Debug("Received authentication response", slog.String("response", string(body)))
+
+	success, err := ParseServiceResponse(body)
 	if err != nil {
 		return nil, err
 	}
 
+	validator.logger.Debug("Parsed ServiceResponse", slog.Any("response", success))
+
+	return success, nil
+}
+
+// fetch performs a GET request against a validation endpoint and returns the response body.
+func (validator *ServiceTicketValidator) fetch(ctx context.Context, u string) ([]byte, error) {
+	r, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return validator.do(r)
+}
+
+// do sends a request to a validation endpoint and returns the response body.
+func (validator *ServiceTicketValidator) do(r *http.Request) ([]byte, error) {
 	r.Header.Add("User-Agent", "Golang CAS client github.com/mattmohan-flipp/cas/v2")
 
 	validator.logger.Info("Attempting ticket validation", slog.String("url", r.URL.String()))
 
-	resp, err := validator.client.Do(r)
+	resp, err := validator.send(r)
 	if err != nil {
 		return nil, err
 	}
 
 	validator.logger.Debug("Request returned", slog.String("status", resp.Status), slog.String("url", r.URL.String()), slog.String("method", r.Method))
 
-	if resp.StatusCode == http.StatusNotFound {
-		return validator.validateTicketCas1(serviceURL, ticket)
-	}
-
 	body, err := io.ReadAll(resp.Body)
 	resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errEndpointNotFound
+	}
+
 	if err != nil {
 		return nil, err
 	}
@@ -75,77 +289,85 @@ func (validator *ServiceTicketValidator) ValidateTicket(serviceURL *url.URL, tic
 		return nil, fmt.Errorf("cas: validate ticket: %v", string(body))
 	}
 
-	validator.logger.Debug("Received authentication response", slog.String("response", string(body)))
-
-	success, err := ParseServiceResponse(body)
-	if err != nil {
-		return nil, err
-	}
-
-	validator.logger.Debug("Parsed ServiceResponse", slog.Any("response", success))
+	return body, nil
+}
 
-	return success, nil
+// send sends the request to the CAS server, failing over between CAS nodes when the URLScheme supports it.
+func (validator *ServiceTicketValidator) send(r *http.Request) (*http.Response, error) {
+	return sendFailover(validator.urlScheme, validator.client, r, validator.retry, validator.breaker, validator.logger)
 }
 
 // ServiceValidateUrl creates the service validation url for the cas >= 2 protocol.
+//
+// The CAS 3 p3/serviceValidate endpoint is used unless the validator is configured for, or has detected, an older
+// protocol version.
 // TODO the function is only exposed, because of the clients ServiceValidateUrl function
 func (validator *ServiceTicketValidator) ServiceValidateUrl(serviceURL *url.URL, ticket string, proxy *proxy.Proxy) (string, error) {
-	u, err := validator.casURL.Parse(path.Join(validator.casURL.Path, "serviceValidate"))
+	version := validator.ProtocolVersion()
+	if version == ProtocolAuto {
+		version = ProtocolCAS3
+	}
+
+	return validator.serviceValidateUrl(serviceURL, ticket, proxy, version, false)
+}
+
+func (validator *ServiceTicketValidator) serviceValidateUrl(serviceURL *url.URL, ticket string, proxy *proxy.Proxy, version ProtocolVersion, renew bool) (string, error) {
+	var u *url.URL
+	var err error
+	if version == ProtocolCAS3 {
+		u, err = urlscheme.P3ServiceValidate(validator.urlScheme)
+	} else {
+		u, err = validator.urlScheme.ServiceValidate()
+	}
 	if err != nil {
 		return "", err
 	}
 
+	return validator.validationUrl(u, serviceURL, ticket, proxy, renew), nil
+}
+
+// validationUrl adds the service, ticket, renew, pgtUrl and format parameters to a cas >= 2 validation endpoint.
+func (validator *ServiceTicketValidator) validationUrl(u *url.URL, serviceURL *url.URL, ticket string, proxy *proxy.Proxy, renew bool) string {
 	q := u.Query()
 	q.Add("service", sanitisedURLString(serviceURL))
 	q.Add("ticket", ticket)
+	if renew {
+		q.Add("renew", "true")
+	}
 	if proxy.IsEnabled() {
 		q.Add("pgtUrl", sanitisedURLString(proxy.GetProxyCallbackURL()))
 	}
+	if validator.responseFormat == ResponseFormatJSON {
+		q.Add("format", "JSON")
+	}
 	u.RawQuery = q.Encode()
 
-	return u.String(), nil
+	return u.String()
 }
 
-func (validator *ServiceTicketValidator) validateTicketCas1(serviceURL *url.URL, ticket string) (*AuthenticationResponse, error) {
-	u, err := validator.ValidateUrl(serviceURL, ticket)
-	if err != nil {
-		return nil, err
-	}
-
-	r, err := http.NewRequest("GET", u, nil)
+func (validator *ServiceTicketValidator) validateTicketCas1(ctx context.Context, serviceURL *url.URL, ticket string, renew bool) (*AuthenticationResponse, error) {
+	u, err := validator.validateUrl(serviceURL, ticket, renew)
 	if err != nil {
 		return nil, err
 	}
 
-	r.Header.Add("User-Agent", "Golang CAS client github.com/mattmohan-flipp/cas/v2")
-	validator.logger.Debug("Attempting ticket validation", slog.String("url", r.URL.String()))
-
-	resp, err := validator.client.Do(r)
-	if err != nil {
-		return nil, err
-	}
-	validator.logger.Debug("Request returned", slog.String("status", resp.Status), slog.String("url", r.URL.String()), slog.String("method", r.Method))
-
-	data, err := io.ReadAll(resp.Body)
-	resp.Body.Close()
-
+	data, err := validator.fetch(ctx, u)
 	if err != nil {
 		return nil, err
 	}
 
 	body := string(data)
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("cas: validate ticket: %v", body)
-	}
 	validator.logger.Debug("Received authentication response", slog.String("response", body))
 
-	if body == "no\n\n" {
-		return nil, nil // not logged in
+	// CAS 1 responses are "yes\n<username>\n" or "no\n\n"
+	lines := strings.Split(body, "\n")
+	if len(lines) < 2 || lines[0] != "yes" || lines[1] == "" {
+		return nil, &AuthenticationError{Code: INVALID_TICKET, Message: fmt.Sprintf("Ticket %s not recognized", ticket)}
 	}
 
 	success := &AuthenticationResponse{
-		User: body[4 : len(body)-1],
+		User:       lines[1],
+		Attributes: make(UserAttributes),
 	}
 
 	validator.logger.Debug("Parsed ServiceResponse", slog.Any("response", success))
@@ -156,7 +378,11 @@ func (validator *ServiceTicketValidator) validateTicketCas1(serviceURL *url.URL,
 // ValidateUrl creates the validation url for the cas >= 1 protocol.
 // TODO the function is only exposed, because of the clients ValidateUrl function
 func (validator *ServiceTicketValidator) ValidateUrl(serviceURL *url.URL, ticket string) (string, error) {
-	u, err := validator.casURL.Parse(path.Join(validator.casURL.Path, "validate"))
+	return validator.validateUrl(serviceURL, ticket, false)
+}
+
+func (validator *ServiceTicketValidator) validateUrl(serviceURL *url.URL, ticket string, renew bool) (string, error) {
+	u, err := validator.urlScheme.Validate()
 	if err != nil {
 		return "", err
 	}
@@ -164,6 +390,9 @@ func (validator *ServiceTicketValidator) ValidateUrl(serviceURL *url.URL, ticket
 	q := u.Query()
 	q.Add("service", sanitisedURLString(serviceURL))
 	q.Add("ticket", ticket)
+	if renew {
+		q.Add("renew", "true")
+	}
 	u.RawQuery = q.Encode()
 
 	return u.String(), nil
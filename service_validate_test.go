@@ -0,0 +1,257 @@
+package cas
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mattmohan-flipp/cas/v2/proxy"
+	"github.com/mattmohan-flipp/cas/v2/proxy/store"
+	"github.com/mattmohan-flipp/cas/v2/urlscheme"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestValidator(t *testing.T, server *httptest.Server, version ProtocolVersion) *ServiceTicketValidator {
+	casURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	return NewServiceTicketValidator(ServiceTicketValidatorOptions{
+		Client:          server.Client(),
+		CasURL:          casURL,
+		ProtocolVersion: version,
+	})
+}
+
+func newDisabledProxy() *proxy.Proxy {
+	return proxy.NewProxy(urlscheme.NewDefaultURLScheme(&url.URL{}), &proxy.ProxyOptions{})
+}
+
+func TestValidateTicketAutoDetectsCas3(t *testing.T) {
+	server := newFakeCasServer(serveValidation("/p3/serviceValidate", "/serviceValidate"))
+	defer server.Close()
+
+	validator := newTestValidator(t, server.Server, ProtocolAuto)
+	service, _ := url.Parse("http://example.com/")
+
+	success, err := validator.ValidateTicket(service, "ST-1", newDisabledProxy())
+	require.NoError(t, err)
+	assert.Equal(t, "enoch.root", success.User)
+	assert.Equal(t, ProtocolCAS3, validator.ProtocolVersion())
+	assert.Equal(t, []string{"/p3/serviceValidate"}, server.Paths())
+}
+
+func TestValidateTicketAutoFallsBackAndRemembers(t *testing.T) {
+	server := newFakeCasServer(serveValidation("/serviceValidate"))
+	defer server.Close()
+
+	validator := newTestValidator(t, server.Server, ProtocolAuto)
+	service, _ := url.Parse("http://example.com/")
+
+	_, err := validator.ValidateTicket(service, "ST-1", newDisabledProxy())
+	require.NoError(t, err)
+	assert.Equal(t, ProtocolCAS2, validator.ProtocolVersion())
+	assert.Equal(t, []string{"/p3/serviceValidate", "/serviceValidate"}, server.Paths())
+
+	server.Reset()
+	_, err = validator.ValidateTicket(service, "ST-2", newDisabledProxy())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/serviceValidate"}, server.Paths())
+}
+
+func TestValidateTicketAutoFallsBackToCas1(t *testing.T) {
+	server := newFakeCasServer(serveValidation("/validate"))
+	defer server.Close()
+
+	validator := newTestValidator(t, server.Server, ProtocolAuto)
+	service, _ := url.Parse("http://example.com/")
+
+	success, err := validator.ValidateTicket(service, "ST-1", newDisabledProxy())
+	require.NoError(t, err)
+	assert.Equal(t, "enoch.root", success.User)
+	assert.Equal(t, ProtocolCAS1, validator.ProtocolVersion())
+	assert.Equal(t, []string{"/p3/serviceValidate", "/serviceValidate", "/validate"}, server.Paths())
+}
+
+func TestValidateTicketExplicitVersion(t *testing.T) {
+	tests := []struct {
+		version ProtocolVersion
+		path    string
+	}{
+		{ProtocolCAS1, "/validate"},
+		{ProtocolCAS2, "/serviceValidate"},
+		{ProtocolCAS3, "/p3/serviceValidate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version.String(), func(t *testing.T) {
+			server := newFakeCasServer(serveValidation("/validate", "/serviceValidate", "/p3/serviceValidate"))
+			defer server.Close()
+
+			validator := newTestValidator(t, server.Server, tt.version)
+			service, _ := url.Parse("http://example.com/")
+
+			_, err := validator.ValidateTicket(service, "ST-1", newDisabledProxy())
+			require.NoError(t, err)
+			assert.Equal(t, []string{tt.path}, server.Paths())
+		})
+	}
+}
+
+func TestValidateTicketCas1Rejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "no\n\n")
+	}))
+	defer server.Close()
+
+	validator := newTestValidator(t, server, ProtocolCAS1)
+	service, _ := url.Parse("http://example.com/")
+
+	success, err := validator.ValidateTicket(service, "ST-1", newDisabledProxy())
+	assert.Nil(t, success)
+	require.Error(t, err)
+
+	authErr, ok := err.(*AuthenticationError)
+	require.True(t, ok)
+	assert.Equal(t, INVALID_TICKET, authErr.Code)
+}
+
+func TestServiceValidateUrlForVersion(t *testing.T) {
+	casURL, _ := url.Parse("https://cas.example.com/cas")
+	service, _ := url.Parse("http://example.com/")
+
+	validator := NewServiceTicketValidator(ServiceTicketValidatorOptions{CasURL: casURL, ProtocolVersion: ProtocolCAS2})
+	u, err := validator.ServiceValidateUrl(service, "ST-1", newDisabledProxy())
+	require.NoError(t, err)
+	assert.Equal(t, "https://cas.example.com/cas/serviceValidate?service=http%3A%2F%2Fexample.com%2F&ticket=ST-1", u)
+
+	validator = NewServiceTicketValidator(ServiceTicketValidatorOptions{CasURL: casURL})
+	u, err = validator.ServiceValidateUrl(service, "ST-1", newDisabledProxy())
+	require.NoError(t, err)
+	assert.Equal(t, "https://cas.example.com/cas/p3/serviceValidate?service=http%3A%2F%2Fexample.com%2F&ticket=ST-1", u)
+}
+
+func TestValidateTicketResolvesProxyGrantingTicket(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "https://web.example.com/pgtCallback", r.URL.Query().Get("pgtUrl"))
+		(&ServiceResponseWriter{}).WriteSuccess(w, &AuthenticationResponse{User: "enoch.root", ProxyGrantingTicket: "PGTIOU-1"})
+	}))
+	defer server.Close()
+
+	validator := newTestValidator(t, server, ProtocolCAS3)
+	proxyStore := store.NewMemoryProxyStore()
+	p := proxy.NewProxy(validator.urlScheme, &proxy.ProxyOptions{
+		RequestProxy:     true,
+		ProxyCallbackURL: "https://web.example.com/pgtCallback",
+		ProxyStore:       proxyStore,
+		WaitTimeout:      time.Second,
+	})
+
+	// The callback is handled by another instance after the validation response was sent
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		proxyStore.Set("PGTIOU-1", "PGT-1")
+	}()
+
+	serviceURL, _ := url.Parse("https://web.example.com/")
+	success, err := validator.ValidateTicket(serviceURL, "ST-1", p)
+	require.NoError(t, err)
+	assert.Equal(t, "PGTIOU-1", success.ProxyGrantingTicket)
+	assert.Equal(t, "PGT-1", success.ProxyGrantingTicketID)
+
+	// A PGT which never arrives does not fail the validation
+	p = proxy.NewProxy(validator.urlScheme, &proxy.ProxyOptions{
+		RequestProxy:     true,
+		ProxyCallbackURL: "https://web.example.com/pgtCallback",
+		WaitTimeout:      10 * time.Millisecond,
+	})
+
+	success, err = validator.ValidateTicket(serviceURL, "ST-2", p)
+	require.NoError(t, err)
+	assert.Equal(t, "", success.ProxyGrantingTicketID)
+}
+
+func TestValidateTicketContextCanceled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	validator := newTestValidator(t, server, ProtocolCAS3)
+	service, _ := url.Parse("http://example.com/")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := validator.ValidateTicketContext(ctx, service, "ST-1", newDisabledProxy())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRequestContext(t *testing.T) {
+	ctx, cancel := requestContext(context.Background(), &http.Client{})
+	defer cancel()
+
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(defaultRequestTimeout), deadline, time.Second)
+
+	ctx, cancel = requestContext(context.Background(), &http.Client{Timeout: time.Second})
+	defer cancel()
+
+	_, ok = ctx.Deadline()
+	assert.False(t, ok)
+}
+
+func TestValidateTicketFailsOver(t *testing.T) {
+	primary := newFakeCasServer(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer primary.Close()
+
+	secondary := newFakeCasServer(serveValidation("/cas/p3/serviceValidate"))
+	defer secondary.Close()
+
+	primaryURL, _ := url.Parse(primary.URL + "/cas")
+	secondaryURL, _ := url.Parse(secondary.URL + "/cas/")
+	scheme, err := urlscheme.NewFailoverURLScheme(primaryURL, secondaryURL)
+	require.NoError(t, err)
+
+	var logs bytes.Buffer
+	validator := NewServiceTicketValidator(ServiceTicketValidatorOptions{
+		Client:          http.DefaultClient,
+		URLScheme:       scheme,
+		Logger:          slog.New(slog.NewTextHandler(&logs, nil)),
+		ProtocolVersion: ProtocolCAS3,
+	})
+	service, _ := url.Parse("http://example.com/")
+
+	success, err := validator.ValidateTicket(service, "ST-1", newDisabledProxy())
+	require.NoError(t, err)
+	assert.Equal(t, "enoch.root", success.User)
+	assert.Equal(t, []string{"/cas/p3/serviceValidate"}, primary.Paths())
+	assert.Equal(t, []string{"/cas/p3/serviceValidate"}, secondary.Paths())
+	assert.Contains(t, logs.String(), `msg="CAS node served request" node=`+secondaryURL.String())
+
+	// Logins move to the secondary while the primary is down
+	assert.True(t, scheme.IsDown(primaryURL))
+	login, err := scheme.Login()
+	require.NoError(t, err)
+	assert.Equal(t, secondary.URL+"/cas/login", login.String())
+
+	// The healthy secondary is validated against directly
+	_, err = validator.ValidateTicket(service, "ST-2", newDisabledProxy())
+	require.NoError(t, err)
+	assert.Equal(t, 1, primary.Count())
+	assert.Equal(t, 2, secondary.Count())
+}
@@ -1,6 +1,9 @@
 package cas
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 // SessionStore store the session's ticket
 // SessionID is retrived from cookies
@@ -15,16 +18,49 @@ type SessionStore interface {
 	Delete(sessionID string) error
 }
 
+// TicketIndexedSessionStore is implemented by SessionStores which index sessions by ticket. Single logout requests
+// of the CAS server only end the sessions of the ticket if the SessionStore implements it.
+type TicketIndexedSessionStore interface {
+	SessionStore
+
+	// GetByTicket returns the ids of all sessions bound to the ticket
+	GetByTicket(ticket string) []string
+
+	// DeleteByTicket deletes all sessions bound to the ticket
+	DeleteByTicket(ticket string) error
+}
+
+// ContextSessionStore is implemented by SessionStores which accept a context, so that calls to remote
+// stores are canceled along with the request. The Client prefers these methods when they are available.
+type ContextSessionStore interface {
+	// GetContext is like Get, but canceled when the context is done.
+	GetContext(ctx context.Context, sessionID string) (string, bool)
+
+	// SetContext is like Set, but canceled when the context is done.
+	SetContext(ctx context.Context, sessionID, ticket string) error
+
+	// DeleteContext is like Delete, but canceled when the context is done.
+	DeleteContext(ctx context.Context, sessionID string) error
+}
+
+// ContextTicketIndexedSessionStore is implemented by TicketIndexedSessionStores which accept a context.
+type ContextTicketIndexedSessionStore interface {
+	// DeleteByTicketContext is like DeleteByTicket, but canceled when the context is done.
+	DeleteByTicketContext(ctx context.Context, ticket string) error
+}
+
 // NewMemorySessionStore create a default SessionStore that uses memory
 func NewMemorySessionStore() SessionStore {
 	return &memorySessionStore{
 		sessions: make(map[string]string),
+		tickets:  make(map[string]map[string]struct{}),
 	}
 }
 
 type memorySessionStore struct {
 	mu       sync.RWMutex
 	sessions map[string]string
+	tickets  map[string]map[string]struct{} // ticket to session ids index
 }
 
 func (m *memorySessionStore) Get(sessionID string) (string, bool) {
@@ -37,7 +73,13 @@ func (m *memorySessionStore) Get(sessionID string) (string, bool) {
 
 func (m *memorySessionStore) Set(sessionID, ticket string) error {
 	m.mu.Lock()
+	m.unindex(sessionID)
 	m.sessions[sessionID] = ticket
+
+	if m.tickets[ticket] == nil {
+		m.tickets[ticket] = make(map[string]struct{})
+	}
+	m.tickets[ticket][sessionID] = struct{}{}
 	m.mu.Unlock()
 
 	return nil
@@ -45,8 +87,45 @@ func (m *memorySessionStore) Set(sessionID, ticket string) error {
 
 func (m *memorySessionStore) Delete(sessionID string) error {
 	m.mu.Lock()
+	m.unindex(sessionID)
 	delete(m.sessions, sessionID)
 	m.mu.Unlock()
 
 	return nil
 }
+
+func (m *memorySessionStore) GetByTicket(ticket string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := make([]string, 0, len(m.tickets[ticket]))
+	for id := range m.tickets[ticket] {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+func (m *memorySessionStore) DeleteByTicket(ticket string) error {
+	m.mu.Lock()
+	for id := range m.tickets[ticket] {
+		delete(m.sessions, id)
+	}
+	delete(m.tickets, ticket)
+	m.mu.Unlock()
+
+	return nil
+}
+
+// unindex removes the session from the ticket index, the caller must hold the lock.
+func (m *memorySessionStore) unindex(sessionID string) {
+	ticket, ok := m.sessions[sessionID]
+	if !ok {
+		return
+	}
+
+	delete(m.tickets[ticket], sessionID)
+	if len(m.tickets[ticket]) == 0 {
+		delete(m.tickets, ticket)
+	}
+}
@@ -1,6 +1,10 @@
 package cas
 
 import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/url"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -82,3 +86,71 @@ func TestSessionStore_Delete(t *testing.T) {
 	require.False(t, ok)
 	require.Equal(t, "", v)
 }
+
+func TestSessionStore_GetByTicket(t *testing.T) {
+	ss := NewMemorySessionStore().(TicketIndexedSessionStore)
+	require.NotNil(t, ss)
+
+	require.Empty(t, ss.GetByTicket("ticket1"))
+
+	require.Nil(t, ss.Set("key1", "ticket1"))
+	require.Nil(t, ss.Set("key2", "ticket1"))
+	require.Nil(t, ss.Set("key3", "ticket2"))
+
+	require.ElementsMatch(t, []string{"key1", "key2"}, ss.GetByTicket("ticket1"))
+	require.ElementsMatch(t, []string{"key3"}, ss.GetByTicket("ticket2"))
+
+	// Re-binding a session moves it to the new ticket
+	require.Nil(t, ss.Set("key2", "ticket2"))
+	require.ElementsMatch(t, []string{"key1"}, ss.GetByTicket("ticket1"))
+	require.ElementsMatch(t, []string{"key2", "key3"}, ss.GetByTicket("ticket2"))
+
+	require.Nil(t, ss.Delete("key1"))
+	require.Empty(t, ss.GetByTicket("ticket1"))
+}
+
+func TestSessionStore_DeleteByTicket(t *testing.T) {
+	ss := NewMemorySessionStore().(TicketIndexedSessionStore)
+	require.NotNil(t, ss)
+
+	require.Nil(t, ss.Set("key1", "ticket1"))
+	require.Nil(t, ss.Set("key2", "ticket1"))
+	require.Nil(t, ss.Set("key3", "ticket2"))
+
+	require.Nil(t, ss.DeleteByTicket("ticket1"))
+
+	_, ok := ss.Get("key1")
+	require.False(t, ok)
+
+	_, ok = ss.Get("key2")
+	require.False(t, ok)
+
+	v, ok := ss.Get("key3")
+	require.True(t, ok)
+	require.Equal(t, "ticket2", v)
+
+	require.Empty(t, ss.GetByTicket("ticket1"))
+}
+
+// plainSessionStore hides the ticket index of the memory store.
+type plainSessionStore struct {
+	SessionStore
+}
+
+func TestDeleteSessionsByTicketWithoutTicketIndex(t *testing.T) {
+	var logs bytes.Buffer
+	casURL, _ := url.Parse("https://cas.example.com/")
+	sessions := plainSessionStore{NewMemorySessionStore()}
+	client := NewClient(&Options{
+		URL:          casURL,
+		SessionStore: sessions,
+		Logger:       slog.New(slog.NewTextHandler(&logs, nil)),
+	})
+
+	require.Nil(t, sessions.Set("key1", "ticket1"))
+	require.Nil(t, client.deleteSessionsByTicket(context.Background(), "ticket1"))
+	require.Contains(t, logs.String(), "single logout cannot end the sessions")
+
+	_, ok := sessions.Get("key1")
+	require.True(t, ok)
+}
@@ -0,0 +1,165 @@
+package cas
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TenantResolver returns the name of the tenant a request belongs to.
+type TenantResolver func(r *http.Request) string
+
+// TenantByHost resolves the tenant from the host of the request, without its port.
+//
+// The forwarding headers of requests from the trusted proxies, IPs or CIDRs, are honored like by Options.TrustedProxies.
+func TenantByHost(trustedProxies ...string) TenantResolver {
+	return tenantByHost(parseTrustedProxies(trustedProxies, slog.Default()))
+}
+
+func tenantByHost(proxies trustedProxies) TenantResolver {
+	return func(r *http.Request) string {
+		u := url.URL{Host: r.Host}
+		proxies.forwardedRequest(r).apply(&u)
+
+		return strings.ToLower(u.Hostname())
+	}
+}
+
+// TenantByPathPrefix resolves the tenant from the first segment of the request path, acme for /acme/reports.
+func TenantByPathPrefix() TenantResolver {
+	return func(r *http.Request) string {
+		tenant, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		return tenant
+	}
+}
+
+// TenantByHeader resolves the tenant from a request header, which must be set by a trusted front end.
+func TenantByHeader(name string) TenantResolver {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// MultiTenantOptions : MultiTenantClient configuration options
+//
+// Each tenant is served by a Client created from its Options. Stores may be shared between tenants, the keys of
+// every tenant are prefixed with its name. The cookies of every tenant are suffixed with its name, so tenants on the
+// same host keep separate sessions.
+type MultiTenantOptions struct {
+	Resolver       TenantResolver      // Resolves the tenant of a request, defaults to TenantByHost with TrustedProxies
+	Tenants        map[string]*Options // Client options by tenant name
+	UnknownTenant  http.Handler        // Replies to requests of unknown tenants, defaults to a 404 Not Found
+	TrustedProxies []string            // IPs or CIDRs of reverse proxies, used by tenants without TrustedProxies of their own
+	Logger         *slog.Logger        // Optional logger, used by tenants without a Logger of their own
+}
+
+// MultiTenantClient serves several tenants, each with their own CAS server, from a single middleware.
+//
+// Sessions are isolated between tenants: a session or ticket of one tenant never authenticates a request of
+// another tenant.
+type MultiTenantClient struct {
+	resolver TenantResolver
+	clients  map[string]*Client
+	unknown  http.Handler
+	logger   *slog.Logger
+}
+
+// NewMultiTenantClient creates a MultiTenantClient with a Client for every tenant.
+func NewMultiTenantClient(options *MultiTenantOptions) *MultiTenantClient {
+	logger := options.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	resolver := options.Resolver
+	if resolver == nil {
+		resolver = tenantByHost(parseTrustedProxies(options.TrustedProxies, logger))
+	}
+
+	unknown := options.UnknownTenant
+	if unknown == nil {
+		unknown = http.NotFoundHandler()
+	}
+
+	clients := make(map[string]*Client, len(options.Tenants))
+	for name, tenantOptions := range options.Tenants {
+		o := *tenantOptions
+		if o.Logger == nil {
+			o.Logger = logger.With(slog.String("tenant", name))
+		}
+		if o.TrustedProxies == nil {
+			o.TrustedProxies = options.TrustedProxies
+		}
+
+		c := NewClient(&o)
+		c.namespace = url.QueryEscape(name) + ":"
+		clients[name] = c
+	}
+
+	return &MultiTenantClient{
+		resolver: resolver,
+		clients:  clients,
+		unknown:  unknown,
+		logger:   logger,
+	}
+}
+
+// Client returns the Client of the tenant, or nil for an unknown tenant.
+func (m *MultiTenantClient) Client(tenant string) *Client {
+	return m.clients[tenant]
+}
+
+// Handle wraps a http.Handler to provide CAS authentication by the Client of the tenant of each request.
+func (m *MultiTenantClient) Handle(h http.Handler) http.Handler {
+	handlers := make(map[string]http.Handler, len(m.clients))
+	for name, c := range m.clients {
+		handlers[name] = c.Handle(h)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := m.resolver(r)
+		handler, ok := handlers[tenant]
+		if !ok {
+			m.logger.Warn("Request for unknown tenant", slog.String("tenant", tenant), slog.String("host", r.Host))
+			m.unknown.ServeHTTP(w, r)
+			return
+		}
+
+		setTenant(r, tenant)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// HandleFunc wraps a function to provide CAS authentication by the Client of the tenant of each request.
+func (m *MultiTenantClient) HandleFunc(h func(http.ResponseWriter, *http.Request)) http.Handler {
+	return m.Handle(http.HandlerFunc(h))
+}
+
+// HandleProxyCallback passes proxy callbacks to the Client of the tenant of the request.
+func (m *MultiTenantClient) HandleProxyCallback(w http.ResponseWriter, r *http.Request) {
+	c, ok := m.clients[m.resolver(r)]
+	if !ok {
+		m.unknown.ServeHTTP(w, r)
+		return
+	}
+
+	c.HandleProxyCallback(w, r)
+}
+
+// setTenant associates the tenant name with a http.Request.
+func setTenant(r *http.Request, tenant string) {
+	ctx := context.WithValue(r.Context(), tenantKey, tenant)
+	r2 := r.WithContext(ctx)
+	*r = *r2
+}
+
+// Tenant returns the name of the tenant of a request served by a MultiTenantClient.
+func Tenant(r *http.Request) string {
+	if t, ok := r.Context().Value(tenantKey).(string); ok {
+		return t
+	}
+
+	return ""
+}
@@ -0,0 +1,31 @@
+package cas
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantResolvers(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://Acme.example.com:8080/acme/reports", nil)
+	r.Header.Set("X-Tenant", "initech")
+
+	assert.Equal(t, "acme.example.com", TenantByHost()(r))
+	assert.Equal(t, "acme", TenantByPathPrefix()(r))
+	assert.Equal(t, "initech", TenantByHeader("X-Tenant")(r))
+
+	r = httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	assert.Equal(t, "", TenantByPathPrefix()(r))
+
+	// The host is only taken from the forwarding headers of trusted proxies
+	r = httptest.NewRequest(http.MethodGet, "http://proxy.internal/", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+	r.Header.Set("X-Forwarded-Host", "Acme.example.com:8443")
+	assert.Equal(t, "proxy.internal", TenantByHost()(r))
+	assert.Equal(t, "acme.example.com", TenantByHost("10.0.0.0/8")(r))
+
+	r.RemoteAddr = "203.0.113.7:5000"
+	assert.Equal(t, "proxy.internal", TenantByHost("10.0.0.0/8")(r))
+}
@@ -1,6 +1,7 @@
 package cas
 
 import (
+	"context"
 	"errors"
 )
 
@@ -25,3 +26,16 @@ type TicketStore interface {
 	// Clear removes all of the AuthenticationResponse data from the store.
 	Clear() error
 }
+
+// ContextTicketStore is implemented by TicketStores which accept a context, so that calls to remote
+// stores are canceled along with the request. The Client prefers these methods when they are available.
+type ContextTicketStore interface {
+	// ReadContext is like Read, but canceled when the context is done.
+	ReadContext(ctx context.Context, id string) (*AuthenticationResponse, error)
+
+	// WriteContext is like Write, but canceled when the context is done.
+	WriteContext(ctx context.Context, id string, ticket *AuthenticationResponse) error
+
+	// DeleteContext is like Delete, but canceled when the context is done.
+	DeleteContext(ctx context.Context, id string) error
+}
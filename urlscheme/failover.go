@@ -0,0 +1,247 @@
+package urlscheme
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultDownTime is how long a node marked down is avoided
+const defaultDownTime = 30 * time.Second
+
+// Failover is implemented by URLSchemes which spread the cas urls over several CAS nodes. Validators report
+// the nodes failing to answer, and move the request to another node.
+type Failover interface {
+	// Node returns the base url of the node serving u
+	Node(u *url.URL) (*url.URL, bool)
+	// MarkDown marks the node serving u down
+	MarkDown(u *url.URL)
+	// MarkUp marks the node serving u healthy
+	MarkUp(u *url.URL)
+	// Rewrite moves u to the preferred healthy node, false if that is the node already serving u
+	Rewrite(u *url.URL) (*url.URL, bool)
+}
+
+// ErrNoFailoverNodes is returned by NewFailoverURLScheme without base urls or with a nil base url.
+var ErrNoFailoverNodes = errors.New("urlscheme: failover requires at least one base url and no nil base urls")
+
+// NewFailoverURLScheme creates a FailoverURLScheme for the ordered CAS base urls, the first is the primary node.
+// At least one base url is required.
+func NewFailoverURLScheme(bases ...*url.URL) (*FailoverURLScheme, error) {
+	if len(bases) == 0 {
+		return nil, ErrNoFailoverNodes
+	}
+
+	nodes := make([]*DefaultURLScheme, len(bases))
+	for i, base := range bases {
+		if base == nil {
+			return nil, ErrNoFailoverNodes
+		}
+		nodes[i] = NewDefaultURLScheme(base)
+	}
+
+	return &FailoverURLScheme{
+		nodes:     nodes,
+		downUntil: make([]time.Time, len(bases)),
+	}, nil
+}
+
+// FailoverURLScheme is a URLScheme for CAS nodes sharing their ticket registry, for example in several regions.
+//
+// Urls point to the first node which is not marked down, so logins stay pinned to the primary node until it fails.
+// A node marked down is avoided for DownTime, after which it is tried again. When every node is down the primary
+// is used.
+type FailoverURLScheme struct {
+	DownTime time.Duration // How long a node marked down is avoided, defaults to 30 seconds
+
+	nodes []*DefaultURLScheme
+
+	mu        sync.Mutex
+	downUntil []time.Time
+}
+
+// Check that FailoverURLScheme implements URLScheme, the optional endpoints and Failover
+var (
+	_ URLScheme     = &FailoverURLScheme{}
+	_ P3URLScheme   = &FailoverURLScheme{}
+	_ SamlURLScheme = &FailoverURLScheme{}
+	_ Failover      = &FailoverURLScheme{}
+)
+
+// Nodes returns the schemes of the nodes in order, their paths can be customized before use.
+func (scheme *FailoverURLScheme) Nodes() []*DefaultURLScheme {
+	return scheme.nodes
+}
+
+// Node returns the base url of the node serving u.
+func (scheme *FailoverURLScheme) Node(u *url.URL) (*url.URL, bool) {
+	i := scheme.nodeIndex(u)
+	if i < 0 {
+		return nil, false
+	}
+
+	return scheme.nodes[i].base, true
+}
+
+// MarkDown marks the node serving u down for DownTime.
+func (scheme *FailoverURLScheme) MarkDown(u *url.URL) {
+	i := scheme.nodeIndex(u)
+	if i < 0 {
+		return
+	}
+
+	downTime := scheme.DownTime
+	if downTime <= 0 {
+		downTime = defaultDownTime
+	}
+
+	scheme.mu.Lock()
+	scheme.downUntil[i] = time.Now().Add(downTime)
+	scheme.mu.Unlock()
+}
+
+// MarkUp marks the node serving u healthy.
+func (scheme *FailoverURLScheme) MarkUp(u *url.URL) {
+	i := scheme.nodeIndex(u)
+	if i < 0 {
+		return
+	}
+
+	scheme.mu.Lock()
+	scheme.downUntil[i] = time.Time{}
+	scheme.mu.Unlock()
+}
+
+// IsDown indicates whether the node serving u is marked down.
+func (scheme *FailoverURLScheme) IsDown(u *url.URL) bool {
+	i := scheme.nodeIndex(u)
+	if i < 0 {
+		return false
+	}
+
+	scheme.mu.Lock()
+	defer scheme.mu.Unlock()
+	return time.Now().Before(scheme.downUntil[i])
+}
+
+// Rewrite moves u to the preferred healthy node, keeping its path below the base and its query.
+func (scheme *FailoverURLScheme) Rewrite(u *url.URL) (*url.URL, bool) {
+	i := scheme.nodeIndex(u)
+	target := scheme.preferred()
+	if i < 0 || target == scheme.nodes[i] {
+		return nil, false
+	}
+
+	rel := strings.TrimPrefix(u.Path, basePath(scheme.nodes[i].base))
+
+	moved := *target.base
+	moved.Path = path.Join(basePath(target.base), rel)
+	moved.RawPath = ""
+	moved.RawQuery = u.RawQuery
+	moved.Fragment = u.Fragment
+
+	return &moved, true
+}
+
+// preferred returns the first node which is not marked down, or the primary when all are.
+func (scheme *FailoverURLScheme) preferred() *DefaultURLScheme {
+	scheme.mu.Lock()
+	defer scheme.mu.Unlock()
+
+	now := time.Now()
+	for i, until := range scheme.downUntil {
+		if !now.Before(until) {
+			return scheme.nodes[i]
+		}
+	}
+
+	return scheme.nodes[0]
+}
+
+// nodeIndex returns the index of the node with the longest base path containing u, or -1.
+func (scheme *FailoverURLScheme) nodeIndex(u *url.URL) int {
+	index, length := -1, -1
+	for i, node := range scheme.nodes {
+		if !strings.EqualFold(node.base.Scheme, u.Scheme) || !strings.EqualFold(node.base.Host, u.Host) {
+			continue
+		}
+
+		p := basePath(node.base)
+		if p != "/" && u.Path != p && !strings.HasPrefix(u.Path, p+"/") {
+			continue
+		}
+
+		if len(p) > length {
+			index, length = i, len(p)
+		}
+	}
+
+	return index
+}
+
+func basePath(base *url.URL) string {
+	return path.Join("/", base.Path)
+}
+
+// Login returns the url for the cas login page of the primary node, unless it is marked down
+func (scheme *FailoverURLScheme) Login() (*url.URL, error) {
+	return scheme.preferred().Login()
+}
+
+// Logout returns the url for the cas logout page of the preferred node
+func (scheme *FailoverURLScheme) Logout() (*url.URL, error) {
+	return scheme.preferred().Logout()
+}
+
+// Validate returns the url for the request validation endpoint of the preferred node
+func (scheme *FailoverURLScheme) Validate() (*url.URL, error) {
+	return scheme.preferred().Validate()
+}
+
+// ServiceValidate returns the url for the service validation endpoint of the preferred node
+func (scheme *FailoverURLScheme) ServiceValidate() (*url.URL, error) {
+	return scheme.preferred().ServiceValidate()
+}
+
+// P3ServiceValidate returns the url for the cas 3 service validation endpoint of the preferred node
+func (scheme *FailoverURLScheme) P3ServiceValidate() (*url.URL, error) {
+	return scheme.preferred().P3ServiceValidate()
+}
+
+// SamlValidate returns the url for the saml 1.1 validation endpoint of the preferred node
+func (scheme *FailoverURLScheme) SamlValidate() (*url.URL, error) {
+	return scheme.preferred().SamlValidate()
+}
+
+// RestGrantingTicket returns the url for requesting an granting ticket via rest api of the preferred node
+func (scheme *FailoverURLScheme) RestGrantingTicket() (*url.URL, error) {
+	return scheme.preferred().RestGrantingTicket()
+}
+
+// RestServiceTicket returns the url for requesting an service ticket via rest api of the preferred node
+func (scheme *FailoverURLScheme) RestServiceTicket(tgt string) (*url.URL, error) {
+	return scheme.preferred().RestServiceTicket(tgt)
+}
+
+// RestLogout returns the url for destroying an granting ticket via rest api of the preferred node
+func (scheme *FailoverURLScheme) RestLogout(tgt string) (*url.URL, error) {
+	return scheme.preferred().RestLogout(tgt)
+}
+
+// Proxy returns the url for creating a proxy ticket on the preferred node
+func (scheme *FailoverURLScheme) Proxy() (*url.URL, error) {
+	return scheme.preferred().Proxy()
+}
+
+// ProxyValidate returns the url for validating a proxy ticket on the preferred node
+func (scheme *FailoverURLScheme) ProxyValidate() (*url.URL, error) {
+	return scheme.preferred().ProxyValidate()
+}
+
+// P3ProxyValidate returns the url for validating a proxy ticket with the cas 3 protocol on the preferred node
+func (scheme *FailoverURLScheme) P3ProxyValidate() (*url.URL, error) {
+	return scheme.preferred().P3ProxyValidate()
+}
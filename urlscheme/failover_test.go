@@ -0,0 +1,101 @@
+package urlscheme
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestFailoverURLScheme(t *testing.T) {
+	primary, _ := url.Parse("https://eu.cas.org/cas")
+	secondary, _ := url.Parse("https://us.cas.org/")
+	scheme, err := NewFailoverURLScheme(primary, secondary)
+	if err != nil {
+		t.Fatalf("returned error")
+	}
+
+	u, err := scheme.Login()
+	assertNode(t, "https://eu.cas.org/cas/login", u, err)
+
+	validate, err := scheme.P3ServiceValidate()
+	assertNode(t, "https://eu.cas.org/cas/p3/serviceValidate", validate, err)
+	validate.RawQuery = "ticket=ST-1"
+
+	if node, ok := scheme.Node(validate); !ok || node != primary {
+		t.Errorf("expected %v to be served by the primary, got %v", validate, node)
+	}
+
+	if _, ok := scheme.Rewrite(validate); ok {
+		t.Errorf("urls of the preferred node should not be rewritten")
+	}
+
+	scheme.MarkDown(validate)
+	if !scheme.IsDown(primary) {
+		t.Errorf("primary should be down")
+	}
+
+	u, err = scheme.Login()
+	assertNode(t, "https://us.cas.org/login", u, err)
+
+	moved, ok := scheme.Rewrite(validate)
+	if !ok {
+		t.Fatalf("url should be moved to the secondary")
+	}
+	assertNode(t, "https://us.cas.org/p3/serviceValidate?ticket=ST-1", moved, nil)
+
+	// With every node down the primary is used
+	scheme.MarkDown(moved)
+	u, err = scheme.Login()
+	assertNode(t, "https://eu.cas.org/cas/login", u, err)
+
+	scheme.MarkUp(primary)
+	if scheme.IsDown(primary) {
+		t.Errorf("primary should be up")
+	}
+
+	other, _ := url.Parse("https://eu.cas.org/cassandra/login")
+	if _, ok := scheme.Node(other); ok {
+		t.Errorf("%v should not belong to a node", other)
+	}
+}
+
+func TestFailoverURLSchemeDownTime(t *testing.T) {
+	primary, _ := url.Parse("https://eu.cas.org/cas")
+	secondary, _ := url.Parse("https://us.cas.org/cas")
+	scheme, err := NewFailoverURLScheme(primary, secondary)
+	if err != nil {
+		t.Fatalf("returned error")
+	}
+	scheme.DownTime = 10 * time.Millisecond
+
+	scheme.MarkDown(primary)
+	u, err := scheme.Login()
+	assertNode(t, "https://us.cas.org/cas/login", u, err)
+
+	time.Sleep(20 * time.Millisecond)
+	u, err = scheme.Login()
+	assertNode(t, "https://eu.cas.org/cas/login", u, err)
+}
+
+func TestFailoverURLSchemeRequiresNodes(t *testing.T) {
+	primary, _ := url.Parse("https://eu.cas.org/cas")
+
+	if _, err := NewFailoverURLScheme(); err != ErrNoFailoverNodes {
+		t.Errorf("expected ErrNoFailoverNodes without base urls, got %v", err)
+	}
+
+	if _, err := NewFailoverURLScheme(primary, nil); err != ErrNoFailoverNodes {
+		t.Errorf("expected ErrNoFailoverNodes with a nil base url, got %v", err)
+	}
+}
+
+func assertNode(t *testing.T, expected string, u *url.URL, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("returned error")
+	}
+
+	if expected != u.String() {
+		t.Errorf("%s should be equal to %s", u, expected)
+	}
+}
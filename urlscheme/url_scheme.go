@@ -18,35 +18,96 @@ type URLScheme interface {
 	ProxyValidate() (*url.URL, error)
 }
 
+// P3URLScheme is implemented by URLSchemes which create the urls of the cas 3 validation endpoints.
+type P3URLScheme interface {
+	P3ServiceValidate() (*url.URL, error)
+	P3ProxyValidate() (*url.URL, error)
+}
+
+// SamlURLScheme is implemented by URLSchemes which create the url of the saml 1.1 validation endpoint.
+type SamlURLScheme interface {
+	SamlValidate() (*url.URL, error)
+}
+
+// P3ServiceValidate returns the url for the cas 3 service validation endpoint of a URLScheme. URLSchemes which do not
+// implement P3URLScheme use p3/serviceValidate next to the login url.
+func P3ServiceValidate(scheme URLScheme) (*url.URL, error) {
+	if s, ok := scheme.(P3URLScheme); ok {
+		return s.P3ServiceValidate()
+	}
+
+	return siblingURL(scheme, path.Join("p3", "serviceValidate"))
+}
+
+// P3ProxyValidate returns the url for validating a proxy ticket with the cas 3 protocol of a URLScheme. URLSchemes
+// which do not implement P3URLScheme use p3/proxyValidate next to the login url.
+func P3ProxyValidate(scheme URLScheme) (*url.URL, error) {
+	if s, ok := scheme.(P3URLScheme); ok {
+		return s.P3ProxyValidate()
+	}
+
+	return siblingURL(scheme, path.Join("p3", "proxyValidate"))
+}
+
+// SamlValidate returns the url for the saml 1.1 validation endpoint of a URLScheme. URLSchemes which do not implement
+// SamlURLScheme use samlValidate next to the login url.
+func SamlValidate(scheme URLScheme) (*url.URL, error) {
+	if s, ok := scheme.(SamlURLScheme); ok {
+		return s.SamlValidate()
+	}
+
+	return siblingURL(scheme, "samlValidate")
+}
+
+// siblingURL replaces the last path element of the login url.
+func siblingURL(scheme URLScheme, urlPath string) (*url.URL, error) {
+	login, err := scheme.Login()
+	if err != nil {
+		return nil, err
+	}
+
+	return login.Parse(path.Join(path.Dir(path.Join("/", login.Path)), urlPath))
+}
+
 // NewDefaultURLScheme creates a URLScheme which uses the cas default urls
 func NewDefaultURLScheme(base *url.URL) *DefaultURLScheme {
 	return &DefaultURLScheme{
-		base:                base,
-		LoginPath:           "login",
-		LogoutPath:          "logout",
-		ValidatePath:        "validate",
-		ServiceValidatePath: "serviceValidate",
-		RestEndpoint:        path.Join("v1", "tickets"),
-		ProxyValidatePath:   "proxyValidate",
-		ProxyPath:           "proxy",
+		base:                  base,
+		LoginPath:             "login",
+		LogoutPath:            "logout",
+		ValidatePath:          "validate",
+		ServiceValidatePath:   "serviceValidate",
+		P3ServiceValidatePath: path.Join("p3", "serviceValidate"),
+		SamlValidatePath:      "samlValidate",
+		RestEndpoint:          path.Join("v1", "tickets"),
+		ProxyValidatePath:     "proxyValidate",
+		P3ProxyValidatePath:   path.Join("p3", "proxyValidate"),
+		ProxyPath:             "proxy",
 	}
 }
 
 // DefaultURLScheme is a configurable URLScheme. Use NewDefaultURLScheme to create DefaultURLScheme with the default cas
 // urls.
 type DefaultURLScheme struct {
-	base                *url.URL
-	LoginPath           string
-	LogoutPath          string
-	ValidatePath        string
-	ServiceValidatePath string
-	RestEndpoint        string
-	ProxyPath           string
-	ProxyValidatePath   string
+	base                  *url.URL
+	LoginPath             string
+	LogoutPath            string
+	ValidatePath          string
+	ServiceValidatePath   string
+	P3ServiceValidatePath string
+	SamlValidatePath      string
+	RestEndpoint          string
+	ProxyPath             string
+	ProxyValidatePath     string
+	P3ProxyValidatePath   string
 }
 
-// Check that DefaultURLScheme implements URLScheme
-var _ URLScheme = &DefaultURLScheme{}
+// Check that DefaultURLScheme implements URLScheme and the optional endpoints
+var (
+	_ URLScheme     = &DefaultURLScheme{}
+	_ P3URLScheme   = &DefaultURLScheme{}
+	_ SamlURLScheme = &DefaultURLScheme{}
+)
 
 // Login returns the url for the cas login page
 func (scheme *DefaultURLScheme) Login() (*url.URL, error) {
@@ -68,6 +129,16 @@ func (scheme *DefaultURLScheme) ServiceValidate() (*url.URL, error) {
 	return scheme.createURL(scheme.ServiceValidatePath)
 }
 
+// P3ServiceValidate returns the url for the cas 3 service validation endpoint
+func (scheme *DefaultURLScheme) P3ServiceValidate() (*url.URL, error) {
+	return scheme.createURL(scheme.P3ServiceValidatePath)
+}
+
+// SamlValidate returns the url for the saml 1.1 validation endpoint
+func (scheme *DefaultURLScheme) SamlValidate() (*url.URL, error) {
+	return scheme.createURL(scheme.SamlValidatePath)
+}
+
 // RestGrantingTicket returns the url for requesting an granting ticket via rest api
 func (scheme *DefaultURLScheme) RestGrantingTicket() (*url.URL, error) {
 	return scheme.createURL(scheme.RestEndpoint)
@@ -88,6 +159,11 @@ func (scheme *DefaultURLScheme) ProxyValidate() (*url.URL, error) {
 	return scheme.createURL(scheme.ProxyValidatePath)
 }
 
+// P3ProxyValidate returns the url for validating a proxy ticket with the cas 3 protocol
+func (scheme *DefaultURLScheme) P3ProxyValidate() (*url.URL, error) {
+	return scheme.createURL(scheme.P3ProxyValidatePath)
+}
+
 // RestLogout returns the url for destroying an granting ticket via rest api
 func (scheme *DefaultURLScheme) RestLogout(tgt string) (*url.URL, error) {
 	return scheme.createURL(path.Join(scheme.RestEndpoint, tgt))
@@ -17,6 +17,10 @@ func TestDefaultURLScheme(t *testing.T) {
 	assertURL(t, "/cas/validate", u, err)
 	u, err = scheme.ServiceValidate()
 	assertURL(t, "/cas/serviceValidate", u, err)
+	u, err = scheme.P3ServiceValidate()
+	assertURL(t, "/cas/p3/serviceValidate", u, err)
+	u, err = scheme.SamlValidate()
+	assertURL(t, "/cas/samlValidate", u, err)
 	u, err = scheme.RestGrantingTicket()
 	assertURL(t, "/cas/v1/tickets", u, err)
 	u, err = scheme.RestServiceTicket("TGT-123")
@@ -27,6 +31,8 @@ func TestDefaultURLScheme(t *testing.T) {
 	assertURL(t, "/cas/proxy", u, err)
 	u, err = scheme.ProxyValidate()
 	assertURL(t, "/cas/proxyValidate", u, err)
+	u, err = scheme.P3ProxyValidate()
+	assertURL(t, "/cas/p3/proxyValidate", u, err)
 }
 
 func assertURL(t *testing.T, expected string, u *url.URL, err error) {
@@ -38,3 +44,26 @@ func assertURL(t *testing.T, expected string, u *url.URL, err error) {
 		t.Errorf("%s should be equal to %s", u.Path, expected)
 	}
 }
+
+// loginOnlyURLScheme is a custom URLScheme which does not implement the optional endpoints.
+type loginOnlyURLScheme struct {
+	URLScheme
+}
+
+func TestOptionalEndpointsFallBackToLoginURL(t *testing.T) {
+	url, _ := url.Parse("https://cas.org/cas")
+	defaultScheme := NewDefaultURLScheme(url)
+	defaultScheme.LoginPath = "sso/login"
+	scheme := loginOnlyURLScheme{defaultScheme}
+
+	u, err := P3ServiceValidate(scheme)
+	assertURL(t, "/cas/sso/p3/serviceValidate", u, err)
+	u, err = P3ProxyValidate(scheme)
+	assertURL(t, "/cas/sso/p3/proxyValidate", u, err)
+	u, err = SamlValidate(scheme)
+	assertURL(t, "/cas/sso/samlValidate", u, err)
+
+	defaultScheme.P3ServiceValidatePath = "v3/serviceValidate"
+	u, err = P3ServiceValidate(defaultScheme)
+	assertURL(t, "/cas/v3/serviceValidate", u, err)
+}
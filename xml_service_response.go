@@ -2,14 +2,18 @@ package cas
 
 import (
 	"encoding/xml"
+	"io"
+	"strings"
 	"time"
 )
 
 type xmlServiceResponse struct {
 	XMLName xml.Name `xml:"http://www.yale.edu/tp/cas serviceResponse"`
 
-	Failure *xmlAuthenticationFailure
-	Success *xmlAuthenticationSuccess
+	Failure      *xmlAuthenticationFailure
+	Success      *xmlAuthenticationSuccess
+	ProxyFailure *xmlProxyFailure
+	ProxySuccess *xmlProxySuccess
 }
 
 type xmlAuthenticationFailure struct {
@@ -18,6 +22,17 @@ type xmlAuthenticationFailure struct {
 	Message string   `xml:",innerxml"`
 }
 
+type xmlProxyFailure struct {
+	XMLName xml.Name `xml:"proxyFailure"`
+	Code    string   `xml:"code,attr"`
+	Message string   `xml:",innerxml"`
+}
+
+type xmlProxySuccess struct {
+	XMLName     xml.Name `xml:"proxySuccess"`
+	ProxyTicket string   `xml:"proxyTicket"`
+}
+
 type xmlAuthenticationSuccess struct {
 	XMLName             xml.Name           `xml:"authenticationSuccess"`
 	User                string             `xml:"user"`
@@ -46,6 +61,22 @@ type xmlAttributes struct {
 	ExtraAttributes                        []*xmlAnyAttribute `xml:",any"`
 }
 
+// MarshalXML renders the attributes, leaving out a zero authenticationDate.
+func (a xmlAttributes) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type attributes xmlAttributes // without the MarshalXML method
+
+	out := struct {
+		AuthenticationDate *time.Time `xml:"authenticationDate,omitempty"`
+		attributes
+	}{attributes: attributes(a)}
+
+	if !a.AuthenticationDate.IsZero() {
+		out.AuthenticationDate = &a.AuthenticationDate
+	}
+
+	return e.EncodeElement(out, start)
+}
+
 type xmlUserAttributes struct {
 	XMLName       xml.Name             `xml:"userAttributes"`
 	Attributes    []*xmlNamedAttribute `xml:"attribute"`
@@ -93,3 +124,25 @@ func successServiceResponse(username, pgt string) *xmlServiceResponse {
 		},
 	}
 }
+
+// xmlInnerText returns the character data of a field unmarshalled as inner xml, resolving entities and CDATA sections.
+//
+// Malformed inner xml is returned unchanged.
+func xmlInnerText(inner string) string {
+	d := xml.NewDecoder(strings.NewReader(inner))
+
+	var b strings.Builder
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return b.String()
+		}
+		if err != nil {
+			return inner
+		}
+
+		if cd, ok := tok.(xml.CharData); ok {
+			b.Write(cd)
+		}
+	}
+}